@@ -2,6 +2,11 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,59 +14,203 @@ import (
 	"fleet-monitor/ingestion/internal/store"
 )
 
+// RevocationChannel carries digests of revoked keys and InvalidationChannel
+// digests of rotated ones; both are published by the serving layer's admin
+// key endpoints.
+const (
+	RevocationChannel   = "auth:revocations"
+	InvalidationChannel = "auth:invalidations"
+)
+
+// registryNegativeTTL bounds how long an unregistered vehicle stays cached,
+// so a freshly registered truck is accepted without waiting out the full TTL.
+const registryNegativeTTL = 30 * time.Second
+
+var (
+	ErrFleetMismatch        = errors.New("api key is not bound to this fleet")
+	ErrVehicleMismatch      = errors.New("api key is not bound to this vehicle")
+	ErrVehicleNotRegistered = errors.New("vehicle is not registered to this fleet")
+)
+
+// Identity is what an API key is bound to. A key with an empty VehicleID is a
+// fleet gateway key and may post for any active vehicle registered to FleetID.
+type Identity struct {
+	FleetID   string
+	VehicleID string
+
+	secret string
+	digest string
+}
+
+func (id Identity) IsGateway() bool {
+	return id.VehicleID == ""
+}
+
 type cacheEntry struct {
-	vehicleID string
+	identity  Identity
+	expiresAt time.Time
+}
+
+type registryEntry struct {
+	fleetID   string
 	expiresAt time.Time
 }
 
 type Authenticator struct {
-	localCache sync.Map
-	redis      *store.RedisStore
-	ttl        time.Duration
-	staticKeys map[string]bool
+	localCache    sync.Map
+	registryCache sync.Map
+	fleetModes    sync.Map
+	redis         *store.RedisStore
+	tsStore       *store.TimescaleStore
+	ttl           time.Duration
+	skew          time.Duration
+	staticKeys    map[string]Identity
 }
 
-func NewAuthenticator(cfg *config.Config, redis *store.RedisStore) *Authenticator {
-	staticKeys := make(map[string]bool, len(cfg.ValidAPIKeys))
-	for _, k := range cfg.ValidAPIKeys {
-		if k != "" {
-			staticKeys[k] = true
+func NewAuthenticator(cfg *config.Config, redis *store.RedisStore, tsStore *store.TimescaleStore) *Authenticator {
+	staticKeys := make(map[string]Identity, len(cfg.ValidAPIKeys))
+	for _, entry := range cfg.ValidAPIKeys {
+		if entry == "" {
+			continue
+		}
+		// Static keys are fleet gateway keys: "key=fleet_id".
+		key, fleetID, ok := strings.Cut(entry, "=")
+		if !ok || key == "" || fleetID == "" {
+			log.Printf("auth: ignoring static key without a fleet binding (want key=fleet_id)")
+			continue
 		}
+		staticKeys[key] = Identity{FleetID: fleetID}
 	}
 
 	return &Authenticator{
 		redis:      redis,
+		tsStore:    tsStore,
 		ttl:        time.Duration(cfg.AuthCacheTTLSeconds) * time.Second,
+		skew:       time.Duration(cfg.AuthSignatureSkewSeconds) * time.Second,
 		staticKeys: staticKeys,
 	}
 }
 
-func (a *Authenticator) Validate(ctx context.Context, apiKey string) bool {
+// Authenticate resolves an API key to the identity it is bound to.
+func (a *Authenticator) Authenticate(ctx context.Context, apiKey string) (Identity, bool) {
 	// Level 0: static config keys
-	if a.staticKeys[apiKey] {
-		return true
+	if id, ok := a.staticKeys[apiKey]; ok {
+		return id, true
 	}
 
+	// Issued keys are stored and cached by digest; revocations carry only
+	// the digest.
+	digest := HashKey(apiKey)
+
 	// Level 1: in-memory cache
-	if raw, ok := a.localCache.Load(apiKey); ok {
+	if raw, ok := a.localCache.Load(digest); ok {
 		entry := raw.(cacheEntry)
 		if time.Now().Before(entry.expiresAt) {
-			return true
+			return entry.identity, true
 		}
-		a.localCache.Delete(apiKey)
+		a.localCache.Delete(digest)
 	}
 
 	// Level 2: Redis lookup
-	vehicleID, err := a.redis.GetAPIKey(ctx, apiKey)
-	if err != nil || vehicleID == "" {
-		return false
+	rec, err := a.redis.GetAPIKey(ctx, digest)
+	if err != nil || rec.FleetID == "" {
+		return Identity{}, false
+	}
+	id := Identity{FleetID: rec.FleetID, VehicleID: rec.VehicleID, secret: rec.Secret, digest: digest}
+
+	// A rotated key stops working at its expiry, not at the end of the TTL.
+	expiresAt := time.Now().Add(a.ttl)
+	if !rec.ExpiresAt.IsZero() {
+		if !time.Now().Before(rec.ExpiresAt) {
+			return Identity{}, false
+		}
+		if rec.ExpiresAt.Before(expiresAt) {
+			expiresAt = rec.ExpiresAt
+		}
 	}
 
 	// Populate in-memory cache
-	a.localCache.Store(apiKey, cacheEntry{
-		vehicleID: vehicleID,
-		expiresAt: time.Now().Add(a.ttl),
+	a.localCache.Store(digest, cacheEntry{
+		identity:  id,
+		expiresAt: expiresAt,
 	})
 
-	return true
+	return id, true
+}
+
+// WatchRevocations evicts revoked and rotated keys from the local cache as
+// soon as the serving layer's key store announces them. It blocks until ctx
+// is cancelled.
+func (a *Authenticator) WatchRevocations(ctx context.Context) {
+	sub := a.redis.Client().Subscribe(ctx, RevocationChannel, InvalidationChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			a.localCache.Delete(msg.Payload)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// HashKey is the digest under which an issued API key is stored in Redis.
+func HashKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authorize checks that a payload's vehicle and fleet match the identity of
+// the key that sent it.
+func (a *Authenticator) Authorize(ctx context.Context, id Identity, vehicleID, fleetID string) error {
+	if fleetID != id.FleetID {
+		return ErrFleetMismatch
+	}
+	if !id.IsGateway() {
+		if vehicleID != id.VehicleID {
+			return ErrVehicleMismatch
+		}
+		return nil
+	}
+
+	registeredFleet, err := a.vehicleFleet(ctx, vehicleID)
+	if err != nil {
+		return err
+	}
+	if registeredFleet != fleetID {
+		return ErrVehicleNotRegistered
+	}
+	return nil
+}
+
+// vehicleFleet returns the fleet a vehicle is registered to, or "" if it is
+// unknown or retired. Both outcomes are cached.
+func (a *Authenticator) vehicleFleet(ctx context.Context, vehicleID string) (string, error) {
+	if raw, ok := a.registryCache.Load(vehicleID); ok {
+		entry := raw.(registryEntry)
+		if time.Now().Before(entry.expiresAt) {
+			return entry.fleetID, nil
+		}
+		a.registryCache.Delete(vehicleID)
+	}
+
+	fleetID, err := a.tsStore.VehicleFleet(ctx, vehicleID)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := a.ttl
+	if fleetID == "" {
+		ttl = registryNegativeTTL
+	}
+	a.registryCache.Store(vehicleID, registryEntry{
+		fleetID:   fleetID,
+		expiresAt: time.Now().Add(ttl),
+	})
+	return fleetID, nil
 }
@@ -38,32 +38,42 @@ type Config struct {
 	AlertWorkers       int
 
 	// Auth
-	AuthCacheTTLSeconds int
-	ValidAPIKeys        []string
+	AuthCacheTTLSeconds      int
+	ValidAPIKeys             []string
+	AuthSignatureSkewSeconds int
+
+	// Tracing
+	TracingExporter     string
+	TracingOTLPEndpoint string
+	TracingSampleRatio  float64
 }
 
 func Load() *Config {
 	return &Config{
-		HTTPPort:            getEnv("HTTP_PORT", "8001"),
-		DBHost:              getEnv("DB_HOST", "localhost"),
-		DBPort:              getEnv("DB_PORT", "5432"),
-		DBUser:              getEnv("DB_USER", "fleet_user"),
-		DBPassword:          getEnv("DB_PASSWORD", "fleet_password"),
-		DBName:              getEnv("DB_NAME", "fleet_monitor"),
-		DBMaxConns:          int32(getEnvInt("DB_MAX_CONNS", 15)),
-		RedisAddr:           getEnv("REDIS_ADDR", "localhost:6379"),
-		RedisPassword:       getEnv("REDIS_PASSWORD", ""),
-		RedisDB:             getEnvInt("REDIS_DB", 0),
-		DBChannelSize:       getEnvInt("DB_CHANNEL_SIZE", 10000),
-		StateChannelSize:    getEnvInt("STATE_CHANNEL_SIZE", 50000),
-		AlertChannelSize:    getEnvInt("ALERT_CHANNEL_SIZE", 10000),
-		DBBatchSize:         getEnvInt("DB_BATCH_SIZE", 500),
-		DBFlushIntervalMS:   getEnvInt("DB_FLUSH_INTERVAL_MS", 100),
-		DBWriterWorkers:     getEnvInt("DB_WRITER_WORKERS", 10),
-		StateWriterWorkers:  getEnvInt("STATE_WRITER_WORKERS", 5),
-		AlertWorkers:        getEnvInt("ALERT_WORKERS", 3),
-		AuthCacheTTLSeconds: getEnvInt("AUTH_CACHE_TTL_SECONDS", 300),
-		ValidAPIKeys:        strings.Split(getEnv("VALID_API_KEYS", ""), ","),
+		HTTPPort:                 getEnv("HTTP_PORT", "8001"),
+		DBHost:                   getEnv("DB_HOST", "localhost"),
+		DBPort:                   getEnv("DB_PORT", "5432"),
+		DBUser:                   getEnv("DB_USER", "fleet_user"),
+		DBPassword:               getEnv("DB_PASSWORD", "fleet_password"),
+		DBName:                   getEnv("DB_NAME", "fleet_monitor"),
+		DBMaxConns:               int32(getEnvInt("DB_MAX_CONNS", 15)),
+		RedisAddr:                getEnv("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:            getEnv("REDIS_PASSWORD", ""),
+		RedisDB:                  getEnvInt("REDIS_DB", 0),
+		DBChannelSize:            getEnvInt("DB_CHANNEL_SIZE", 10000),
+		StateChannelSize:         getEnvInt("STATE_CHANNEL_SIZE", 50000),
+		AlertChannelSize:         getEnvInt("ALERT_CHANNEL_SIZE", 10000),
+		DBBatchSize:              getEnvInt("DB_BATCH_SIZE", 500),
+		DBFlushIntervalMS:        getEnvInt("DB_FLUSH_INTERVAL_MS", 100),
+		DBWriterWorkers:          getEnvInt("DB_WRITER_WORKERS", 10),
+		StateWriterWorkers:       getEnvInt("STATE_WRITER_WORKERS", 5),
+		AlertWorkers:             getEnvInt("ALERT_WORKERS", 3),
+		AuthCacheTTLSeconds:      getEnvInt("AUTH_CACHE_TTL_SECONDS", 300),
+		ValidAPIKeys:             strings.Split(getEnv("VALID_API_KEYS", ""), ","),
+		AuthSignatureSkewSeconds: getEnvInt("AUTH_SIGNATURE_SKEW_SECONDS", 300),
+		TracingExporter:          getEnv("OTEL_TRACES_EXPORTER", "none"),
+		TracingOTLPEndpoint:      getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "localhost:4318"),
+		TracingSampleRatio:       getEnvFloat("OTEL_TRACES_SAMPLE_RATIO", 0.1),
 	}
 }
 
@@ -85,3 +95,15 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return n
 }
+
+func getEnvFloat(key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fallback
+	}
+	return f
+}
@@ -1,6 +1,10 @@
 package domain
 
-import "time"
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
 
 type TelemetryMessage struct {
 	ReceivedAt time.Time
@@ -21,6 +25,10 @@ type TelemetryMessage struct {
 	EngineOn       bool
 
 	RawPayload []byte
+
+	// SpanContext of the request that produced this message. The pipeline
+	// workers read it off the channel to parent (or link) their own spans.
+	SpanContext trace.SpanContext
 }
 
 type AlertType string
@@ -1,26 +1,129 @@
 package metrics
 
 import (
-	"fmt"
 	"net/http"
-	"sync/atomic"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Pipeline counters — names predate the Prometheus client and are kept so
+// existing dashboards and the k6 reports keep working.
 var (
-	MessagesReceived  atomic.Int64
-	DBWriteSuccess    atomic.Int64
-	DBWriteFailures   atomic.Int64
-	DBChannelDrops    atomic.Int64
-	StateChannelDrops atomic.Int64
-	AlertChannelDrops atomic.Int64
+	MessagesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ingestion_messages_received_total",
+		Help: "Telemetry messages accepted by the HTTP handler.",
+	})
+	DBWriteSuccess = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ingestion_db_write_success_total",
+		Help: "Telemetry rows written to TimescaleDB.",
+	})
+	DBWriteFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ingestion_db_write_failures_total",
+		Help: "Telemetry rows lost after the CopyFrom retry also failed.",
+	})
+	DBChannelDrops = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ingestion_db_channel_drops_total",
+		Help: "Messages dropped because DBChan was full.",
+	})
+	StateChannelDrops = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ingestion_state_channel_drops_total",
+		Help: "Messages dropped because StateChan was full.",
+	})
+	AlertChannelDrops = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ingestion_alert_channel_drops_total",
+		Help: "Messages dropped because AlertChan was full.",
+	})
+)
+
+// Latency and shape of the pipeline stages.
+var (
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ingestion_http_request_duration_seconds",
+		Help:    "HTTP request latency by route pattern and status code.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"route", "method", "status"})
+
+	CopyFromBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ingestion_db_copy_batch_size",
+		Help:    "Rows per CopyFrom batch.",
+		Buckets: []float64{1, 10, 50, 100, 250, 500, 1000, 2500},
+	})
+	CopyFromDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ingestion_db_copy_duration_seconds",
+		Help:    "CopyFrom latency per attempt.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"result"})
+
+	RedisPipelineDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ingestion_redis_pipeline_duration_seconds",
+		Help:    "Redis pipeline round-trip latency.",
+		Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1},
+	}, []string{"op", "result"})
+
+	AlertsFired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ingestion_alerts_fired_total",
+		Help: "Alerts inserted after dedup, by type and fleet.",
+	}, []string{"alert_type", "fleet_id"})
 )
 
+// RegisterChannelDepth exposes the current length of a pipeline channel as
+// ingestion_channel_depth{channel=name}. depth is called on every scrape.
+func RegisterChannelDepth(name string, capacity int, depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "ingestion_channel_depth",
+		Help:        "Messages currently buffered in a pipeline channel.",
+		ConstLabels: prometheus.Labels{"channel": name},
+	}, func() float64 { return float64(depth()) })
+	promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        "ingestion_channel_capacity",
+		Help:        "Buffer size of a pipeline channel.",
+		ConstLabels: prometheus.Labels{"channel": name},
+	}).Set(float64(capacity))
+}
+
+// Result labels a latency observation by outcome.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// Instrument records HTTP request duration. It must wrap the ServeMux so that
+// r.Pattern has been filled in by the time the handler returns; requests that
+// match no route are reported as "unmatched".
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequestDuration.
+			WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+var exposition = promhttp.Handler()
+
 func HandleMetrics(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
-	fmt.Fprintf(w, "ingestion_messages_received_total %d\n", MessagesReceived.Load())
-	fmt.Fprintf(w, "ingestion_db_write_success_total %d\n", DBWriteSuccess.Load())
-	fmt.Fprintf(w, "ingestion_db_write_failures_total %d\n", DBWriteFailures.Load())
-	fmt.Fprintf(w, "ingestion_db_channel_drops_total %d\n", DBChannelDrops.Load())
-	fmt.Fprintf(w, "ingestion_state_channel_drops_total %d\n", StateChannelDrops.Load())
-	fmt.Fprintf(w, "ingestion_alert_channel_drops_total %d\n", AlertChannelDrops.Load())
+	exposition.ServeHTTP(w, r)
 }
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"fleet-monitor/ingestion/internal/domain"
+	"fleet-monitor/ingestion/internal/metrics"
 	"fleet-monitor/ingestion/internal/store"
 )
 
@@ -67,6 +68,7 @@ func (e *AlertEvaluator) evaluate(ctx context.Context, msg *domain.TelemetryMess
 			fmt.Printf("Alert insert failed for %s: %v\n", msg.VehicleID, err)
 			continue
 		}
+		metrics.AlertsFired.WithLabelValues(string(rule.Type), msg.FleetID).Inc()
 
 		if err := e.redis.SetAlertDedup(ctx, msg.VehicleID, rule.Type); err != nil {
 			fmt.Printf("Alert dedup set failed for %s: %v\n", msg.VehicleID, err)
@@ -80,7 +82,9 @@ func (e *AlertEvaluator) evaluate(ctx context.Context, msg *domain.TelemetryMess
 			"value":        triggerValue,
 			"triggered_at": time.Now().Unix(),
 		})
-		e.redis.PublishAlert(ctx, msg.FleetID, alertPayload)
+		if _, err := e.redis.PublishAlert(ctx, msg.FleetID, msg.VehicleID, rule.Severity, alertPayload); err != nil {
+			fmt.Printf("Alert publish failed for %s: %v\n", msg.VehicleID, err)
+		}
 	}
 }
 
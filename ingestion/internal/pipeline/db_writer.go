@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
 	"fleet-monitor/ingestion/internal/domain"
 	"fleet-monitor/ingestion/internal/metrics"
 	"fleet-monitor/ingestion/internal/store"
+	"fleet-monitor/ingestion/internal/tracing"
 )
 
 type DBWriter struct {
@@ -67,16 +72,41 @@ func (w *DBWriter) Run(ctx context.Context) {
 }
 
 func (w *DBWriter) flush(ctx context.Context, batch []*domain.TelemetryMessage) {
-	err := w.db.BatchInsert(ctx, batch)
+	// One batch serves many requests, so the span links to each message's
+	// trace rather than picking one as parent.
+	links := make([]trace.Link, 0, len(batch))
+	for _, msg := range batch {
+		if msg.SpanContext.IsValid() {
+			links = append(links, trace.Link{SpanContext: msg.SpanContext})
+		}
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "DBWriter.flush",
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("db.batch_size", len(batch))),
+	)
+	defer span.End()
+
+	metrics.CopyFromBatchSize.Observe(float64(len(batch)))
+
+	err := w.insert(ctx, batch)
 	if err != nil {
 		fmt.Printf("DB write failed (batch=%d), retrying: %v\n", len(batch), err)
 		time.Sleep(500 * time.Millisecond)
-		err = w.db.BatchInsert(ctx, batch)
+		err = w.insert(ctx, batch)
 		if err != nil {
 			fmt.Printf("DB write permanently failed (batch=%d): %v\n", len(batch), err)
-			metrics.DBWriteFailures.Add(int64(len(batch)))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "CopyFrom failed after retry")
+			metrics.DBWriteFailures.Add(float64(len(batch)))
 			return
 		}
 	}
-	metrics.DBWriteSuccess.Add(int64(len(batch)))
+	metrics.DBWriteSuccess.Add(float64(len(batch)))
+}
+
+func (w *DBWriter) insert(ctx context.Context, batch []*domain.TelemetryMessage) error {
+	start := time.Now()
+	err := w.db.BatchInsert(ctx, batch)
+	metrics.CopyFromDuration.WithLabelValues(metrics.Result(err)).Observe(time.Since(start).Seconds())
+	return err
 }
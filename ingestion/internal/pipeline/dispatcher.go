@@ -1,8 +1,14 @@
 package pipeline
 
 import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	"fleet-monitor/ingestion/internal/domain"
 	"fleet-monitor/ingestion/internal/metrics"
+	"fleet-monitor/ingestion/internal/tracing"
 )
 
 type Dispatcher struct {
@@ -12,29 +18,41 @@ type Dispatcher struct {
 }
 
 func NewDispatcher(dbSize, stateSize, alertSize int) *Dispatcher {
-	return &Dispatcher{
+	d := &Dispatcher{
 		DBChan:    make(chan *domain.TelemetryMessage, dbSize),
 		StateChan: make(chan *domain.TelemetryMessage, stateSize),
 		AlertChan: make(chan *domain.TelemetryMessage, alertSize),
 	}
+
+	metrics.RegisterChannelDepth("db", dbSize, func() int { return len(d.DBChan) })
+	metrics.RegisterChannelDepth("state", stateSize, func() int { return len(d.StateChan) })
+	metrics.RegisterChannelDepth("alert", alertSize, func() int { return len(d.AlertChan) })
+
+	return d
 }
 
-func (d *Dispatcher) Dispatch(msg *domain.TelemetryMessage) {
+func (d *Dispatcher) Dispatch(ctx context.Context, msg *domain.TelemetryMessage) {
+	_, span := tracing.Tracer().Start(ctx, "Dispatcher.Dispatch")
+	defer span.End()
+
 	select {
 	case d.DBChan <- msg:
 	default:
-		metrics.DBChannelDrops.Add(1)
+		metrics.DBChannelDrops.Inc()
+		span.AddEvent("dropped", trace.WithAttributes(attribute.String("channel", "db")))
 	}
 
 	select {
 	case d.StateChan <- msg:
 	default:
-		metrics.StateChannelDrops.Add(1)
+		metrics.StateChannelDrops.Inc()
+		span.AddEvent("dropped", trace.WithAttributes(attribute.String("channel", "state")))
 	}
 
 	select {
 	case d.AlertChan <- msg:
 	default:
-		metrics.AlertChannelDrops.Add(1)
+		metrics.AlertChannelDrops.Inc()
+		span.AddEvent("dropped", trace.WithAttributes(attribute.String("channel", "alert")))
 	}
 }
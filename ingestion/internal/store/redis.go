@@ -4,14 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"fleet-monitor/ingestion/internal/config"
 	"fleet-monitor/ingestion/internal/domain"
+	"fleet-monitor/ingestion/internal/metrics"
+	"fleet-monitor/ingestion/internal/tracing"
 )
 
+// lastKnownTTL keeps a vehicle's last report long after its 30s state hash
+// has expired, so the live map can place vehicles that are offline. It only
+// lapses for vehicles that have been silent for a month.
+const lastKnownTTL = 30 * 24 * time.Hour
+
 type RedisStore struct {
 	client *redis.Client
 }
@@ -45,6 +55,11 @@ func (r *RedisStore) Client() *redis.Client {
 }
 
 func (r *RedisStore) PipelineStateUpdate(ctx context.Context, msg *domain.TelemetryMessage) error {
+	ctx = trace.ContextWithSpanContext(ctx, msg.SpanContext)
+	ctx, span := tracing.Tracer().Start(ctx, "PipelineStateUpdate",
+		trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+
 	stateData := map[string]interface{}{
 		"vehicle_id":  msg.VehicleID,
 		"fleet_id":    msg.FleetID,
@@ -60,38 +75,101 @@ func (r *RedisStore) PipelineStateUpdate(ctx context.Context, msg *domain.Teleme
 		"received_at": msg.ReceivedAt.Unix(),
 	}
 
-	pubPayload, err := json.Marshal(stateData)
+	// The pub/sub payload is the state hash plus what only subscribers need:
+	// a millisecond device timestamp and the trace context, so the serving
+	// layer can continue this trace through to the WebSocket write.
+	pubData := make(map[string]interface{}, len(stateData)+2)
+	for k, v := range stateData {
+		pubData[k] = v
+	}
+	pubData["timestamp_ms"] = msg.Timestamp.UnixMilli()
+	pubData["trace"] = tracing.Inject(ctx)
+
+	pubPayload, err := json.Marshal(pubData)
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
 
 	vehicleStateKey := fmt.Sprintf("vehicle:%s:state", msg.VehicleID)
 	pubChannel := fmt.Sprintf("fleet:%s:telemetry", msg.FleetID)
+	// fleet:{id}:geo — GEO set of each vehicle's latest position for nearby
+	// search. Members outlive the state hash; the serving layer drops those
+	// whose state has expired.
+	geoKey := fmt.Sprintf("fleet:%s:geo", msg.FleetID)
+	// fleet:{id}:vehicles — SET of vehicles that have reported, so the
+	// serving layer reads a fleet's state hashes without a keyspace SCAN.
+	// Pruned there the same way as the GEO set.
+	fleetVehiclesKey := fmt.Sprintf("fleet:%s:vehicles", msg.FleetID)
+	// vehicle:{id}:last_known — the same hash without the short TTL.
+	lastKnownKey := fmt.Sprintf("vehicle:%s:last_known", msg.VehicleID)
 
 	pipe := r.client.Pipeline()
 
 	pipe.HSet(ctx, vehicleStateKey, stateData)
 	pipe.Expire(ctx, vehicleStateKey, 30*time.Second)
+	pipe.HSet(ctx, lastKnownKey, stateData)
+	pipe.Expire(ctx, lastKnownKey, lastKnownTTL)
+	pipe.GeoAdd(ctx, geoKey, &redis.GeoLocation{
+		Name:      msg.VehicleID,
+		Longitude: msg.Longitude,
+		Latitude:  msg.Latitude,
+	})
+	pipe.SAdd(ctx, fleetVehiclesKey, msg.VehicleID)
 	pipe.Publish(ctx, pubChannel, pubPayload)
 
+	start := time.Now()
 	_, err = pipe.Exec(ctx)
+	metrics.RedisPipelineDuration.
+		WithLabelValues("state_update", metrics.Result(err)).
+		Observe(time.Since(start).Seconds())
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "redis pipeline failed")
 		return fmt.Errorf("redis pipeline failed: %w", err)
 	}
 
 	return nil
 }
 
-func (r *RedisStore) GetAPIKey(ctx context.Context, apiKey string) (string, error) {
-	key := fmt.Sprintf("vehicle:auth:%s", apiKey)
-	val, err := r.client.Get(ctx, key).Result()
-	if err == redis.Nil {
-		return "", nil
+// APIKeyRecord is the hash stored at vehicle:auth:{sha256(key)}. An empty
+// VehicleID marks a fleet gateway key; Secret is the device's HMAC signing
+// secret and is empty for keys that only use plain mode. ExpiresAt is set on
+// keys that have been rotated out.
+type APIKeyRecord struct {
+	FleetID   string
+	VehicleID string
+	Secret    string
+	ExpiresAt time.Time
+}
+
+// GetAPIKey returns the record stored under a key digest. A missing key
+// returns an empty record and no error.
+func (r *RedisStore) GetAPIKey(ctx context.Context, digest string) (APIKeyRecord, error) {
+	key := fmt.Sprintf("vehicle:auth:%s", digest)
+	vals, err := r.client.HMGet(ctx, key, "fleet_id", "vehicle_id", "secret", "expires_at").Result()
+	if err != nil {
+		return APIKeyRecord{}, fmt.Errorf("redis get api key failed: %w", err)
+	}
+	var rec APIKeyRecord
+	rec.FleetID, _ = vals[0].(string)
+	rec.VehicleID, _ = vals[1].(string)
+	rec.Secret, _ = vals[2].(string)
+	if v, _ := vals[3].(string); v != "" {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		rec.ExpiresAt = time.Unix(n, 0)
 	}
+	return rec, nil
+}
+
+// ClaimNonce records a request nonce for ttl. It returns false if the nonce
+// was already seen, i.e. the request is a replay.
+func (r *RedisStore) ClaimNonce(ctx context.Context, digest, nonce string, ttl time.Duration) (bool, error) {
+	key := fmt.Sprintf("auth:nonce:%s:%s", digest, nonce)
+	ok, err := r.client.SetNX(ctx, key, 1, ttl).Result()
 	if err != nil {
-		return "", fmt.Errorf("redis get api key failed: %w", err)
+		return false, fmt.Errorf("redis claim nonce failed: %w", err)
 	}
-	return val, nil
+	return ok, nil
 }
 
 func (r *RedisStore) CheckAlertDedup(ctx context.Context, vehicleID string, alertType domain.AlertType) (bool, error) {
@@ -108,7 +186,30 @@ func (r *RedisStore) SetAlertDedup(ctx context.Context, vehicleID string, alertT
 	return r.client.Set(ctx, key, "1", 5*time.Minute).Err()
 }
 
-func (r *RedisStore) PublishAlert(ctx context.Context, fleetID string, payload []byte) error {
-	channel := fmt.Sprintf("fleet:%s:alerts", fleetID)
-	return r.client.Publish(ctx, channel, payload).Err()
+// Fleet event stream — alerts and other discrete events (not positions) get a
+// per-fleet sequence number and are kept in a capped Redis Stream so WebSocket
+// clients can resume after a disconnect. The serving module appends to the
+// same stream with the same script; keep the two in step.
+const eventStreamMaxLen = 10000
+
+var appendEventScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[2], '0-' .. seq,
+	'type', ARGV[3], 'vehicle_id', ARGV[4], 'severity', ARGV[5], 'payload', ARGV[6])
+redis.call('PUBLISH', ARGV[1], cjson.encode({
+	seq = seq, type = ARGV[3], vehicle_id = ARGV[4], severity = ARGV[5], payload = ARGV[6]}))
+return seq
+`)
+
+// PublishAlert appends the alert to the fleet's event stream and publishes it
+// on fleet:{id}:events, returning its sequence number.
+func (r *RedisStore) PublishAlert(ctx context.Context, fleetID, vehicleID string, severity domain.AlertSeverity, payload []byte) (int64, error) {
+	keys := []string{
+		fmt.Sprintf("fleet:%s:seq", fleetID),
+		fmt.Sprintf("fleet:%s:events", fleetID),
+	}
+	return appendEventScript.Run(ctx, r.client, keys,
+		fmt.Sprintf("fleet:%s:events", fleetID), eventStreamMaxLen,
+		"vehicle.alert", vehicleID, string(severity), payload,
+	).Int64()
 }
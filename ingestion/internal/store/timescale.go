@@ -126,3 +126,36 @@ func (s *TimescaleStore) InsertAlert(
 	)
 	return err
 }
+
+// VehicleFleet returns the fleet an active vehicle is registered to, or "" if
+// the vehicle is unknown or retired.
+func (s *TimescaleStore) VehicleFleet(ctx context.Context, vehicleID string) (string, error) {
+	var fleetID string
+	err := s.pool.QueryRow(ctx, `
+		SELECT fleet_id FROM vehicle_registry
+		WHERE vehicle_id = $1 AND active
+	`, vehicleID).Scan(&fleetID)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("vehicle registry lookup failed: %w", err)
+	}
+	return fleetID, nil
+}
+
+// FleetRequiresSigning reports whether a fleet has switched off plain-key
+// telemetry. Fleets without a fleet_config row default to plain mode.
+func (s *TimescaleStore) FleetRequiresSigning(ctx context.Context, fleetID string) (bool, error) {
+	var required bool
+	err := s.pool.QueryRow(ctx, `
+		SELECT require_signed_telemetry FROM fleet_config WHERE fleet_id = $1
+	`, fleetID).Scan(&required)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("fleet config lookup failed: %w", err)
+	}
+	return required, nil
+}
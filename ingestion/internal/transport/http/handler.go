@@ -2,12 +2,20 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"fleet-monitor/ingestion/internal/auth"
 	"fleet-monitor/ingestion/internal/domain"
 	"fleet-monitor/ingestion/internal/metrics"
 	"fleet-monitor/ingestion/internal/pipeline"
+	"fleet-monitor/ingestion/internal/tracing"
 )
 
 type incomingPayload struct {
@@ -31,18 +39,26 @@ type incomingPayload struct {
 
 type TelemetryHandler struct {
 	dispatcher *pipeline.Dispatcher
+	auth       *auth.Authenticator
 }
 
-func NewTelemetryHandler(d *pipeline.Dispatcher) *TelemetryHandler {
-	return &TelemetryHandler{dispatcher: d}
+func NewTelemetryHandler(d *pipeline.Dispatcher, a *auth.Authenticator) *TelemetryHandler {
+	return &TelemetryHandler{dispatcher: d, auth: a}
 }
 
 func (h *TelemetryHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	// Devices that send a traceparent header get their trace continued.
+	ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracing.Tracer().Start(ctx, "TelemetryHandler.Handle",
+		trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	var p incomingPayload
 	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(`{"error":"invalid json payload"}`))
+		span.SetStatus(codes.Error, "invalid json payload")
 		return
 	}
 
@@ -50,6 +66,30 @@ func (h *TelemetryHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(`{"error":"vehicle_id and fleet_id are required"}`))
+		span.SetStatus(codes.Error, "missing identity")
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("vehicle.id", p.VehicleID),
+		attribute.String("fleet.id", p.FleetID),
+	)
+
+	// The key must be bound to this vehicle, or be a gateway key for the
+	// fleet the vehicle is registered to.
+	if err := h.auth.Authorize(ctx, identityFromContext(r.Context()), p.VehicleID, p.FleetID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case errors.Is(err, auth.ErrFleetMismatch),
+			errors.Is(err, auth.ErrVehicleMismatch),
+			errors.Is(err, auth.ErrVehicleNotRegistered):
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"vehicle registry unavailable"}`))
+		}
+		span.SetStatus(codes.Error, err.Error())
 		return
 	}
 
@@ -70,10 +110,11 @@ func (h *TelemetryHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		IsMoving:       p.VehicleState.IsMoving,
 		EngineOn:       p.VehicleState.EngineOn,
 		RawPayload:     raw,
+		SpanContext:    span.SpanContext(),
 	}
 
-	h.dispatcher.Dispatch(msg)
-	metrics.MessagesReceived.Add(1)
+	h.dispatcher.Dispatch(ctx, msg)
+	metrics.MessagesReceived.Inc()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
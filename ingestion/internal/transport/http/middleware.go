@@ -1,11 +1,29 @@
 package http
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 
 	"fleet-monitor/ingestion/internal/auth"
 )
 
+// maxSignedBodyBytes caps how much of a signed request is buffered for HMAC
+// verification. Telemetry payloads are well under 1 KiB.
+const maxSignedBodyBytes = 64 << 10
+
+type identityKey struct{}
+
+// identityFromContext returns the identity of the key that authenticated the
+// request. Only valid behind AuthMiddleware.
+func identityFromContext(ctx context.Context) auth.Identity {
+	id, _ := ctx.Value(identityKey{}).(auth.Identity)
+	return id
+}
+
 type AuthMiddleware struct {
 	auth *auth.Authenticator
 }
@@ -24,13 +42,61 @@ func (m *AuthMiddleware) Wrap(next http.Handler) http.Handler {
 			return
 		}
 
-		if !m.auth.Validate(r.Context(), apiKey) {
+		id, ok := m.auth.Authenticate(r.Context(), apiKey)
+		if !ok {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte(`{"error":"invalid API key"}`))
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// A request carrying a signature is always verified. Unsigned requests
+		// are accepted only while the key's fleet still allows plain mode.
+		if sig := r.Header.Get(auth.HeaderSignature); sig != "" {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodyBytes+1))
+			if err != nil || len(body) > maxSignedBodyBytes {
+				writeAuthError(w, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			err = m.auth.VerifySignature(r.Context(), id,
+				r.Header.Get(auth.HeaderTimestamp), r.Header.Get(auth.HeaderNonce), sig, body)
+			if err != nil {
+				writeSignatureError(w, err)
+				return
+			}
+		} else {
+			required, err := m.auth.SigningRequired(r.Context(), id.FleetID)
+			if err != nil {
+				writeAuthError(w, http.StatusServiceUnavailable, "auth backend unavailable")
+				return
+			}
+			if required {
+				writeAuthError(w, http.StatusUnauthorized, auth.ErrSignatureRequired.Error())
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
 	})
 }
+
+func writeSignatureError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrNoSigningSecret),
+		errors.Is(err, auth.ErrSignatureMalformed),
+		errors.Is(err, auth.ErrSignatureExpired),
+		errors.Is(err, auth.ErrSignatureInvalid),
+		errors.Is(err, auth.ErrNonceReused):
+		writeAuthError(w, http.StatusUnauthorized, err.Error())
+	default:
+		writeAuthError(w, http.StatusServiceUnavailable, "auth backend unavailable")
+	}
+}
+
+func writeAuthError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
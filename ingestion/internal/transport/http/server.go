@@ -23,7 +23,7 @@ func NewServer(
 	tsStore *store.TimescaleStore,
 	redisStore *store.RedisStore,
 ) *Server {
-	telemetryHandler := NewTelemetryHandler(dispatcher)
+	telemetryHandler := NewTelemetryHandler(dispatcher, authenticator)
 	healthHandler := NewHealthHandler(tsStore, redisStore)
 	authMiddleware := NewAuthMiddleware(authenticator)
 
@@ -39,7 +39,7 @@ func NewServer(
 	return &Server{
 		httpServer: &http.Server{
 			Addr:    ":" + cfg.HTTPPort,
-			Handler: mux,
+			Handler: metrics.Instrument(mux),
 		},
 	}
 }
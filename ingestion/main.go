@@ -14,6 +14,7 @@ import (
 	"fleet-monitor/ingestion/internal/config"
 	"fleet-monitor/ingestion/internal/pipeline"
 	"fleet-monitor/ingestion/internal/store"
+	"fleet-monitor/ingestion/internal/tracing"
 	transport "fleet-monitor/ingestion/internal/transport/http"
 )
 
@@ -25,6 +26,17 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		ServiceName:  "fleet-ingestion",
+		Exporter:     cfg.TracingExporter,
+		OTLPEndpoint: cfg.TracingOTLPEndpoint,
+		SampleRatio:  cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("Tracing: %v", err)
+	}
+	fmt.Printf("✓ Tracing ready (exporter=%s)\n", cfg.TracingExporter)
+
 	tsStore, err := store.NewTimescaleStore(ctx, cfg)
 	if err != nil {
 		log.Fatalf("TimescaleDB: %v", err)
@@ -39,7 +51,8 @@ func main() {
 	defer redisStore.Close()
 	fmt.Println("✓ Redis connected")
 
-	authenticator := auth.NewAuthenticator(cfg, redisStore)
+	authenticator := auth.NewAuthenticator(cfg, redisStore, tsStore)
+	go authenticator.WatchRevocations(ctx)
 	fmt.Println("✓ Authenticator ready")
 
 	dispatcher := pipeline.NewDispatcher(
@@ -81,6 +94,7 @@ func main() {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	server.Shutdown(shutdownCtx)
+	shutdownTracing(shutdownCtx)
 
 	time.Sleep(2 * time.Second)
 	fmt.Println("Done.")
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/joho/godotenv"
@@ -41,7 +42,16 @@ func main() {
 	step3_alerts_table(ctx, conn)
 	step4_registry_tables(ctx, conn)
 	step5_indexes(ctx, conn)
-	step6_verify(ctx, conn)
+	step6_continuous_aggregates(ctx, conn)
+	step7_storage_policies(ctx, conn)
+	step8_archive_catalog(ctx, conn)
+	step9_operator_accounts(ctx, conn)
+	step10_job_leases(ctx, conn)
+	step11_job_runs(ctx, conn)
+	step12_segment_speeds(ctx, conn)
+	step13_arrival_windows(ctx, conn)
+	step14_unplanned_stops(ctx, conn)
+	step15_verify(ctx, conn)
 
 	fmt.Println("\n Database initialised successfully")
 	fmt.Println("   Run next: go run scripts/seed_redis.go")
@@ -64,6 +74,12 @@ func step1_extensions(ctx context.Context, conn *pgx.Conn) {
 		"CREATE EXTENSION IF NOT EXISTS postgis;",
 		"postgis extension",
 	)
+
+	// pgcrypto — bcrypt hashes for seeded operator accounts (crypt/gen_salt)
+	execOrFatal(ctx, conn,
+		"CREATE EXTENSION IF NOT EXISTS pgcrypto;",
+		"pgcrypto extension",
+	)
 }
 
 // ─────────────────────────────────────────────────────────────
@@ -150,7 +166,7 @@ func step3_alerts_table(ctx context.Context, conn *pgx.Conn) {
 
 			-- Alert classification
 			-- Must exactly match domain.AlertType constants:
-			-- SPEEDING | LOW_FUEL | ENGINE_OVERHEAT | ROUTE_DEVIATION
+			-- SPEEDING | LOW_FUEL | ENGINE_OVERHEAT | ROUTE_DEVIATION | DELAY_PREDICTED | UNPLANNED_STOP
 			alert_type       TEXT             NOT NULL,
 
 			-- Must exactly match domain.AlertSeverity constants:
@@ -174,10 +190,12 @@ func step3_alerts_table(ctx context.Context, conn *pgx.Conn) {
 			resolved_at      TIMESTAMPTZ,
 			resolved_by      TEXT,
 
-			-- Constraint: alert_type must be one of the 4 valid values
-			-- ROUTE_DEVIATION is fired by the route deviation detector background job
+			-- Constraint: alert_type must be one of the valid values
+			-- ROUTE_DEVIATION is fired by the route deviation detector background job,
+			-- DELAY_PREDICTED by the ETA estimator, UNPLANNED_STOP by the
+			-- unplanned stop detector
 			CONSTRAINT chk_alert_type CHECK (
-				alert_type IN ('SPEEDING', 'LOW_FUEL', 'ENGINE_OVERHEAT', 'ROUTE_DEVIATION')
+				alert_type IN ('SPEEDING', 'LOW_FUEL', 'ENGINE_OVERHEAT', 'ROUTE_DEVIATION', 'DELAY_PREDICTED', 'UNPLANNED_STOP')
 			),
 
 			-- Constraint: severity must be one of the 3 valid values
@@ -186,6 +204,15 @@ func step3_alerts_table(ctx context.Context, conn *pgx.Conn) {
 			)
 		);
 	`, "vehicle_alerts table created")
+
+	// Databases created before an alert type was added still carry the old
+	// list; replace the constraint so it always matches the one above.
+	execOrFatal(ctx, conn, `
+		ALTER TABLE vehicle_alerts DROP CONSTRAINT IF EXISTS chk_alert_type;
+		ALTER TABLE vehicle_alerts ADD CONSTRAINT chk_alert_type CHECK (
+			alert_type IN ('SPEEDING', 'LOW_FUEL', 'ENGINE_OVERHEAT', 'ROUTE_DEVIATION', 'DELAY_PREDICTED', 'UNPLANNED_STOP')
+		);
+	`, "vehicle_alerts.chk_alert_type ensured")
 }
 
 // ─────────────────────────────────────────────────────────────
@@ -273,13 +300,21 @@ func step4_registry_tables(ctx context.Context, conn *pgx.Conn) {
 	// fleet_config — per-fleet operational config.
 	// staleness_threshold_seconds: how long a vehicle can go silent before
 	// the heartbeat monitor marks it offline. Default 60s.
+	// require_signed_telemetry: reject telemetry without an HMAC signature.
+	// Off by default so fleets can migrate devices one at a time.
 	execOrFatal(ctx, conn, `
 		CREATE TABLE IF NOT EXISTS fleet_config (
-			fleet_id                    TEXT PRIMARY KEY,
-			staleness_threshold_seconds INT  NOT NULL DEFAULT 60
+			fleet_id                    TEXT    PRIMARY KEY,
+			staleness_threshold_seconds INT     NOT NULL DEFAULT 60,
+			require_signed_telemetry    BOOLEAN NOT NULL DEFAULT false
 		);
 	`, "fleet_config table created")
 
+	execOrFatal(ctx, conn, `
+		ALTER TABLE fleet_config
+			ADD COLUMN IF NOT EXISTS require_signed_telemetry BOOLEAN NOT NULL DEFAULT false;
+	`, "fleet_config.require_signed_telemetry ensured")
+
 	// trip — a specific instance of a vehicle + driver + route.
 	// This is the central entity that ties everything together for
 	// the stop detector, deviation detector, and ETA estimator.
@@ -413,10 +448,540 @@ func step5_indexes(ctx context.Context, conn *pgx.Conn) {
 }
 
 // ─────────────────────────────────────────────────────────────
-// Step 6 — Verify everything was created
+// Step 6 — Continuous aggregates for fleet analytics
+// ─────────────────────────────────────────────────────────────
+// Historical analytics read from these views instead of scanning
+// raw vehicle_telemetry. Daily rolls up from hourly (hierarchical
+// continuous aggregate), so raw rows are only touched once.
+//
+//	telemetry_hourly  ← vehicle_telemetry
+//	telemetry_daily   ← telemetry_hourly
+//
+// ─────────────────────────────────────────────────────────────
+func step6_continuous_aggregates(ctx context.Context, conn *pgx.Conn) {
+	fmt.Println("\n── Step 6: Continuous aggregates ───────────────")
+
+	// telemetry_hourly — one row per vehicle per hour.
+	// Distance and fuel are stored as the hour's first and last readings
+	// rather than as deltas: travel between the last sample of one hour and
+	// the first of the next belongs to neither bucket alone, and a view
+	// cannot see its neighbours. The timeseries API closes that gap with LAG
+	// over the previous hour's last reading. Distance uses the odometer, not
+	// summed GPS hops — it is monotonic and immune to GPS jitter.
+	// fuel_used_pct is the fuel burned inside the hour. If the hour ends
+	// fuller than it started there was a refuel, and the burn is the drop to
+	// the lowest reading plus the drop from the highest one after it.
+	// engine_on / moving minutes are the share of samples with the flag set,
+	// scaled by the time span those samples cover.
+	// materialized_only = false keeps the not-yet-refreshed tail visible
+	// (real-time aggregation).
+	execOrFatal(ctx, conn, `
+		CREATE MATERIALIZED VIEW IF NOT EXISTS telemetry_hourly
+		WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+		SELECT
+			time_bucket(INTERVAL '1 hour', timestamp)        AS bucket,
+			vehicle_id,
+			fleet_id,
+			COUNT(*)                                         AS sample_count,
+			first(odometer_km, timestamp)                    AS odometer_first_km,
+			last(odometer_km, timestamp)                     AS odometer_last_km,
+			MAX(speed_kmh)                                   AS max_speed_kmh,
+			AVG(speed_kmh)                                   AS avg_speed_kmh,
+			first(fuel_pct, timestamp)                       AS fuel_first_pct,
+			last(fuel_pct, timestamp)                        AS fuel_last_pct,
+			CASE WHEN last(fuel_pct, timestamp) <= first(fuel_pct, timestamp)
+			     THEN first(fuel_pct, timestamp) - last(fuel_pct, timestamp)
+			     ELSE (first(fuel_pct, timestamp) - MIN(fuel_pct))
+			        + (MAX(fuel_pct) - last(fuel_pct, timestamp))
+			END                                              AS fuel_used_pct,
+			COUNT(*) FILTER (WHERE engine_on)::DOUBLE PRECISION / COUNT(*)
+			    * EXTRACT(EPOCH FROM MAX(timestamp) - MIN(timestamp)) / 60
+			                                                 AS engine_on_minutes,
+			COUNT(*) FILTER (WHERE is_moving)::DOUBLE PRECISION / COUNT(*)
+			    * EXTRACT(EPOCH FROM MAX(timestamp) - MIN(timestamp)) / 60
+			                                                 AS moving_minutes
+		FROM vehicle_telemetry
+		GROUP BY bucket, vehicle_id, fleet_id
+		WITH NO DATA;
+	`, "telemetry_hourly continuous aggregate created")
+
+	// telemetry_daily — rolls up telemetry_hourly.
+	// avg_speed_kmh is re-weighted by sample_count so a sparse hour does not
+	// count as much as a busy one. Distance and fuel are left out: summing
+	// hours would lose the gaps between them, so the timeseries API rolls
+	// those up from telemetry_hourly instead.
+	execOrFatal(ctx, conn, `
+		CREATE MATERIALIZED VIEW IF NOT EXISTS telemetry_daily
+		WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+		SELECT
+			time_bucket(INTERVAL '1 day', bucket)            AS bucket,
+			vehicle_id,
+			fleet_id,
+			SUM(sample_count)                                AS sample_count,
+			MAX(max_speed_kmh)                               AS max_speed_kmh,
+			SUM(avg_speed_kmh * sample_count) / SUM(sample_count)
+			                                                 AS avg_speed_kmh,
+			SUM(engine_on_minutes)                           AS engine_on_minutes,
+			SUM(moving_minutes)                              AS moving_minutes
+		FROM telemetry_hourly
+		GROUP BY time_bucket(INTERVAL '1 day', bucket), vehicle_id, fleet_id
+		WITH NO DATA;
+	`, "telemetry_daily continuous aggregate created")
+
+	// Refresh policies — end_offset leaves the open bucket to real-time
+	// aggregation; start_offset re-materialises late-arriving telemetry
+	// from devices that buffered while out of coverage.
+	execOrFatal(ctx, conn, `
+		SELECT add_continuous_aggregate_policy('telemetry_hourly',
+			start_offset      => INTERVAL '3 hours',
+			end_offset        => INTERVAL '1 hour',
+			schedule_interval => INTERVAL '30 minutes',
+			if_not_exists     => TRUE
+		);
+	`, "telemetry_hourly refresh policy (every 30m)")
+
+	execOrFatal(ctx, conn, `
+		SELECT add_continuous_aggregate_policy('telemetry_daily',
+			start_offset      => INTERVAL '3 days',
+			end_offset        => INTERVAL '1 day',
+			schedule_interval => INTERVAL '1 hour',
+			if_not_exists     => TRUE
+		);
+	`, "telemetry_daily refresh policy (every 1h)")
+
+	execOrFatal(ctx, conn, `
+		CREATE INDEX IF NOT EXISTS idx_telemetry_hourly_fleet
+		ON telemetry_hourly (fleet_id, bucket DESC);
+	`, fmt.Sprintf("%-40s ← %s", "idx_telemetry_hourly_fleet", "query: fleet timeseries (hourly)"))
+
+	execOrFatal(ctx, conn, `
+		CREATE INDEX IF NOT EXISTS idx_telemetry_daily_fleet
+		ON telemetry_daily (fleet_id, bucket DESC);
+	`, fmt.Sprintf("%-40s ← %s", "idx_telemetry_daily_fleet", "query: fleet timeseries (daily)"))
+}
+
+// ─────────────────────────────────────────────────────────────
+// Step 7 — Compression and retention tiers for vehicle_telemetry
+// ─────────────────────────────────────────────────────────────
+// Three tiers, each configurable from the environment:
+//
+//	TELEMETRY_RAW_PAYLOAD_DAYS  raw_payload set to NULL   (0 = keep)
+//	TELEMETRY_COMPRESS_DAYS     chunk compressed          (0 = never)
+//	TELEMETRY_RETENTION_DAYS    chunk dropped once archived (0 = keep forever)
+//
+// Policies are removed and re-added on every run, so changing a value
+// in .env and re-running init_db reconfigures an existing database.
+// ─────────────────────────────────────────────────────────────
+func step7_storage_policies(ctx context.Context, conn *pgx.Conn) {
+	fmt.Println("\n── Step 7: Storage policies ────────────────────")
+
+	rawPayloadDays := dbGetEnvInt("TELEMETRY_RAW_PAYLOAD_DAYS", 14)
+	compressDays := dbGetEnvInt("TELEMETRY_COMPRESS_DAYS", 30)
+	retentionDays := dbGetEnvInt("TELEMETRY_RETENTION_DAYS", 180)
+
+	// raw_payload is nulled with a plain UPDATE, which is cheap on row-store
+	// chunks and expensive on compressed ones — it has to run first. Equal
+	// values would leave prune_raw_payload an empty window, so rows would be
+	// compressed with raw_payload still set.
+	if rawPayloadDays > 0 && compressDays > 0 && rawPayloadDays >= compressDays {
+		log.Fatalf("TELEMETRY_RAW_PAYLOAD_DAYS (%d) must be less than TELEMETRY_COMPRESS_DAYS (%d)",
+			rawPayloadDays, compressDays)
+	}
+	if retentionDays > 0 && compressDays > 0 && compressDays >= retentionDays {
+		log.Fatalf("TELEMETRY_COMPRESS_DAYS (%d) must be less than TELEMETRY_RETENTION_DAYS (%d)",
+			compressDays, retentionDays)
+	}
+
+	// ── Compression ──────────────────────────────────────────────────
+	// segmentby vehicle_id — every history query filters on one vehicle,
+	// so it decompresses only that vehicle's segment.
+	// orderby timestamp DESC — matches idx_telemetry_vehicle_time.
+	// The settings cannot be changed once compressed chunks exist, so a
+	// re-run leaves them alone; only the policies below are reapplied.
+	var compressionConfigured bool
+	if err := conn.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM timescaledb_information.compression_settings
+			WHERE hypertable_name = 'vehicle_telemetry'
+		)
+	`).Scan(&compressionConfigured); err != nil {
+		log.Fatalf("FAILED — read compression settings\nError: %v", err)
+	}
+	if compressionConfigured {
+		fmt.Println("  ✓ vehicle_telemetry compression already enabled")
+	} else {
+		execOrFatal(ctx, conn, `
+			ALTER TABLE vehicle_telemetry SET (
+				timescaledb.compress,
+				timescaledb.compress_segmentby = 'vehicle_id',
+				timescaledb.compress_orderby   = 'timestamp DESC'
+			);
+		`, "vehicle_telemetry compression enabled (segmentby vehicle_id)")
+	}
+
+	execOrFatal(ctx, conn,
+		"SELECT remove_compression_policy('vehicle_telemetry', if_exists => TRUE);",
+		"previous compression policy cleared",
+	)
+	if compressDays > 0 {
+		execOrFatal(ctx, conn, fmt.Sprintf(
+			"SELECT add_compression_policy('vehicle_telemetry', INTERVAL '%d days');",
+			compressDays,
+		), fmt.Sprintf("compression policy: chunks older than %d days", compressDays))
+	}
+
+	// ── Retention ────────────────────────────────────────────────────
+	// Continuous aggregates keep their rows — their refresh windows
+	// (step 6) never reach back as far as the retention horizon.
+	//
+	// Chunks are dropped by drop_archived_chunks rather than TimescaleDB's
+	// retention policy, which drops on its own schedule whether or not the
+	// serving layer's archiver has exported them. A chunk without a
+	// telemetry_archive row (step 8) stays until it has one, so an archiver
+	// outage delays retention instead of losing data. Each chunk goes
+	// through drop_chunks bounded to its own range, so TimescaleDB keeps its
+	// catalog, compressed chunks and continuous-aggregate state in step.
+	// TELEMETRY_RETENTION_REQUIRE_ARCHIVE=false restores the plain policy
+	// for installs that run without an archive.
+	requireArchive := dbGetEnv("TELEMETRY_RETENTION_REQUIRE_ARCHIVE", "true") != "false"
+
+	execOrFatal(ctx, conn,
+		"SELECT remove_retention_policy('vehicle_telemetry', if_exists => TRUE);",
+		"previous retention policy cleared",
+	)
+	execOrFatal(ctx, conn, `
+		CREATE OR REPLACE PROCEDURE drop_archived_chunks(job_id INT, config JSONB)
+		LANGUAGE plpgsql AS $$
+		DECLARE
+			c RECORD;
+		BEGIN
+			FOR c IN
+				SELECT ch.range_start, ch.range_end
+				FROM timescaledb_information.chunks ch
+				JOIN telemetry_archive ta ON ta.chunk_name = ch.chunk_name
+				WHERE ch.hypertable_name = 'vehicle_telemetry'
+				  AND ch.range_end < NOW() - (config->>'drop_after')::INTERVAL
+			LOOP
+				PERFORM drop_chunks('vehicle_telemetry',
+				                    older_than => c.range_end,
+				                    newer_than => c.range_start);
+			END LOOP;
+		END
+		$$;
+	`, "drop_archived_chunks procedure created")
+	execOrFatal(ctx, conn, `
+		SELECT delete_job(job_id)
+		FROM timescaledb_information.jobs
+		WHERE proc_name = 'drop_archived_chunks';
+	`, "previous archived-chunk retention job cleared")
+
+	switch {
+	case retentionDays <= 0:
+		// keep forever
+	case requireArchive:
+		execOrFatal(ctx, conn, fmt.Sprintf(`
+			SELECT add_job(
+				'drop_archived_chunks',
+				INTERVAL '1 day',
+				config => jsonb_build_object('drop_after', '%d days')
+			);
+		`, retentionDays), fmt.Sprintf("retention: archived chunks dropped after %d days", retentionDays))
+	default:
+		execOrFatal(ctx, conn, fmt.Sprintf(
+			"SELECT add_retention_policy('vehicle_telemetry', INTERVAL '%d days');",
+			retentionDays,
+		), fmt.Sprintf("retention policy: chunks dropped after %d days, archived or not", retentionDays))
+	}
+
+	// ── raw_payload pruning ──────────────────────────────────────────
+	// A TimescaleDB user-defined action; older_than lives in the job config
+	// so the interval is visible in timescaledb_information.jobs.
+	// newer_than is the compression horizon: rows past it sit in compressed
+	// chunks, already pruned, and scanning them would decompress every
+	// chunk on every run. Without compression there is no lower bound.
+	execOrFatal(ctx, conn, `
+		CREATE OR REPLACE PROCEDURE prune_raw_payload(job_id INT, config JSONB)
+		LANGUAGE plpgsql AS $$
+		BEGIN
+			UPDATE vehicle_telemetry
+			SET    raw_payload = NULL
+			WHERE  timestamp < NOW() - (config->>'older_than')::INTERVAL
+			  AND  (config->>'newer_than' IS NULL
+			        OR timestamp >= NOW() - (config->>'newer_than')::INTERVAL)
+			  AND  raw_payload IS NOT NULL;
+		END
+		$$;
+	`, "prune_raw_payload procedure created")
+
+	execOrFatal(ctx, conn, `
+		SELECT delete_job(job_id)
+		FROM timescaledb_information.jobs
+		WHERE proc_name = 'prune_raw_payload';
+	`, "previous raw_payload job cleared")
+	if rawPayloadDays > 0 {
+		newerThan := "NULL"
+		if compressDays > 0 {
+			newerThan = fmt.Sprintf("'%d days'", compressDays)
+		}
+		execOrFatal(ctx, conn, fmt.Sprintf(`
+			SELECT add_job(
+				'prune_raw_payload',
+				INTERVAL '1 hour',
+				config => jsonb_build_object('older_than', '%d days', 'newer_than', %s::TEXT)
+			);
+		`, rawPayloadDays, newerThan), fmt.Sprintf("raw_payload pruning: nulled after %d days", rawPayloadDays))
+	}
+}
+
+// ─────────────────────────────────────────────────────────────
+// Step 8 — Cold-storage archive catalogue
+// ─────────────────────────────────────────────────────────────
+func step8_archive_catalog(ctx context.Context, conn *pgx.Conn) {
+	fmt.Println("\n── Step 8: Archive catalogue ───────────────────")
+
+	// telemetry_archive — one row per vehicle_telemetry chunk exported to
+	// Parquet by the serving layer's archiver job.
+	// chunk_name is TimescaleDB's internal chunk name; drop_archived_chunks
+	// (step 7) drops only chunks that have a row here.
+	// manifest_key points at the JSON manifest in the object store, which
+	// lists every Parquet file written for the chunk.
+	execOrFatal(ctx, conn, `
+		CREATE TABLE IF NOT EXISTS telemetry_archive (
+			chunk_name    TEXT        PRIMARY KEY,
+			range_start   TIMESTAMPTZ NOT NULL,
+			range_end     TIMESTAMPTZ NOT NULL,
+			row_count     BIGINT      NOT NULL,
+			file_count    INT         NOT NULL,
+			manifest_key  TEXT        NOT NULL,
+			archived_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`, "telemetry_archive table created")
+}
+
+// ─────────────────────────────────────────────────────────────
+// Step 9 — Operator accounts for the serving layer's local issuer
+// ─────────────────────────────────────────────────────────────
+func step9_operator_accounts(ctx context.Context, conn *pgx.Conn) {
+	fmt.Println("\n── Step 9: Operator accounts ───────────────────")
+
+	// operator_account — dashboard logins for the serving layer's built-in
+	// OIDC issuer (OIDC_LOCAL_ISSUER=true). Deployments using an external
+	// identity provider can leave it empty.
+	// subject becomes the token "sub" and is what acknowledged_by/resolved_by
+	// record. fleet_id is NULL only for super_admin, which spans all fleets.
+	// password_hash is bcrypt, compatible with pgcrypto's crypt().
+	execOrFatal(ctx, conn, `
+		CREATE TABLE IF NOT EXISTS operator_account (
+			subject       TEXT        PRIMARY KEY,
+			username      TEXT        NOT NULL UNIQUE,
+			display_name  TEXT        NOT NULL,
+			role          TEXT        NOT NULL,
+			fleet_id      TEXT,
+			password_hash TEXT        NOT NULL,
+			active        BOOLEAN     NOT NULL DEFAULT true,
+			created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+			CONSTRAINT chk_operator_role CHECK (
+				role IN ('viewer', 'dispatcher', 'fleet_admin', 'super_admin')
+			),
+			CONSTRAINT chk_operator_fleet CHECK (
+				(role = 'super_admin') = (fleet_id IS NULL)
+			)
+		);
+	`, "operator_account table created")
+
+	// Development logins, one per role. Skipped unless a password is set,
+	// and never overwrites an existing account.
+	password := os.Getenv("OPERATOR_DEV_PASSWORD")
+	if password == "" {
+		fmt.Println("  - OPERATOR_DEV_PASSWORD not set, skipping dev operators")
+		return
+	}
+	devOperators := []struct {
+		subject, username, name, role string
+		fleetID                       *string
+	}{
+		{"op-admin", "admin", "Platform Admin", "super_admin", nil},
+		{"op-test-fleet-admin", "fleet_admin", "Test Fleet Admin", "fleet_admin", strPtr("test_fleet")},
+		{"op-test-dispatcher", "dispatcher", "Test Dispatcher", "dispatcher", strPtr("test_fleet")},
+		{"op-test-viewer", "viewer", "Test Viewer", "viewer", strPtr("test_fleet")},
+	}
+	for _, op := range devOperators {
+		_, err := conn.Exec(ctx, `
+			INSERT INTO operator_account (subject, username, display_name, role, fleet_id, password_hash)
+			VALUES ($1, $2, $3, $4, $5, crypt($6, gen_salt('bf')))
+			ON CONFLICT (subject) DO NOTHING
+		`, op.subject, op.username, op.name, op.role, op.fleetID, password)
+		if err != nil {
+			log.Fatalf("FAILED — dev operator %s\nError: %v", op.username, err)
+		}
+		fmt.Printf("  ✓ dev operator: %s (%s)\n", op.username, op.role)
+	}
+}
+
+// ─────────────────────────────────────────────────────────────
+// Step 10 — Background job leadership (serving layer)
+// ─────────────────────────────────────────────────────────────
+func step10_job_leases(ctx context.Context, conn *pgx.Conn) {
+	fmt.Println("\n── Step 10: Job leases ─────────────────────────")
+
+	// job_lease — the fencing token of each background job's current leader.
+	// Leases themselves live in Redis; this row lets job writes check they
+	// still hold the newest token, so a paused ex-leader's late write affects
+	// nothing. fencing_token only ever increases.
+	execOrFatal(ctx, conn, `
+		CREATE TABLE IF NOT EXISTS job_lease (
+			job           TEXT        PRIMARY KEY,
+			holder        TEXT        NOT NULL,
+			fencing_token BIGINT      NOT NULL,
+			acquired_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`, "job_lease table created")
+}
+
+// ─────────────────────────────────────────────────────────────
+// Step 11 — Background job run history
+// ─────────────────────────────────────────────────────────────
+func step11_job_runs(ctx context.Context, conn *pgx.Conn) {
+	fmt.Println("\n── Step 11: Job run history ────────────────────")
+
+	// job_runs — one row per serving background job run, scheduled or
+	// triggered by an operator. items is whatever the job counts as work
+	// done (vehicles checked, chunks archived, ...). The scheduler prunes
+	// rows past its history retention.
+	execOrFatal(ctx, conn, `
+		CREATE TABLE IF NOT EXISTS job_runs (
+			run_id        BIGSERIAL   PRIMARY KEY,
+			job           TEXT        NOT NULL,
+			instance_id   TEXT        NOT NULL,
+			trigger       TEXT        NOT NULL,
+			started_at    TIMESTAMPTZ NOT NULL,
+			duration_ms   INTEGER     NOT NULL,
+			items         INTEGER     NOT NULL DEFAULT 0,
+			error         TEXT,
+			CONSTRAINT chk_job_run_trigger CHECK (trigger IN ('schedule', 'manual'))
+		);
+	`, "job_runs table created")
+
+	execOrFatal(ctx, conn, `
+		CREATE INDEX IF NOT EXISTS idx_job_runs_job_started
+		ON job_runs (job, started_at DESC);
+	`, "idx_job_runs_job_started")
+}
+
+// ─────────────────────────────────────────────────────────────
+// Step 12 — Historical segment travel times
+// ─────────────────────────────────────────────────────────────
+func step12_segment_speeds(ctx context.Context, conn *pgx.Conn) {
+	fmt.Println("\n── Step 12: Segment travel times ───────────────")
+
+	// route_segment_stats — how long vehicles take between consecutive
+	// route_stops, learned from completed trips by the serving ETA model.
+	// One row per segment per hour of the week (0 = Sunday 00:00 UTC), keyed
+	// by the hour the vehicle left from_stop_id. travel_seconds is departure
+	// from from_stop_id to arrival at to_stop_id; dwell_seconds is time spent
+	// at from_stop_id. Medians, so one breakdown does not skew the model.
+	execOrFatal(ctx, conn, `
+		CREATE TABLE IF NOT EXISTS route_segment_stats (
+			route_id       TEXT             NOT NULL REFERENCES route_registry(route_id),
+			from_stop_id   TEXT             NOT NULL REFERENCES route_stops(stop_id),
+			to_stop_id     TEXT             NOT NULL REFERENCES route_stops(stop_id),
+			hour_of_week   SMALLINT         NOT NULL,
+			samples        INT              NOT NULL,
+			travel_seconds DOUBLE PRECISION NOT NULL,
+			dwell_seconds  DOUBLE PRECISION,
+			updated_at     TIMESTAMPTZ      NOT NULL DEFAULT NOW(),
+
+			PRIMARY KEY (route_id, from_stop_id, hour_of_week),
+
+			CONSTRAINT chk_hour_of_week CHECK (hour_of_week BETWEEN 0 AND 167)
+		);
+	`, "route_segment_stats table created")
+}
+
+// ─────────────────────────────────────────────────────────────
+// Step 13 — Planned arrival windows and on-time performance
 // ─────────────────────────────────────────────────────────────
-func step6_verify(ctx context.Context, conn *pgx.Conn) {
-	fmt.Println("\n── Step 6: Verification ────────────────────────")
+func step13_arrival_windows(ctx context.Context, conn *pgx.Conn) {
+	fmt.Println("\n── Step 13: Arrival windows ────────────────────")
+
+	// The window promised to the customer for each stop of a trip, set when
+	// the trip is planned (PUT /api/v1/trips/{id}/windows). Both ends or
+	// neither; stops without a window are not tracked for punctuality.
+	//
+	// punctuality and arrival_delta_seconds are written by the stop detector
+	// as a windowed stop resolves: EARLY / ON_TIME / LATE on arrival, with
+	// the seconds before window_start (negative) or after window_end
+	// (positive); MISSED if the stop is skipped.
+	execOrFatal(ctx, conn, `
+		ALTER TABLE trip_stop_progress
+			ADD COLUMN IF NOT EXISTS window_start          TIMESTAMPTZ,
+			ADD COLUMN IF NOT EXISTS window_end            TIMESTAMPTZ,
+			ADD COLUMN IF NOT EXISTS punctuality           TEXT,
+			ADD COLUMN IF NOT EXISTS arrival_delta_seconds INT;
+	`, "trip_stop_progress window columns ensured")
+
+	execOrFatal(ctx, conn, `
+		ALTER TABLE trip_stop_progress DROP CONSTRAINT IF EXISTS chk_stop_window;
+		ALTER TABLE trip_stop_progress ADD CONSTRAINT chk_stop_window CHECK (
+			(window_start IS NULL) = (window_end IS NULL)
+			AND (window_start IS NULL OR window_end >= window_start)
+		);
+		ALTER TABLE trip_stop_progress DROP CONSTRAINT IF EXISTS chk_stop_punctuality;
+		ALTER TABLE trip_stop_progress ADD CONSTRAINT chk_stop_punctuality CHECK (
+			punctuality IN ('EARLY', 'ON_TIME', 'LATE', 'MISSED')
+		);
+	`, "trip_stop_progress window constraints ensured")
+}
+
+// ─────────────────────────────────────────────────────────────
+// Step 14 — Unplanned stops
+// ─────────────────────────────────────────────────────────────
+func step14_unplanned_stops(ctx context.Context, conn *pgx.Conn) {
+	fmt.Println("\n── Step 14: Unplanned stops ────────────────────")
+
+	// unplanned_stops — a vehicle on a trip stationary for longer than
+	// UNPLANNED_STOP_MIN_MINUTES outside every route_stops arrival radius.
+	// A row is written once the halt qualifies and stays open (ended_at
+	// NULL) until the vehicle moves off or the trip ends; duration_seconds
+	// is filled in then. alert_id points at the UNPLANNED_STOP alert if the
+	// halt ran past UNPLANNED_STOP_ALERT_MINUTES.
+	execOrFatal(ctx, conn, `
+		CREATE TABLE IF NOT EXISTS unplanned_stops (
+			id               BIGSERIAL              PRIMARY KEY,
+			vehicle_id       TEXT                   NOT NULL REFERENCES vehicle_registry(vehicle_id),
+			fleet_id         TEXT                   NOT NULL,
+			trip_id          TEXT                   NOT NULL REFERENCES trip(trip_id),
+			latitude         DOUBLE PRECISION       NOT NULL,
+			longitude        DOUBLE PRECISION       NOT NULL,
+			location         GEOGRAPHY(POINT, 4326)
+			                     GENERATED ALWAYS AS (
+			                         ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)::geography
+			                     ) STORED,
+			started_at       TIMESTAMPTZ            NOT NULL,
+			ended_at         TIMESTAMPTZ,
+			duration_seconds INT,
+			alert_id         BIGINT,
+
+			CONSTRAINT chk_unplanned_stop_end CHECK (ended_at IS NULL OR ended_at >= started_at)
+		);
+	`, "unplanned_stops table created")
+
+	// Fleet history screens list newest first; the detector reloads open
+	// halts on failover.
+	execOrFatal(ctx, conn, `
+		CREATE INDEX IF NOT EXISTS idx_unplanned_stops_fleet_started
+		ON unplanned_stops (fleet_id, started_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_unplanned_stops_open
+		ON unplanned_stops (vehicle_id) WHERE ended_at IS NULL;
+	`, "unplanned_stops indexes created")
+}
+
+// ─────────────────────────────────────────────────────────────
+// Step 15 — Verify everything was created
+// ─────────────────────────────────────────────────────────────
+func step15_verify(ctx context.Context, conn *pgx.Conn) {
+	fmt.Println("\n── Step 15: Verification ───────────────────────")
 
 	// Check all tables exist
 	tables := []string{
@@ -429,6 +994,12 @@ func step6_verify(ctx context.Context, conn *pgx.Conn) {
 		"fleet_config",
 		"trip",
 		"trip_stop_progress",
+		"telemetry_archive",
+		"operator_account",
+		"job_lease",
+		"job_runs",
+		"route_segment_stats",
+		"unplanned_stops",
 	}
 	for _, table := range tables {
 		var exists bool
@@ -456,6 +1027,21 @@ func step6_verify(ctx context.Context, conn *pgx.Conn) {
 	}
 	fmt.Printf("  ✓ hypertable: %s (time partitioned)\n", hypertableName)
 
+	// Check continuous aggregates
+	for _, view := range []string{"telemetry_hourly", "telemetry_daily"} {
+		var exists bool
+		err := conn.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM timescaledb_information.continuous_aggregates
+				WHERE view_name = $1
+			)
+		`, view).Scan(&exists)
+		if err != nil || !exists {
+			log.Fatalf("Continuous aggregate %s was not created: %v", view, err)
+		}
+		fmt.Printf("  ✓ continuous aggregate: %s\n", view)
+	}
+
 	// Check indexes across all relevant tables
 	var indexCount int
 	err = conn.QueryRow(ctx, `
@@ -492,3 +1078,15 @@ func dbGetEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func dbGetEnvInt(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
@@ -45,19 +48,46 @@ func main() {
 func step1_api_keys(ctx context.Context, client *redis.Client) {
 	fmt.Println("\n── Step 1: Seeding API keys ────────────────────")
 
-	apiKeys := map[string]string{
-		"vehicle:auth:fleet_delhi_jaipur_key": "fleet_delhi_jaipur",
-		"vehicle:auth:fleet_mumbai_pune_key":  "fleet_mumbai_pune",
-		"vehicle:auth:fleet_bangalore_key":    "fleet_bangalore",
-		"vehicle:auth:test_key":               "test_fleet",
+	// Keys are stored under the SHA-256 of the plaintext key, the same way
+	// the serving layer's admin endpoints issue them. An empty vehicle_id is
+	// a fleet gateway key, accepted for any vehicle registered to the fleet.
+	// secret is the device's HMAC signing secret; gateway keys have none.
+	apiKeys := map[string][3]string{
+		"fleet_delhi_jaipur_key": {"fleet_delhi_jaipur", "", ""},
+		"fleet_mumbai_pune_key":  {"fleet_mumbai_pune", "", ""},
+		"fleet_bangalore_key":    {"fleet_bangalore", "", ""},
+		"test_key":               {"test_fleet", "", ""},
+		"VH_TEST_001_key":        {"test_fleet", "VH_TEST_001", "VH_TEST_001_secret"},
+		"VH_TEST_002_key":        {"test_fleet", "VH_TEST_002", "VH_TEST_002_secret"},
+		"VH_TEST_003_key":        {"test_fleet", "VH_TEST_003", "VH_TEST_003_secret"},
 	}
 
-	for key, fleetID := range apiKeys {
-		err := client.Set(ctx, key, fleetID, 0).Err()
-		if err != nil {
-			log.Fatalf("Failed to set key %s: %v", key, err)
+	for apiKey, id := range apiKeys {
+		sum := sha256.Sum256([]byte(apiKey))
+		digest := hex.EncodeToString(sum[:])
+		keyID := "seed_" + apiKey
+		signing := "false"
+		if id[2] != "" {
+			signing = "true"
+		}
+
+		// DEL first so reruns start from a clean hash.
+		pipe := client.TxPipeline()
+		pipe.Del(ctx, "vehicle:auth:"+digest, "auth:key:"+keyID)
+		pipe.HSet(ctx, "vehicle:auth:"+digest,
+			"fleet_id", id[0], "vehicle_id", id[1], "secret", id[2], "key_id", keyID)
+		pipe.HSet(ctx, "auth:key:"+keyID,
+			"digest", digest, "fleet_id", id[0], "vehicle_id", id[1],
+			"label", "seed", "signing", signing, "created_at", time.Now().Unix())
+		pipe.SAdd(ctx, "auth:keys", keyID)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Fatalf("Failed to set key %s: %v", apiKey, err)
+		}
+		bound := id[1]
+		if bound == "" {
+			bound = "(gateway)"
 		}
-		fmt.Printf("  ✓ %-45s → %s\n", key, fleetID)
+		fmt.Printf("  ✓ %-45s → %s / %s\n", apiKey, id[0], bound)
 	}
 }
 
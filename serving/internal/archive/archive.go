@@ -0,0 +1,172 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Layout inside the object store:
+//
+//	telemetry/fleet_id={fleet}/date={YYYY-MM-DD}/{chunk}.parquet
+//	manifests/{chunk}.json
+//
+// A TimescaleDB chunk can span several days and fleets, so it produces one
+// Parquet file per (fleet, day) and a single manifest listing them all.
+// A day can in turn receive files from two chunks, hence the chunk name in
+// the file name.
+const (
+	telemetryPrefix = "telemetry/"
+	manifestPrefix  = "manifests/"
+	dayLayout       = "2006-01-02"
+)
+
+// Row is one archived telemetry sample. raw_payload is deliberately absent —
+// it is pruned long before a chunk reaches the archive.
+type Row struct {
+	Timestamp      time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	ReceivedAt     time.Time `parquet:"received_at,timestamp(millisecond)"`
+	VehicleID      string    `parquet:"vehicle_id,dict"`
+	FleetID        string    `parquet:"fleet_id,dict"`
+	Latitude       float64   `parquet:"latitude"`
+	Longitude      float64   `parquet:"longitude"`
+	SpeedKmh       float64   `parquet:"speed_kmh"`
+	FuelPct        float64   `parquet:"fuel_pct"`
+	EngineTempC    float64   `parquet:"engine_temp_celsius"`
+	BatteryVoltage float64   `parquet:"battery_voltage"`
+	OdometerKm     float64   `parquet:"odometer_km"`
+	IsMoving       bool      `parquet:"is_moving"`
+	EngineOn       bool      `parquet:"engine_on"`
+}
+
+// Manifest records everything exported from one TimescaleDB chunk.
+type Manifest struct {
+	ChunkName  string         `json:"chunk_name"`
+	RangeStart time.Time      `json:"range_start"`
+	RangeEnd   time.Time      `json:"range_end"`
+	RowCount   int64          `json:"row_count"`
+	ArchivedAt time.Time      `json:"archived_at"`
+	Files      []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes one Parquet partition file.
+type ManifestFile struct {
+	Key          string    `json:"key"`
+	FleetID      string    `json:"fleet_id"`
+	Date         string    `json:"date"`
+	RowCount     int       `json:"row_count"`
+	MinTimestamp time.Time `json:"min_timestamp"`
+	MaxTimestamp time.Time `json:"max_timestamp"`
+	Bytes        int       `json:"bytes"`
+	SHA256       string    `json:"sha256"`
+}
+
+type Archive struct {
+	store ObjectStore
+}
+
+func New(store ObjectStore) *Archive {
+	return &Archive{store: store}
+}
+
+func partitionPrefix(fleetID string, day time.Time) string {
+	return fmt.Sprintf("%sfleet_id=%s/date=%s/", telemetryPrefix, fleetID, day.UTC().Format(dayLayout))
+}
+
+func ManifestKey(chunkName string) string {
+	return manifestPrefix + chunkName + ".json"
+}
+
+// WritePartition encodes rows (all from one fleet and one UTC day) as a
+// zstd-compressed Parquet file and uploads it. Rows are sorted by vehicle
+// then time so per-vehicle reads touch contiguous pages.
+func (a *Archive) WritePartition(ctx context.Context, chunkName, fleetID string, day time.Time, rows []Row) (ManifestFile, error) {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].VehicleID != rows[j].VehicleID {
+			return rows[i].VehicleID < rows[j].VehicleID
+		}
+		return rows[i].Timestamp.Before(rows[j].Timestamp)
+	})
+
+	var buf bytes.Buffer
+	if err := parquet.Write(&buf, rows, parquet.Compression(&parquet.Zstd)); err != nil {
+		return ManifestFile{}, fmt.Errorf("archive: encode %s/%s: %w", fleetID, day.Format(dayLayout), err)
+	}
+
+	key := partitionPrefix(fleetID, day) + chunkName + ".parquet"
+	if err := a.store.Put(ctx, key, buf.Bytes()); err != nil {
+		return ManifestFile{}, err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	file := ManifestFile{
+		Key:      key,
+		FleetID:  fleetID,
+		Date:     day.UTC().Format(dayLayout),
+		RowCount: len(rows),
+		Bytes:    buf.Len(),
+		SHA256:   hex.EncodeToString(sum[:]),
+	}
+	for i, r := range rows {
+		if i == 0 || r.Timestamp.Before(file.MinTimestamp) {
+			file.MinTimestamp = r.Timestamp
+		}
+		if r.Timestamp.After(file.MaxTimestamp) {
+			file.MaxTimestamp = r.Timestamp
+		}
+	}
+	return file, nil
+}
+
+// WriteManifest uploads the manifest last, so its presence means every file
+// it lists is already in place.
+func (a *Archive) WriteManifest(ctx context.Context, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("archive: encode manifest %s: %w", m.ChunkName, err)
+	}
+	return a.store.Put(ctx, ManifestKey(m.ChunkName), data)
+}
+
+// ReadVehicle returns one vehicle's archived rows in [from, to), oldest first.
+func (a *Archive) ReadVehicle(ctx context.Context, fleetID, vehicleID string, from, to time.Time) ([]Row, error) {
+	var out []Row
+
+	day := from.UTC().Truncate(24 * time.Hour)
+	for day.Before(to) {
+		keys, err := a.store.List(ctx, partitionPrefix(fleetID, day))
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if !strings.HasSuffix(key, ".parquet") {
+				continue
+			}
+			data, err := a.store.Get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			rows, err := parquet.Read[Row](bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				return nil, fmt.Errorf("archive: decode %s: %w", key, err)
+			}
+			for _, r := range rows {
+				if r.VehicleID == vehicleID && !r.Timestamp.Before(from) && r.Timestamp.Before(to) {
+					out = append(out, r)
+				}
+			}
+		}
+		day = day.Add(24 * time.Hour)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Timestamp.Before(out[j].Timestamp) })
+	return out, nil
+}
@@ -0,0 +1,42 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned by ObjectStore.Get when the key does not exist.
+var ErrNotFound = errors.New("archive: object not found")
+
+// ObjectStore is the minimal blob API the archive needs. Keys are
+// slash-separated paths; implementations map them onto their own layout.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+type Config struct {
+	Backend string // "local" | "s3"
+
+	LocalDir string
+
+	S3Endpoint  string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3UseSSL    bool
+}
+
+// NewObjectStore builds the backend selected by cfg.Backend.
+func NewObjectStore(ctx context.Context, cfg Config) (ObjectStore, error) {
+	switch cfg.Backend {
+	case "local":
+		return NewLocalStore(cfg.LocalDir)
+	case "s3":
+		return NewS3Store(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("archive: unknown backend %q", cfg.Backend)
+	}
+}
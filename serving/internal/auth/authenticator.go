@@ -3,6 +3,7 @@ package auth
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -47,36 +48,8 @@ func NewAuthenticator(cfg Config, redisClient *redis.Client) *Authenticator {
 }
 
 func (a *Authenticator) Validate(ctx context.Context, apiKey string) bool {
-	if apiKey == "" {
-		return false
-	}
-
-	// Level 0 — static config keys, zero I/O.
-	if a.staticKeys[apiKey] {
-		return true
-	}
-
-	// Level 1 — in-process cache.
-	if raw, ok := a.localCache.Load(apiKey); ok {
-		entry := raw.(cacheEntry)
-		if time.Now().Before(entry.expiresAt) {
-			return true
-		}
-		a.localCache.Delete(apiKey)
-	}
-
-	// Level 2 — Redis.
-	fleetID, err := a.lookupRedis(ctx, apiKey)
-	if err != nil || fleetID == "" {
-		return false
-	}
-
-	a.localCache.Store(apiKey, cacheEntry{
-		fleetID:   fleetID,
-		expiresAt: time.Now().Add(a.ttl),
-	})
-
-	return true
+	_, ok := a.FleetID(ctx, apiKey)
+	return ok
 }
 
 func (a *Authenticator) FleetID(ctx context.Context, apiKey string) (string, bool) {
@@ -84,39 +57,76 @@ func (a *Authenticator) FleetID(ctx context.Context, apiKey string) (string, boo
 		return "", false
 	}
 
+	// Level 0 — static config keys, zero I/O.
 	if a.staticKeys[apiKey] {
 		return "", true
 	}
 
-	if raw, ok := a.localCache.Load(apiKey); ok {
+	// Redis-backed keys are cached by digest so revocations, which only
+	// carry the digest, can find them.
+	digest := HashKey(apiKey)
+	if raw, ok := a.localCache.Load(digest); ok {
 		entry := raw.(cacheEntry)
 		if time.Now().Before(entry.expiresAt) {
 			return entry.fleetID, true
 		}
-		a.localCache.Delete(apiKey)
+		a.localCache.Delete(digest)
 	}
 
-	fleetID, err := a.lookupRedis(ctx, apiKey)
+	fleetID, keyExpiry, err := a.lookupRedis(ctx, digest)
 	if err != nil || fleetID == "" {
 		return "", false
 	}
 
-	a.localCache.Store(apiKey, cacheEntry{
+	// A rotated key stops working at its expiry, not at the end of the TTL.
+	expiresAt := time.Now().Add(a.ttl)
+	if !keyExpiry.IsZero() {
+		if !time.Now().Before(keyExpiry) {
+			return "", false
+		}
+		if keyExpiry.Before(expiresAt) {
+			expiresAt = keyExpiry
+		}
+	}
+	a.localCache.Store(digest, cacheEntry{
 		fleetID:   fleetID,
-		expiresAt: time.Now().Add(a.ttl),
+		expiresAt: expiresAt,
 	})
 
 	return fleetID, true
 }
 
-func (a *Authenticator) lookupRedis(ctx context.Context, apiKey string) (string, error) {
-	key := fmt.Sprintf("vehicle:auth:%s", apiKey)
-	val, err := a.redis.Get(ctx, key).Result()
-	if err == redis.Nil {
-		return "", nil
+// WatchRevocations evicts revoked and rotated keys from the local cache as
+// soon as the key store announces them. It blocks until ctx is cancelled.
+func (a *Authenticator) WatchRevocations(ctx context.Context) {
+	sub := a.redis.Subscribe(ctx, RevocationChannel, InvalidationChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			a.localCache.Delete(msg.Payload)
+		case <-ctx.Done():
+			return
+		}
 	}
+}
+
+func (a *Authenticator) lookupRedis(ctx context.Context, digest string) (string, time.Time, error) {
+	key := fmt.Sprintf("vehicle:auth:%s", digest)
+	vals, err := a.redis.HMGet(ctx, key, "fleet_id", "expires_at").Result()
 	if err != nil {
-		return "", fmt.Errorf("redis auth lookup failed: %w", err)
+		return "", time.Time{}, fmt.Errorf("redis auth lookup failed: %w", err)
+	}
+	fleetID, _ := vals[0].(string)
+	var expiresAt time.Time
+	if v, _ := vals[1].(string); v != "" {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		expiresAt = time.Unix(n, 0)
 	}
-	return val, nil
+	return fleetID, expiresAt, nil
 }
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Key layout in Redis — shared with the ingestion authenticator:
+//
+//	vehicle:auth:{sha256(key)}  HASH  fleet_id, vehicle_id, secret, key_id, expires_at
+//	auth:key:{key_id}           HASH  key metadata (never the key itself)
+//	auth:keys                   SET   all key_ids
+//	auth:revocations            PUBSUB  sha256 digests of revoked keys
+//	auth:invalidations          PUBSUB  sha256 digests of keys whose record changed
+const (
+	RevocationChannel   = "auth:revocations"
+	InvalidationChannel = "auth:invalidations"
+	keyIndex            = "auth:keys"
+)
+
+var (
+	ErrKeyNotFound = errors.New("api key not found")
+	ErrKeyRotated  = errors.New("api key already rotated")
+)
+
+// rotateAttempts bounds Rotate's retries when the key changes under it.
+const rotateAttempts = 3
+
+// HashKey is the digest under which an API key is stored. Plaintext keys are
+// returned once at issue time and never persisted.
+func HashKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyInfo is the listable metadata of an issued key.
+type KeyInfo struct {
+	KeyID      string     `json:"key_id"`
+	FleetID    string     `json:"fleet_id"`
+	VehicleID  string     `json:"vehicle_id,omitempty"` // empty = fleet gateway key
+	Label      string     `json:"label,omitempty"`
+	Signing    bool       `json:"signing"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy string     `json:"replaced_by,omitempty"`
+}
+
+// IssuedKey is returned exactly once, when a key is issued or rotated.
+type IssuedKey struct {
+	KeyInfo
+	APIKey string `json:"api_key"`
+	Secret string `json:"secret,omitempty"` // HMAC signing secret, when Signing
+}
+
+type IssueRequest struct {
+	FleetID   string
+	VehicleID string
+	Label     string
+	Signing   bool
+}
+
+type KeyStore struct {
+	redis *redis.Client
+}
+
+func NewKeyStore(redisClient *redis.Client) *KeyStore {
+	return &KeyStore{redis: redisClient}
+}
+
+func (s *KeyStore) Issue(ctx context.Context, req IssueRequest) (*IssuedKey, error) {
+	issued, err := newKey(req)
+	if err != nil {
+		return nil, err
+	}
+	pipe := s.redis.TxPipeline()
+	queueKey(ctx, pipe, issued)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("issue key: %w", err)
+	}
+	return issued, nil
+}
+
+// newKey generates the ID, key and secret of a key to be issued.
+func newKey(req IssueRequest) (*IssuedKey, error) {
+	keyID, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	issued := &IssuedKey{
+		KeyInfo: KeyInfo{
+			KeyID:     keyID,
+			FleetID:   req.FleetID,
+			VehicleID: req.VehicleID,
+			Label:     req.Label,
+			Signing:   req.Signing,
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		},
+		APIKey: "fm_" + raw,
+	}
+	if req.Signing {
+		if issued.Secret, err = randomHex(32); err != nil {
+			return nil, err
+		}
+	}
+
+	return issued, nil
+}
+
+// queueKey adds the writes that store an issued key to pipe.
+func queueKey(ctx context.Context, pipe redis.Pipeliner, issued *IssuedKey) {
+	digest := HashKey(issued.APIKey)
+	pipe.HSet(ctx, "vehicle:auth:"+digest,
+		"fleet_id", issued.FleetID,
+		"vehicle_id", issued.VehicleID,
+		"secret", issued.Secret,
+		"key_id", issued.KeyID,
+	)
+	pipe.HSet(ctx, "auth:key:"+issued.KeyID,
+		"digest", digest,
+		"fleet_id", issued.FleetID,
+		"vehicle_id", issued.VehicleID,
+		"label", issued.Label,
+		"signing", strconv.FormatBool(issued.Signing),
+		"created_at", issued.CreatedAt.Unix(),
+	)
+	pipe.SAdd(ctx, keyIndex, issued.KeyID)
+}
+
+// List returns key metadata, newest first. An empty fleetID lists every fleet.
+func (s *KeyStore) List(ctx context.Context, fleetID string) ([]KeyInfo, error) {
+	ids, err := s.redis.SMembers(ctx, keyIndex).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+
+	pipe := s.redis.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, "auth:key:"+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+
+	keys := make([]KeyInfo, 0, len(ids))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 || (fleetID != "" && fields["fleet_id"] != fleetID) {
+			continue
+		}
+		keys = append(keys, keyInfoFromHash(ids[i], fields))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (s *KeyStore) Get(ctx context.Context, keyID string) (KeyInfo, error) {
+	fields, err := s.redis.HGetAll(ctx, "auth:key:"+keyID).Result()
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("get key: %w", err)
+	}
+	if len(fields) == 0 {
+		return KeyInfo{}, ErrKeyNotFound
+	}
+	return keyInfoFromHash(keyID, fields), nil
+}
+
+// Rotate issues a replacement with the same binding and lets the old key
+// keep working for overlap, so devices can be re-provisioned without a gap.
+// A key can be rotated once: one already rotated reports ErrKeyRotated. The
+// check and both writes run under WATCH on the old key's metadata, so two
+// concurrent rotations can't both succeed.
+//
+// Caches filled before the rotation don't know the new expires_at, so every
+// instance is told to drop the old key and re-read it. Unlike a revocation
+// this doesn't close sockets; those lapse at the periodic re-check.
+func (s *KeyStore) Rotate(ctx context.Context, keyID string, overlap time.Duration) (*IssuedKey, error) {
+	metaKey := "auth:key:" + keyID
+	var issued *IssuedKey
+	rotate := func(tx *redis.Tx) error {
+		fields, err := tx.HGetAll(ctx, metaKey).Result()
+		if err != nil {
+			return fmt.Errorf("rotate key: %w", err)
+		}
+		old := keyInfoFromHash(keyID, fields)
+		switch {
+		case len(fields) == 0, old.RevokedAt != nil:
+			return ErrKeyNotFound
+		case old.ExpiresAt != nil, old.ReplacedBy != "":
+			return ErrKeyRotated
+		}
+
+		issued, err = newKey(IssueRequest{
+			FleetID:   old.FleetID,
+			VehicleID: old.VehicleID,
+			Label:     old.Label,
+			Signing:   old.Signing,
+		})
+		if err != nil {
+			return err
+		}
+
+		digest := fields["digest"]
+		expiresAt := time.Now().Add(overlap).UTC().Truncate(time.Second)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			queueKey(ctx, pipe, issued)
+			pipe.HSet(ctx, "vehicle:auth:"+digest, "expires_at", expiresAt.Unix())
+			pipe.ExpireAt(ctx, "vehicle:auth:"+digest, expiresAt)
+			pipe.HSet(ctx, metaKey,
+				"expires_at", expiresAt.Unix(),
+				"replaced_by", issued.KeyID,
+			)
+			pipe.Publish(ctx, InvalidationChannel, digest)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("rotate key: %w", err)
+		}
+		return nil
+	}
+
+	for range rotateAttempts {
+		err := s.redis.Watch(ctx, rotate, metaKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue // changed under us; the next read says how
+		}
+		if err != nil {
+			return nil, err
+		}
+		return issued, nil
+	}
+	return nil, fmt.Errorf("rotate key: %w", redis.TxFailedErr)
+}
+
+// Revoke deletes a key immediately and tells every instance to drop it from
+// its local cache. Metadata is kept for audit.
+func (s *KeyStore) Revoke(ctx context.Context, keyID string) error {
+	digest, err := s.redis.HGet(ctx, "auth:key:"+keyID, "digest").Result()
+	if err == redis.Nil {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("revoke key: %w", err)
+	}
+
+	pipe := s.redis.TxPipeline()
+	pipe.Del(ctx, "vehicle:auth:"+digest)
+	pipe.HSet(ctx, "auth:key:"+keyID, "revoked_at", time.Now().Unix())
+	pipe.Publish(ctx, RevocationChannel, digest)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("revoke key: %w", err)
+	}
+	return nil
+}
+
+func keyInfoFromHash(keyID string, f map[string]string) KeyInfo {
+	info := KeyInfo{
+		KeyID:      keyID,
+		FleetID:    f["fleet_id"],
+		VehicleID:  f["vehicle_id"],
+		Label:      f["label"],
+		Signing:    f["signing"] == "true",
+		CreatedAt:  unixField(f["created_at"]),
+		ReplacedBy: f["replaced_by"],
+	}
+	if f["expires_at"] != "" {
+		t := unixField(f["expires_at"])
+		info.ExpiresAt = &t
+	}
+	if f["revoked_at"] != "" {
+		t := unixField(f["revoked_at"])
+		info.RevokedAt = &t
+	}
+	return info
+}
+
+func unixField(v string) time.Time {
+	n, _ := strconv.ParseInt(v, 10, 64)
+	return time.Unix(n, 0).UTC()
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate random bytes: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
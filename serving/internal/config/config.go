@@ -27,11 +27,75 @@ type Config struct {
 	ValidAPIKeys        []string
 	AuthCacheTTLSeconds int
 
+	// WebSocket — browser origins allowed to open /ws; empty = same origin
+	// only, "*" = any
+	WSAllowedOrigins []string
+	// Seconds to drain sessions on shutdown before closing them
+	WSDrainSeconds int
+
 	// Background jobs
 	HeartbeatIntervalSeconds         int
 	StalenessThresholdSeconds        int
 	StopDetectorIntervalSeconds      int
 	DeviationDetectorIntervalSeconds int
+	// ETA model — how often segment travel times are relearned, from how
+	// many days of completed trips
+	SegmentLearnerIntervalSeconds int
+	SegmentHistoryDays            int
+	// How far past a stop's planned window its ETA may run before
+	// DELAY_PREDICTED fires
+	DelayAlertMarginSeconds int
+	// Unplanned stops — a vehicle on a trip stationary within
+	// UnplannedStopRadiusMeters, away from every planned stop, is recorded
+	// after UnplannedStopMinMinutes and alerted on after
+	// UnplannedStopAlertMinutes
+	UnplannedStopMinMinutes   int
+	UnplannedStopAlertMinutes int
+	UnplannedStopRadiusMeters int
+	// Lease TTL for job leadership — bounds failover time
+	LeaderLeaseSeconds int
+	// Days of job_runs history kept
+	JobHistoryDays int
+
+	// Cold-storage archive — empty backend disables archiving
+	ArchiveBackend         string
+	ArchiveLocalDir        string
+	ArchiveS3Endpoint      string
+	ArchiveS3Bucket        string
+	ArchiveS3AccessKey     string
+	ArchiveS3SecretKey     string
+	ArchiveS3UseSSL        bool
+	ArchiveIntervalSeconds int
+	ArchiveLeadHours       int
+
+	// Reverse geocoding — empty provider disables place names
+	GeocoderProvider          string
+	GeocoderOfflineFile       string
+	GeocoderHTTPURL           string
+	GeocoderHTTPUserAgent     string
+	GeocoderHTTPMinIntervalMs int
+	GeocoderCacheTTLSeconds   int
+	// Decimal places of the cached coordinates: 3 ≈ 110 m
+	GeocoderCachePrecision int
+
+	// Operator auth — OIDC issuer for dashboard bearer tokens. Empty issuer
+	// with the local issuer off leaves only API-key auth.
+	OIDCIssuerURL       string
+	OIDCAudience        string
+	OIDCLocalIssuer     bool
+	OIDCLocalSigningKey string
+	OIDCLocalEphemeral  bool // per-process signing key — single replica only
+	OIDCTokenTTLSeconds int
+
+	// Password grant throttling on the local issuer
+	OIDCLoginMaxFailures    int
+	OIDCLoginLockoutSeconds int
+	OIDCLoginsPerMinute     int
+
+	// Tracing — exporter: none | stdout | otlp
+	TracingExporter     string
+	TracingOTLPEndpoint string
+	TracingSampleRatio  float64
 }
 
 func Load() *Config {
@@ -51,10 +115,54 @@ func Load() *Config {
 		ValidAPIKeys:        strings.Split(getEnv("VALID_API_KEYS", ""), ","),
 		AuthCacheTTLSeconds: getEnvInt("AUTH_CACHE_TTL_SECONDS", 300),
 
+		WSAllowedOrigins: strings.Split(getEnv("WS_ALLOWED_ORIGINS", ""), ","),
+		WSDrainSeconds:   getEnvInt("WS_DRAIN_SECONDS", 10),
+
 		HeartbeatIntervalSeconds:         getEnvInt("HEARTBEAT_INTERVAL_SECONDS", 30),
 		StalenessThresholdSeconds:        getEnvInt("STALENESS_THRESHOLD_SECONDS", 60),
 		StopDetectorIntervalSeconds:      getEnvInt("STOP_DETECTOR_INTERVAL_SECONDS", 30),
 		DeviationDetectorIntervalSeconds: getEnvInt("DEVIATION_DETECTOR_INTERVAL_SECONDS", 60),
+		SegmentLearnerIntervalSeconds:    getEnvInt("SEGMENT_LEARNER_INTERVAL_SECONDS", 3600),
+		SegmentHistoryDays:               getEnvInt("SEGMENT_HISTORY_DAYS", 56),
+		DelayAlertMarginSeconds:          getEnvInt("DELAY_ALERT_MARGIN_SECONDS", 300),
+		UnplannedStopMinMinutes:          getEnvInt("UNPLANNED_STOP_MIN_MINUTES", 10),
+		UnplannedStopAlertMinutes:        getEnvInt("UNPLANNED_STOP_ALERT_MINUTES", 30),
+		UnplannedStopRadiusMeters:        getEnvInt("UNPLANNED_STOP_RADIUS_METERS", 50),
+		LeaderLeaseSeconds:               getEnvInt("LEADER_LEASE_SECONDS", 10),
+		JobHistoryDays:                   getEnvInt("JOB_HISTORY_DAYS", 14),
+
+		ArchiveBackend:         getEnv("ARCHIVE_BACKEND", ""),
+		ArchiveLocalDir:        getEnv("ARCHIVE_LOCAL_DIR", "./archive"),
+		ArchiveS3Endpoint:      getEnv("ARCHIVE_S3_ENDPOINT", ""),
+		ArchiveS3Bucket:        getEnv("ARCHIVE_S3_BUCKET", "fleet-telemetry-archive"),
+		ArchiveS3AccessKey:     getEnv("ARCHIVE_S3_ACCESS_KEY", ""),
+		ArchiveS3SecretKey:     getEnv("ARCHIVE_S3_SECRET_KEY", ""),
+		ArchiveS3UseSSL:        getEnv("ARCHIVE_S3_USE_SSL", "false") == "true",
+		ArchiveIntervalSeconds: getEnvInt("ARCHIVE_INTERVAL_SECONDS", 3600),
+		ArchiveLeadHours:       getEnvInt("ARCHIVE_LEAD_HOURS", 48),
+
+		GeocoderProvider:          getEnv("GEOCODER_PROVIDER", ""),
+		GeocoderOfflineFile:       getEnv("GEOCODER_OFFLINE_FILE", "./data/cities500.txt"),
+		GeocoderHTTPURL:           getEnv("GEOCODER_HTTP_URL", "https://nominatim.openstreetmap.org"),
+		GeocoderHTTPUserAgent:     getEnv("GEOCODER_HTTP_USER_AGENT", "fleet-monitor"),
+		GeocoderHTTPMinIntervalMs: getEnvInt("GEOCODER_HTTP_MIN_INTERVAL_MS", 1000),
+		GeocoderCacheTTLSeconds:   getEnvInt("GEOCODER_CACHE_TTL_SECONDS", 604800),
+		GeocoderCachePrecision:    getEnvInt("GEOCODER_CACHE_PRECISION", 3),
+
+		OIDCIssuerURL:       getEnv("OIDC_ISSUER_URL", ""),
+		OIDCAudience:        getEnv("OIDC_AUDIENCE", "fleet-monitor"),
+		OIDCLocalIssuer:     getEnv("OIDC_LOCAL_ISSUER", "false") == "true",
+		OIDCLocalSigningKey: getEnv("OIDC_LOCAL_SIGNING_KEY_FILE", ""),
+		OIDCLocalEphemeral:  getEnv("OIDC_LOCAL_EPHEMERAL_KEY", "false") == "true",
+		OIDCTokenTTLSeconds: getEnvInt("OIDC_TOKEN_TTL_SECONDS", 3600),
+
+		OIDCLoginMaxFailures:    getEnvInt("OIDC_LOGIN_MAX_FAILURES", 5),
+		OIDCLoginLockoutSeconds: getEnvInt("OIDC_LOGIN_LOCKOUT_SECONDS", 900),
+		OIDCLoginsPerMinute:     getEnvInt("OIDC_LOGINS_PER_MINUTE", 20),
+
+		TracingExporter:     getEnv("OTEL_TRACES_EXPORTER", "none"),
+		TracingOTLPEndpoint: getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "localhost:4318"),
+		TracingSampleRatio:  getEnvFloat("OTEL_TRACES_SAMPLE_RATIO", 0.1),
 	}
 }
 
@@ -76,3 +184,15 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return n
 }
+
+func getEnvFloat(key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fallback
+	}
+	return f
+}
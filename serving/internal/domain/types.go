@@ -49,6 +49,8 @@ const (
 	AlertLowFuel        AlertType = "LOW_FUEL"
 	AlertEngineOverheat AlertType = "ENGINE_OVERHEAT"
 	AlertRouteDeviation AlertType = "ROUTE_DEVIATION"
+	AlertDelayPredicted AlertType = "DELAY_PREDICTED"
+	AlertUnplannedStop  AlertType = "UNPLANNED_STOP"
 )
 
 type AlertSeverity string
@@ -98,8 +100,25 @@ type StopProgress struct {
 	Status     StopStatus `json:"status"`
 	ArrivedAt  *string    `json:"arrived_at,omitempty"`
 	DepartedAt *string    `json:"departed_at,omitempty"`
+	ETA        *string    `json:"eta,omitempty"` // remaining stops of an active trip
+
+	// Planned arrival window and, once the stop resolves, how arrival
+	// compared with it. Absent for stops without a window.
+	WindowStart         *string      `json:"window_start,omitempty"`
+	WindowEnd           *string      `json:"window_end,omitempty"`
+	Punctuality         *Punctuality `json:"punctuality,omitempty"`
+	ArrivalDeltaSeconds *int         `json:"arrival_delta_seconds,omitempty"` // <0 early, >0 late
 }
 
+type Punctuality string
+
+const (
+	PunctualityEarly  Punctuality = "EARLY"
+	PunctualityOnTime Punctuality = "ON_TIME"
+	PunctualityLate   Punctuality = "LATE"
+	PunctualityMissed Punctuality = "MISSED"
+)
+
 type Trip struct {
 	TripID             string     `json:"trip_id"`
 	VehicleID          string     `json:"vehicle_id"`
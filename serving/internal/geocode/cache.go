@@ -0,0 +1,71 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// lookupTimeout bounds a cache miss, so a slow provider delays a
+	// response or event by at most this much; the place is then left out.
+	lookupTimeout = 2 * time.Second
+	// missTTL caches "nothing here" so open sea is not asked about on
+	// every request.
+	missTTL = time.Hour
+)
+
+// Geocoder labels coordinates, caching results in Redis:
+//
+//	geocode:{lat}:{lng}  STRING label, coordinates rounded to precision
+//	                     decimals; "" = no place known
+//
+// A nil *Geocoder is valid and labels nothing, which is how geocoding is
+// switched off.
+type Geocoder struct {
+	provider  Provider
+	redis     *redis.Client
+	ttl       time.Duration
+	precision int
+}
+
+// NewGeocoder wraps provider with the cache. precision is decimal places
+// kept in the cache key: 3 ≈ 110 m, 2 ≈ 1.1 km.
+func NewGeocoder(provider Provider, rc *redis.Client, ttl time.Duration, precision int) *Geocoder {
+	return &Geocoder{provider: provider, redis: rc, ttl: ttl, precision: precision}
+}
+
+// Label returns a human-readable place for the coordinates, or "" when none
+// is known or the lookup failed.
+func (g *Geocoder) Label(ctx context.Context, lat, lng float64) string {
+	if g == nil || (lat == 0 && lng == 0) {
+		return ""
+	}
+	scale := math.Pow(10, float64(g.precision))
+	rLat, rLng := math.Round(lat*scale)/scale, math.Round(lng*scale)/scale
+	key := fmt.Sprintf("geocode:%.*f:%.*f", g.precision, rLat, g.precision, rLng)
+
+	if label, err := g.redis.Get(ctx, key).Result(); err == nil {
+		return label
+	}
+
+	lctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	place, err := g.provider.Reverse(lctx, rLat, rLng)
+	switch {
+	case errors.Is(err, ErrNoPlace):
+		g.redis.Set(ctx, key, "", missTTL)
+		return ""
+	case err != nil:
+		log.Printf("geocode: %s: %v", key, err)
+		return "" // not cached — worth another try
+	}
+	label := place.Label()
+	g.redis.Set(ctx, key, label, g.ttl)
+	return label
+}
@@ -0,0 +1,65 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoPlace is returned by Provider.Reverse when nothing is known near the
+// coordinates (open sea, outside the loaded extract).
+var ErrNoPlace = errors.New("geocode: no place near coordinates")
+
+// Place is the named location nearest to a coordinate.
+type Place struct {
+	Name       string  // town, suburb or street
+	Region     string  // state / province; may be empty
+	Country    string  // ISO 3166-1 alpha-2
+	DistanceKm float64 // from the coordinate to Name; 0 when it lies within it
+}
+
+// Label is the human-readable form shown to operators, e.g. "Pune, MH, IN"
+// or "4.2 km from Pune, MH, IN".
+func (p Place) Label() string {
+	name := p.Name
+	if p.Region != "" {
+		name += ", " + p.Region
+	}
+	if p.Country != "" {
+		name += ", " + p.Country
+	}
+	if p.DistanceKm < 1 {
+		return name
+	}
+	return fmt.Sprintf("%.1f km from %s", p.DistanceKm, name)
+}
+
+// Provider turns coordinates into a place.
+type Provider interface {
+	Reverse(ctx context.Context, lat, lng float64) (Place, error)
+}
+
+type Config struct {
+	Provider string // "offline" | "http"
+
+	OfflineFile string // GeoNames dump (cities500.txt, allCountries.txt, …)
+
+	HTTPURL       string // Nominatim-compatible base URL
+	HTTPUserAgent string
+	// HTTPMinInterval spaces requests to the provider; public Nominatim
+	// allows one per second.
+	HTTPMinInterval time.Duration
+}
+
+// NewProvider builds the provider selected by cfg.Provider.
+func NewProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case "offline":
+		return NewOfflineProvider(cfg.OfflineFile)
+	case "http":
+		return NewHTTPProvider(cfg.HTTPURL, cfg.HTTPUserAgent, cfg.HTTPMinInterval)
+	default:
+		return nil, fmt.Errorf("geocode: unknown provider %q", cfg.Provider)
+	}
+}
@@ -0,0 +1,161 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPProvider asks a Nominatim-compatible /reverse endpoint — public
+// Nominatim, a self-hosted instance, or a commercial service speaking the
+// same API.
+type HTTPProvider struct {
+	baseURL   string
+	userAgent string
+	client    *http.Client
+
+	// Requests are spaced minInterval apart; next is when the next may go.
+	mu          sync.Mutex
+	minInterval time.Duration
+	next        time.Time
+}
+
+func NewHTTPProvider(baseURL, userAgent string, minInterval time.Duration) (*HTTPProvider, error) {
+	if baseURL == "" {
+		return nil, errors.New("geocode: http provider requires a URL")
+	}
+	if userAgent == "" {
+		// Nominatim's usage policy rejects requests without one.
+		return nil, errors.New("geocode: http provider requires a user agent")
+	}
+	return &HTTPProvider{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		userAgent:   userAgent,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		minInterval: minInterval,
+	}, nil
+}
+
+type nominatimReverse struct {
+	Error   string `json:"error"`
+	Name    string `json:"name"`
+	Address struct {
+		Road        string `json:"road"`
+		Suburb      string `json:"suburb"`
+		Village     string `json:"village"`
+		Town        string `json:"town"`
+		City        string `json:"city"`
+		County      string `json:"county"`
+		State       string `json:"state"`
+		CountryCode string `json:"country_code"`
+	} `json:"address"`
+}
+
+func (p *HTTPProvider) Reverse(ctx context.Context, lat, lng float64) (Place, error) {
+	if err := p.wait(ctx); err != nil {
+		return Place{}, err
+	}
+
+	q := url.Values{}
+	q.Set("format", "jsonv2")
+	q.Set("lat", strconv.FormatFloat(lat, 'f', 6, 64))
+	q.Set("lon", strconv.FormatFloat(lng, 'f', 6, 64))
+	q.Set("zoom", "14") // suburb level
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/reverse?"+q.Encode(), nil)
+	if err != nil {
+		return Place{}, err
+	}
+	req.Header.Set("User-Agent", p.userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Place{}, fmt.Errorf("geocode: reverse: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Place{}, fmt.Errorf("geocode: reverse: %s", resp.Status)
+	}
+
+	var body nominatimReverse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Place{}, fmt.Errorf("geocode: decode reverse: %w", err)
+	}
+	if body.Error != "" {
+		return Place{}, ErrNoPlace // "Unable to geocode"
+	}
+
+	a := body.Address
+	name := firstNonEmpty(a.Suburb, a.Village, a.Town, a.City, a.Road, body.Name, a.County)
+	if name == "" {
+		return Place{}, ErrNoPlace
+	}
+	// A suburb alone is ambiguous; qualify it with its city.
+	if city := firstNonEmpty(a.City, a.Town); name == a.Suburb && city != "" && city != name {
+		name += ", " + city
+	}
+	return Place{
+		Name:    name,
+		Region:  a.State,
+		Country: strings.ToUpper(a.CountryCode),
+	}, nil
+}
+
+// errNoSlot means the caller's deadline falls before the next free slot.
+var errNoSlot = errors.New("geocode: rate limited past the request deadline")
+
+// wait blocks until this request's slot comes up. A slot past ctx's deadline
+// is refused without being taken, and a cancelled wait gives its slot back
+// if no later request has queued behind it, so callers that give up don't
+// push the queue out for everyone after them.
+func (p *HTTPProvider) wait(ctx context.Context) error {
+	if p.minInterval <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	now := time.Now()
+	slot := p.next
+	if slot.Before(now) {
+		slot = now
+	}
+	if deadline, ok := ctx.Deadline(); ok && slot.After(deadline) {
+		p.mu.Unlock()
+		return errNoSlot
+	}
+	p.next = slot.Add(p.minInterval)
+	p.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		if p.next.Equal(slot.Add(p.minInterval)) {
+			p.next = slot
+		}
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
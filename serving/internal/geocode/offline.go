@@ -0,0 +1,148 @@
+package geocode
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// cellDeg is the side of the grid cells places are bucketed into.
+	cellDeg = 0.5
+	// maxOfflineKm — further than this from any loaded place is ErrNoPlace.
+	maxOfflineKm  = 100.0
+	earthRadiusKm = 6371.0
+)
+
+// OfflineProvider answers from a GeoNames dump held in memory: the nearest
+// populated place to the coordinate. Any OSM extract converted to the
+// GeoNames column layout loads the same way.
+type OfflineProvider struct {
+	cells map[cell][]offlinePlace
+}
+
+type cell struct{ lat, lng int }
+
+type offlinePlace struct {
+	name     string
+	region   string
+	country  string
+	lat, lng float64
+}
+
+// GeoNames dump columns (tab separated, no header).
+const (
+	colName    = 1
+	colLat     = 4
+	colLng     = 5
+	colClass   = 6
+	colCountry = 8
+	colAdmin1  = 10
+	minColumns = 11
+)
+
+func NewOfflineProvider(path string) (*OfflineProvider, error) {
+	if path == "" {
+		return nil, errors.New("geocode: offline provider requires a GeoNames file")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("geocode: open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	p := &OfflineProvider{cells: make(map[cell][]offlinePlace)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // alternatenames can be long
+	loaded := 0
+	for scanner.Scan() {
+		cols := strings.Split(scanner.Text(), "\t")
+		// Feature class P: cities, towns, villages.
+		if len(cols) < minColumns || cols[colClass] != "P" {
+			continue
+		}
+		lat, err1 := strconv.ParseFloat(cols[colLat], 64)
+		lng, err2 := strconv.ParseFloat(cols[colLng], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		c := cellOf(lat, lng)
+		p.cells[c] = append(p.cells[c], offlinePlace{
+			name:    cols[colName],
+			region:  cols[colAdmin1],
+			country: cols[colCountry],
+			lat:     lat,
+			lng:     lng,
+		})
+		loaded++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("geocode: read %s: %w", path, err)
+	}
+	if loaded == 0 {
+		return nil, fmt.Errorf("geocode: no populated places in %s", path)
+	}
+	return p, nil
+}
+
+func cellOf(lat, lng float64) cell {
+	return cell{int(math.Floor(lat / cellDeg)), int(math.Floor(lng / cellDeg))}
+}
+
+// Reverse searches outward ring by ring from the coordinate's cell. A ring
+// is only conclusive once it lies further away than the best match so far.
+func (p *OfflineProvider) Reverse(ctx context.Context, lat, lng float64) (Place, error) {
+	origin := cellOf(lat, lng)
+	best, bestKm := offlinePlace{}, math.Inf(1)
+	// The narrowest side of a cell is its longitude span, which shrinks
+	// towards the poles.
+	cellKm := cellDeg * 111 * math.Max(math.Cos(lat*math.Pi/180), 0.1)
+	maxRing := int(math.Ceil(maxOfflineKm/cellKm)) + 1
+	for ring := 0; ring <= maxRing; ring++ {
+		if float64(ring-1)*cellKm > bestKm {
+			break
+		}
+		for dLat := -ring; dLat <= ring; dLat++ {
+			for dLng := -ring; dLng <= ring; dLng++ {
+				if max(abs(dLat), abs(dLng)) != ring {
+					continue // inner rings are done
+				}
+				for _, pl := range p.cells[cell{origin.lat + dLat, origin.lng + dLng}] {
+					if km := haversineKm(lat, lng, pl.lat, pl.lng); km < bestKm {
+						best, bestKm = pl, km
+					}
+				}
+			}
+		}
+	}
+	if bestKm > maxOfflineKm {
+		return Place{}, ErrNoPlace
+	}
+	return Place{
+		Name:       best.name,
+		Region:     best.region,
+		Country:    best.country,
+		DistanceKm: bestKm,
+	}, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*
+			math.Sin(dLng/2)*math.Sin(dLng/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
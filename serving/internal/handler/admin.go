@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AdminHandler serves operator-only maintenance endpoints:
+//
+//	GET /api/v1/admin/storage — telemetry chunk sizes, compression and policy schedule
+type AdminHandler struct {
+	tsStore *pgxpool.Pool
+}
+
+func NewAdminHandler(tsStore *pgxpool.Pool) *AdminHandler {
+	return &AdminHandler{tsStore: tsStore}
+}
+
+// ── Response types ────────────────────────────────────────────────────────────
+
+// StorageResponse summarises how vehicle_telemetry is laid out on disk and
+// when the storage tiers configured by init_db will next run.
+type StorageResponse struct {
+	Hypertable       string          `json:"hypertable"`
+	TotalBytes       int64           `json:"total_bytes"`
+	ChunkCount       int             `json:"chunk_count"`
+	CompressedChunks int             `json:"compressed_chunks"`
+	BeforeBytes      int64           `json:"compression_before_bytes"`
+	AfterBytes       int64           `json:"compression_after_bytes"`
+	CompressionRatio *float64        `json:"compression_ratio"` // nil until a chunk is compressed
+	Chunks           []ChunkInfo     `json:"chunks"`
+	Policies         []PolicyRunInfo `json:"policies"`
+}
+
+// ChunkInfo is one time partition of vehicle_telemetry.
+type ChunkInfo struct {
+	ChunkName    string `json:"chunk_name"`
+	RangeStart   string `json:"range_start"`
+	RangeEnd     string `json:"range_end"`
+	IsCompressed bool   `json:"is_compressed"`
+	TotalBytes   int64  `json:"total_bytes"`
+}
+
+// PolicyRunInfo is a background TimescaleDB job acting on telemetry storage.
+type PolicyRunInfo struct {
+	JobID         int     `json:"job_id"`
+	Kind          string  `json:"kind"` // compression | retention | raw_payload | cagg_refresh
+	Target        string  `json:"target"`
+	ScheduleEvery string  `json:"schedule_interval"`
+	Config        *string `json:"config,omitempty"`
+	LastRunStatus *string `json:"last_run_status,omitempty"`
+	LastRunAt     *string `json:"last_run_at,omitempty"`
+	NextRunAt     *string `json:"next_run_at,omitempty"`
+}
+
+// ── Handlers ──────────────────────────────────────────────────────────────────
+
+// GET /api/v1/admin/storage
+func (h *AdminHandler) HandleStorage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	resp := StorageResponse{Hypertable: "vehicle_telemetry"}
+
+	// ── Chunks — newest first ────────────────────────────────────────────────
+	rows, err := h.tsStore.Query(ctx, `
+		SELECT c.chunk_name, c.range_start, c.range_end, c.is_compressed,
+		       COALESCE(s.total_bytes, 0)
+		FROM timescaledb_information.chunks c
+		LEFT JOIN chunks_detailed_size('vehicle_telemetry') s
+		       ON s.chunk_schema = c.chunk_schema AND s.chunk_name = c.chunk_name
+		WHERE c.hypertable_name = 'vehicle_telemetry'
+		ORDER BY c.range_start DESC
+	`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query telemetry chunks")
+		return
+	}
+	defer rows.Close()
+
+	resp.Chunks = []ChunkInfo{}
+	for rows.Next() {
+		var c ChunkInfo
+		var start, end time.Time
+		if e := rows.Scan(&c.ChunkName, &start, &end, &c.IsCompressed, &c.TotalBytes); e != nil {
+			continue
+		}
+		c.RangeStart = start.Format(time.RFC3339)
+		c.RangeEnd = end.Format(time.RFC3339)
+		resp.Chunks = append(resp.Chunks, c)
+		resp.ChunkCount++
+		resp.TotalBytes += c.TotalBytes
+		if c.IsCompressed {
+			resp.CompressedChunks++
+		}
+	}
+	rows.Close()
+
+	// ── Compression ratio ────────────────────────────────────────────────────
+	// hypertable_compression_stats returns NULL sizes until the first chunk
+	// has been compressed.
+	var before, after *int64
+	err = h.tsStore.QueryRow(ctx, `
+		SELECT before_compression_total_bytes, after_compression_total_bytes
+		FROM hypertable_compression_stats('vehicle_telemetry')
+	`).Scan(&before, &after)
+	if err == nil && before != nil && after != nil && *after > 0 {
+		resp.BeforeBytes = *before
+		resp.AfterBytes = *after
+		ratio := float64(*before) / float64(*after)
+		resp.CompressionRatio = &ratio
+	}
+
+	// ── Policy schedule ──────────────────────────────────────────────────────
+	// Everything that touches telemetry storage: the three tiers on the
+	// hypertable plus the continuous aggregate refreshes built on top of it.
+	policyRows, err := h.tsStore.Query(ctx, `
+		SELECT j.job_id,
+		       CASE
+		         WHEN j.proc_name = 'policy_compression'   THEN 'compression'
+		         WHEN j.proc_name = 'policy_retention'     THEN 'retention'
+		         WHEN j.proc_name = 'drop_archived_chunks' THEN 'retention'
+		         WHEN j.proc_name = 'prune_raw_payload'    THEN 'raw_payload'
+		         WHEN j.proc_name = 'policy_refresh_continuous_aggregate' THEN 'cagg_refresh'
+		         ELSE j.proc_name
+		       END,
+		       COALESCE(ca.view_name, j.hypertable_name, 'vehicle_telemetry'),
+		       j.schedule_interval::TEXT,
+		       j.config::TEXT,
+		       s.last_run_status,
+		       s.last_run_started_at,
+		       s.next_start
+		FROM timescaledb_information.jobs j
+		LEFT JOIN timescaledb_information.job_stats s ON s.job_id = j.job_id
+		LEFT JOIN timescaledb_information.continuous_aggregates ca
+		       ON ca.materialization_hypertable_name = j.hypertable_name
+		WHERE j.proc_name IN (
+		        'policy_compression', 'policy_retention', 'drop_archived_chunks',
+		        'prune_raw_payload', 'policy_refresh_continuous_aggregate'
+		      )
+		ORDER BY s.next_start NULLS LAST, j.job_id
+	`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query storage policies")
+		return
+	}
+	defer policyRows.Close()
+
+	resp.Policies = []PolicyRunInfo{}
+	for policyRows.Next() {
+		var p PolicyRunInfo
+		var lastRun, nextRun *time.Time
+		if e := policyRows.Scan(
+			&p.JobID, &p.Kind, &p.Target, &p.ScheduleEvery, &p.Config,
+			&p.LastRunStatus, &lastRun, &nextRun,
+		); e != nil {
+			continue
+		}
+		if lastRun != nil {
+			s := lastRun.Format(time.RFC3339)
+			p.LastRunAt = &s
+		}
+		if nextRun != nil {
+			s := nextRun.Format(time.RFC3339)
+			p.NextRunAt = &s
+		}
+		resp.Policies = append(resp.Policies, p)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
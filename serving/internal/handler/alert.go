@@ -2,7 +2,6 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -12,6 +11,8 @@ import (
 	"github.com/redis/go-redis/v9"
 
 	"fleet-monitor/serving/internal/domain"
+	"fleet-monitor/serving/internal/geocode"
+	"fleet-monitor/serving/internal/middleware"
 )
 
 // AlertHandler serves all alert-management endpoints:
@@ -23,14 +24,16 @@ import (
 //	POST /api/v1/alerts/{alert_id}/unacknowledge — reverse an erroneous acknowledge
 //	GET  /api/v1/fleet/{fleet_id}/alerts         — paginated fleet alert history
 type AlertHandler struct {
-	redis   *redis.Client
-	tsStore *pgxpool.Pool // single TimescaleDB pool — all tables live here
+	redis    *redis.Client
+	tsStore  *pgxpool.Pool     // single TimescaleDB pool — all tables live here
+	geocoder *geocode.Geocoder // nil when reverse geocoding is disabled
 }
 
-func NewAlertHandler(redisClient *redis.Client, tsStore *pgxpool.Pool) *AlertHandler {
+func NewAlertHandler(redisClient *redis.Client, tsStore *pgxpool.Pool, geocoder *geocode.Geocoder) *AlertHandler {
 	return &AlertHandler{
-		redis:   redisClient,
-		tsStore: tsStore,
+		redis:    redisClient,
+		tsStore:  tsStore,
+		geocoder: geocoder,
 	}
 }
 
@@ -140,6 +143,7 @@ type alertDetail struct {
 	DriverPhone        *string              `json:"driver_phone,omitempty"`
 	DriverLicense      *string              `json:"driver_license,omitempty"`
 	TelemetryAtAlert   *domain.VehicleState `json:"telemetry_at_alert,omitempty"`
+	Place              string               `json:"place,omitempty"` // where telemetry_at_alert was taken
 }
 
 // GET /api/v1/alerts/{alert_id}
@@ -227,6 +231,7 @@ func (h *AlertHandler) HandleAlertDetail(w http.ResponseWriter, r *http.Request)
 	if e == nil {
 		snap.Timestamp = snapTs.Unix()
 		detail.TelemetryAtAlert = &snap
+		detail.Place = h.geocoder.Label(ctx, snap.Lat, snap.Lng)
 	}
 
 	writeJSON(w, http.StatusOK, detail)
@@ -234,10 +239,6 @@ func (h *AlertHandler) HandleAlertDetail(w http.ResponseWriter, r *http.Request)
 
 // ── Acknowledge Workflow ──────────────────────────────────────────────────────
 
-type operatorBody struct {
-	OperatorName string `json:"operator"`
-}
-
 // POST /api/v1/alerts/{alert_id}/acknowledge
 func (h *AlertHandler) HandleAcknowledge(w http.ResponseWriter, r *http.Request) {
 	alertID, err := strconv.ParseInt(r.PathValue("alert_id"), 10, 64)
@@ -246,18 +247,15 @@ func (h *AlertHandler) HandleAcknowledge(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var body operatorBody
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.OperatorName == "" {
-		writeError(w, http.StatusBadRequest, `request body must contain "operator" field`)
-		return
-	}
+	// The operator is whoever the token says, never a name from the body.
+	operator := middleware.PrincipalFromContext(r.Context()).Subject
 
 	ctx := r.Context()
 	result, err := h.tsStore.Exec(ctx, `
 		UPDATE vehicle_alerts
 		SET acknowledged_at = NOW(), acknowledged_by = $1
 		WHERE id = $2 AND acknowledged_at IS NULL
-	`, body.OperatorName, alertID)
+	`, operator, alertID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to acknowledge alert")
 		return
@@ -269,7 +267,7 @@ func (h *AlertHandler) HandleAcknowledge(w http.ResponseWriter, r *http.Request)
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"alert_id":        alertID,
-		"acknowledged_by": body.OperatorName,
+		"acknowledged_by": operator,
 		"acknowledged_at": time.Now().UTC().Format(time.RFC3339),
 	})
 }
@@ -282,18 +280,14 @@ func (h *AlertHandler) HandleResolve(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var body operatorBody
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.OperatorName == "" {
-		writeError(w, http.StatusBadRequest, `request body must contain "operator" field`)
-		return
-	}
+	operator := middleware.PrincipalFromContext(r.Context()).Subject
 
 	ctx := r.Context()
 	result, err := h.tsStore.Exec(ctx, `
 		UPDATE vehicle_alerts
 		SET resolved_at = NOW(), resolved_by = $1
 		WHERE id = $2 AND resolved_at IS NULL
-	`, body.OperatorName, alertID)
+	`, operator, alertID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to resolve alert")
 		return
@@ -305,7 +299,7 @@ func (h *AlertHandler) HandleResolve(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"alert_id":    alertID,
-		"resolved_by": body.OperatorName,
+		"resolved_by": operator,
 		"resolved_at": time.Now().UTC().Format(time.RFC3339),
 	})
 }
@@ -492,6 +486,9 @@ func (h *AlertHandler) isConditionActive(ctx context.Context, vehicleID string,
 		devKey := fmt.Sprintf("vehicle:%s:deviation", vehicleID)
 		v, _ := h.redis.Get(ctx, devKey).Result()
 		return v == "true"
+	case domain.AlertUnplannedStop:
+		n, _ := h.redis.Exists(ctx, fmt.Sprintf("vehicle:%s:unplanned_stop", vehicleID)).Result()
+		return n > 0
 	}
 	return false
 }
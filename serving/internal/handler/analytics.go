@@ -3,7 +3,11 @@ package handler
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
@@ -12,8 +16,10 @@ import (
 )
 
 // AnalyticsHandler serves the live summary numbers shown at the top of the
-// operations dashboard. Each metric answers one operational question.
-// Source mix: Redis (live state), TimescaleDB (alerts, registry, trips — all one DB).
+// operations dashboard, and the historical timeseries behind its charts.
+// Each metric answers one operational question.
+// Source mix: Redis (live state), TimescaleDB (alerts, registry, trips, continuous
+// aggregates — all one DB).
 type AnalyticsHandler struct {
 	redis   *redis.Client
 	tsStore *pgxpool.Pool // single TimescaleDB pool — all tables live here
@@ -125,53 +131,521 @@ func (h *AnalyticsHandler) HandleSummary(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, http.StatusOK, resp)
 }
 
-// countLiveStates scans all vehicle:{id}:state hashes that belong to the given fleet.
+// countLiveStates counts the fleet's reporting vehicles by state.
 // Returns (activeMoving, idle, parked, liveCount, error).
 //
-// Design note: we SCAN for all state keys then HMGET each. This is O(n) on vehicle
-// count but fleets are bounded (hundreds, not millions). For larger deployments,
-// maintain a fleet:{id}:vehicles SET in Redis and use SMEMBERS to avoid SCAN.
+// Reads the fleet:{id}:vehicles SET kept by ingestion rather than SCANning
+// the keyspace, so the cost follows this fleet's size, not every fleet's.
 func (h *AnalyticsHandler) countLiveStates(ctx context.Context, fleetID string) (moving, idle, parked, total int, err error) {
-	pattern := "vehicle:*:state"
-	var cursor uint64
+	states, err := fleetLiveStates(ctx, h.redis, fleetID)
+	if err != nil {
+		return
+	}
+	for _, st := range states {
+		total++
+		switch liveStatus(st) {
+		case "moving":
+			moving++
+		case "idle":
+			idle++
+		default:
+			parked++
+		}
+	}
+	return
+}
+
+// ── Timeseries ────────────────────────────────────────────────────────────────
+
+// timeseriesMetrics maps a ?metric= value to the SQL expression that folds the
+// per-vehicle rows of one bucket into a fleet-level value. Only keys in this
+// map ever reach the query string.
+var timeseriesMetrics = map[string]string{
+	"max_speed_kmh":     "MAX(max_speed_kmh)",
+	"avg_speed_kmh":     "SUM(avg_speed_kmh * sample_count) / NULLIF(SUM(sample_count), 0)",
+	"engine_on_minutes": "SUM(engine_on_minutes)",
+	"moving_minutes":    "SUM(moving_minutes)",
+}
+
+// timeseriesDeltas are the metrics measured between readings. Each is the
+// per-vehicle, per-hour expression over telemetry_hourly with prev_* set to
+// the vehicle's previous hour, so the travel and burn between two hours is
+// counted once, in the later one. A refuel between hours counts as no burn.
+var timeseriesDeltas = map[string]string{
+	"distance_km":   "GREATEST(odometer_last_km - COALESCE(prev_odometer_km, odometer_first_km), 0)",
+	"fuel_used_pct": "fuel_used_pct + GREATEST(COALESCE(prev_fuel_pct, fuel_first_pct) - fuel_first_pct, 0)",
+}
+
+// timeseriesDeltaLookback is how far before from the previous hour of each
+// vehicle is looked for; a vehicle silent for longer starts from its own
+// first reading in the range.
+const timeseriesDeltaLookback = 24 * time.Hour
+
+// timeseriesViews maps a ?bucket= value to its continuous aggregate and the
+// default look-back window when ?from= is omitted.
+var timeseriesViews = map[string]struct {
+	view     string
+	width    string // bucket width, for rolling up timeseriesDeltas
+	lookback time.Duration
+}{
+	"hour": {view: "telemetry_hourly", width: "1 hour", lookback: 24 * time.Hour},
+	"day":  {view: "telemetry_daily", width: "1 day", lookback: 30 * 24 * time.Hour},
+}
+
+// TimeseriesPoint is one bucket of a fleet analytics timeseries.
+type TimeseriesPoint struct {
+	Bucket   string  `json:"bucket"` // RFC3339, start of bucket
+	Value    float64 `json:"value"`
+	Vehicles int     `json:"vehicles"` // vehicles that reported in this bucket
+}
+
+// TimeseriesResponse is the payload for the fleet analytics chart.
+type TimeseriesResponse struct {
+	FleetID   string            `json:"fleet_id"`
+	VehicleID string            `json:"vehicle_id,omitempty"`
+	Metric    string            `json:"metric"`
+	Bucket    string            `json:"bucket"`
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Points    []TimeseriesPoint `json:"points"`
+}
+
+// GET /api/v1/fleet/{fleet_id}/analytics/timeseries
+//
+// Query params: metric (required), bucket (hour|day, default hour),
+// from, to (RFC3339), vehicle_id (optional — single vehicle instead of fleet).
+// Reads from the telemetry_hourly / telemetry_daily continuous aggregates,
+// never from raw vehicle_telemetry. distance_km and fuel_used_pct are rolled
+// up from telemetry_hourly at either bucket size; see timeseriesDeltas.
+func (h *AnalyticsHandler) HandleTimeseries(w http.ResponseWriter, r *http.Request) {
+	fleetID := r.PathValue("fleet_id")
+	if fleetID == "" {
+		writeError(w, http.StatusBadRequest, "fleet_id path parameter is required")
+		return
+	}
+
+	q := r.URL.Query()
+
+	metric := q.Get("metric")
+	expr, ok := timeseriesMetrics[metric]
+	delta, isDelta := timeseriesDeltas[metric]
+	if !ok && !isDelta {
+		writeError(w, http.StatusBadRequest,
+			"metric must be one of: distance_km, max_speed_kmh, avg_speed_kmh, fuel_used_pct, engine_on_minutes, moving_minutes")
+		return
+	}
+
+	bucket := q.Get("bucket")
+	if bucket == "" {
+		bucket = "hour"
+	}
+	src, ok := timeseriesViews[bucket]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "bucket must be one of: hour, day")
+		return
+	}
 
-	for {
-		var keys []string
-		keys, cursor, err = h.redis.Scan(ctx, cursor, pattern, 100).Result()
+	to := time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
 		if err != nil {
+			writeError(w, http.StatusBadRequest, "to must be RFC3339")
 			return
 		}
+		to = t.UTC()
+	}
+	from := to.Add(-src.lookback)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "from must be RFC3339")
+			return
+		}
+		from = t.UTC()
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	args := []interface{}{fleetID, from, to}
+	where := "WHERE fleet_id = $1 AND bucket >= $2 AND bucket < $3"
+
+	vehicleID := q.Get("vehicle_id")
+	if vehicleID != "" {
+		args = append(args, vehicleID)
+		where += fmt.Sprintf(" AND vehicle_id = $%d", len(args))
+	}
+
+	query := `
+		SELECT bucket, COALESCE(` + expr + `, 0), COUNT(DISTINCT vehicle_id)
+		FROM ` + src.view + `
+		` + where + `
+		GROUP BY bucket
+		ORDER BY bucket
+	`
+	if isDelta {
+		// The inner scan starts a lookback early so the first hour in range
+		// has its predecessor; those extra hours are dropped after LAG.
+		args[1] = from.Add(-timeseriesDeltaLookback)
+		args = append(args, from)
+		query = `
+			SELECT time_bucket(INTERVAL '` + src.width + `', bucket) AS b,
+			       COALESCE(SUM(` + delta + `), 0), COUNT(DISTINCT vehicle_id)
+			FROM (
+				SELECT bucket, vehicle_id,
+				       odometer_first_km, odometer_last_km, fuel_first_pct, fuel_used_pct,
+				       LAG(odometer_last_km) OVER w AS prev_odometer_km,
+				       LAG(fuel_last_pct)    OVER w AS prev_fuel_pct
+				FROM telemetry_hourly
+				` + where + `
+				WINDOW w AS (PARTITION BY vehicle_id ORDER BY bucket)
+			) h
+			WHERE time_bucket(INTERVAL '` + src.width + `', bucket) >= $` + fmt.Sprint(len(args)) + `
+			GROUP BY b
+			ORDER BY b
+		`
+	}
+
+	rows, err := h.tsStore.Query(r.Context(), query, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query analytics timeseries")
+		return
+	}
+	defer rows.Close()
+
+	points := []TimeseriesPoint{}
+	for rows.Next() {
+		var p TimeseriesPoint
+		var ts time.Time
+		if e := rows.Scan(&ts, &p.Value, &p.Vehicles); e != nil {
+			continue
+		}
+		p.Bucket = ts.UTC().Format(time.RFC3339)
+		points = append(points, p)
+	}
+
+	writeJSON(w, http.StatusOK, TimeseriesResponse{
+		FleetID:   fleetID,
+		VehicleID: vehicleID,
+		Metric:    metric,
+		Bucket:    bucket,
+		From:      from.Format(time.RFC3339),
+		To:        to.Format(time.RFC3339),
+		Points:    points,
+	})
+}
+
+// ── On-time performance ───────────────────────────────────────────────────────
+
+// OnTimeStats counts resolved stops that had a planned arrival window.
+type OnTimeStats struct {
+	Windowed       int     `json:"windowed"`
+	OnTime         int     `json:"on_time"`
+	Early          int     `json:"early"`
+	Late           int     `json:"late"`
+	Missed         int     `json:"missed"`
+	OnTimePct      float64 `json:"on_time_pct"`      // on_time / windowed × 100
+	AvgLateSeconds float64 `json:"avg_late_seconds"` // over LATE stops only
+}
+
+type RouteOnTime struct {
+	RouteID   string `json:"route_id"`
+	RouteName string `json:"route_name"`
+	OnTimeStats
+}
+
+type OnTimeResponse struct {
+	FleetID string        `json:"fleet_id"`
+	From    string        `json:"from"`
+	To      string        `json:"to"`
+	Total   OnTimeStats   `json:"total"`
+	Routes  []RouteOnTime `json:"routes"` // worst on-time percentage first
+}
+
+// GET /api/v1/fleet/{fleet_id}/analytics/on-time
+//
+// Query params: from, to (RFC3339, default the last 7 days), matched against
+// each stop's window_end. Stops without a window or not yet resolved are
+// left out.
+func (h *AnalyticsHandler) HandleOnTime(w http.ResponseWriter, r *http.Request) {
+	fleetID := r.PathValue("fleet_id")
+	from, to, msg := parseRange(r, 7*24*time.Hour)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	rows, err := h.tsStore.Query(r.Context(), `
+		SELECT rr.route_id, rr.route_name,
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE tsp.punctuality = 'ON_TIME'),
+		       COUNT(*) FILTER (WHERE tsp.punctuality = 'EARLY'),
+		       COUNT(*) FILTER (WHERE tsp.punctuality = 'LATE'),
+		       COUNT(*) FILTER (WHERE tsp.punctuality = 'MISSED'),
+		       COALESCE(SUM(tsp.arrival_delta_seconds) FILTER (WHERE tsp.punctuality = 'LATE'), 0)
+		FROM trip_stop_progress tsp
+		JOIN trip t             ON t.trip_id    = tsp.trip_id
+		JOIN vehicle_registry v ON v.vehicle_id = t.vehicle_id
+		JOIN route_registry rr  ON rr.route_id  = t.route_id
+		WHERE v.fleet_id = $1
+		  AND tsp.punctuality IS NOT NULL
+		  AND tsp.window_end >= $2 AND tsp.window_end < $3
+		GROUP BY rr.route_id, rr.route_name
+	`, fleetID, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query on-time performance")
+		return
+	}
+	defer rows.Close()
+
+	resp := OnTimeResponse{
+		FleetID: fleetID,
+		From:    from.Format(time.RFC3339),
+		To:      to.Format(time.RFC3339),
+		Routes:  []RouteOnTime{},
+	}
+	var totalLateSeconds float64
+	for rows.Next() {
+		var rt RouteOnTime
+		var lateSeconds float64
+		if e := rows.Scan(
+			&rt.RouteID, &rt.RouteName,
+			&rt.Windowed, &rt.OnTime, &rt.Early, &rt.Late, &rt.Missed, &lateSeconds,
+		); e != nil {
+			continue
+		}
+		rt.finish(lateSeconds)
+		resp.Routes = append(resp.Routes, rt)
+
+		resp.Total.Windowed += rt.Windowed
+		resp.Total.OnTime += rt.OnTime
+		resp.Total.Early += rt.Early
+		resp.Total.Late += rt.Late
+		resp.Total.Missed += rt.Missed
+		totalLateSeconds += lateSeconds
+	}
+	resp.Total.finish(totalLateSeconds)
+	sort.Slice(resp.Routes, func(i, j int) bool {
+		return resp.Routes[i].OnTimePct < resp.Routes[j].OnTimePct
+	})
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// finish derives the percentages from the counts.
+func (s *OnTimeStats) finish(lateSeconds float64) {
+	if s.Windowed > 0 {
+		s.OnTimePct = math.Round(float64(s.OnTime)/float64(s.Windowed)*1000) / 10
+	}
+	if s.Late > 0 {
+		s.AvgLateSeconds = math.Round(lateSeconds / float64(s.Late))
+	}
+}
+
+// parseRange reads the from / to query params (RFC3339). to defaults to now,
+// from to lookback before to. A non-empty msg describes a bad range.
+func parseRange(r *http.Request, lookback time.Duration) (from, to time.Time, msg string) {
+	q := r.URL.Query()
+	to = time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, "to must be RFC3339"
+		}
+		to = t.UTC()
+	}
+	from = to.Add(-lookback)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, "from must be RFC3339"
+		}
+		from = t.UTC()
+	}
+	if !from.Before(to) {
+		return from, to, "from must be before to"
+	}
+	return from, to, ""
+}
+
+// ── Dwell time ────────────────────────────────────────────────────────────────
+
+// StopDwell summarises time spent at one planned stop, arrival to departure.
+type StopDwell struct {
+	RouteID       string  `json:"route_id"`
+	StopID        string  `json:"stop_id"`
+	StopName      string  `json:"stop_name"`
+	Sequence      int     `json:"sequence"`
+	Visits        int     `json:"visits"`
+	AvgSeconds    float64 `json:"avg_seconds"`
+	MedianSeconds float64 `json:"median_seconds"`
+	P90Seconds    float64 `json:"p90_seconds"`
+	MaxSeconds    float64 `json:"max_seconds"`
+}
+
+// GET /api/v1/fleet/{fleet_id}/analytics/dwell
+//
+// Query params: route_id (optional), from, to (RFC3339, default the last 7
+// days), matched against arrival. Only visits with both an arrival and a
+// departure count.
+func (h *AnalyticsHandler) HandleDwell(w http.ResponseWriter, r *http.Request) {
+	fleetID := r.PathValue("fleet_id")
+	from, to, msg := parseRange(r, 7*24*time.Hour)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	args := []interface{}{fleetID, from, to}
+	where := "WHERE v.fleet_id = $1 AND tsp.arrived_at >= $2 AND tsp.arrived_at < $3 AND tsp.departed_at IS NOT NULL"
+	routeID := r.URL.Query().Get("route_id")
+	if routeID != "" {
+		args = append(args, routeID)
+		where += fmt.Sprintf(" AND rs.route_id = $%d", len(args))
+	}
+
+	rows, err := h.tsStore.Query(r.Context(), `
+		SELECT rs.route_id, rs.stop_id, rs.stop_name, rs.stop_sequence,
+		       COUNT(*),
+		       AVG(d.seconds),
+		       percentile_cont(0.5) WITHIN GROUP (ORDER BY d.seconds),
+		       percentile_cont(0.9) WITHIN GROUP (ORDER BY d.seconds),
+		       MAX(d.seconds)
+		FROM trip_stop_progress tsp
+		JOIN trip t             ON t.trip_id    = tsp.trip_id
+		JOIN vehicle_registry v ON v.vehicle_id = t.vehicle_id
+		JOIN route_stops rs     ON rs.stop_id   = tsp.stop_id
+		CROSS JOIN LATERAL (
+			SELECT EXTRACT(EPOCH FROM tsp.departed_at - tsp.arrived_at)::float8 AS seconds
+		) d
+		`+where+`
+		GROUP BY rs.route_id, rs.stop_id, rs.stop_name, rs.stop_sequence
+		ORDER BY rs.route_id, rs.stop_sequence
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query dwell times")
+		return
+	}
+	defer rows.Close()
+
+	stops := []StopDwell{}
+	for rows.Next() {
+		var sd StopDwell
+		if e := rows.Scan(
+			&sd.RouteID, &sd.StopID, &sd.StopName, &sd.Sequence,
+			&sd.Visits, &sd.AvgSeconds, &sd.MedianSeconds, &sd.P90Seconds, &sd.MaxSeconds,
+		); e != nil {
+			continue
+		}
+		sd.AvgSeconds = math.Round(sd.AvgSeconds)
+		stops = append(stops, sd)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"fleet_id": fleetID,
+		"route_id": routeID,
+		"from":     from.Format(time.RFC3339),
+		"to":       to.Format(time.RFC3339),
+		"stops":    stops,
+	})
+}
+
+// ── Unplanned stops ───────────────────────────────────────────────────────────
+
+type UnplannedStop struct {
+	ID              int64   `json:"id"`
+	VehicleID       string  `json:"vehicle_id"`
+	TripID          string  `json:"trip_id"`
+	Lat             float64 `json:"lat"`
+	Lng             float64 `json:"lng"`
+	StartedAt       string  `json:"started_at"`
+	EndedAt         *string `json:"ended_at"`         // null while the vehicle is still there
+	DurationSeconds int     `json:"duration_seconds"` // so far, for an open stop
+	AlertID         *int64  `json:"alert_id"`
+}
+
+// GET /api/v1/fleet/{fleet_id}/analytics/unplanned-stops
+//
+// Query params: vehicle_id, trip_id, min_minutes, from, to (RFC3339, default
+// the last 7 days, matched against started_at), page, limit. Newest first.
+func (h *AnalyticsHandler) HandleUnplannedStops(w http.ResponseWriter, r *http.Request) {
+	fleetID := r.PathValue("fleet_id")
+	q := r.URL.Query()
+	from, to, msg := parseRange(r, 7*24*time.Hour)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	page, limit := parsePagination(q.Get("page"), q.Get("limit"))
 
-		for _, key := range keys {
-			vals, hErr := h.redis.HMGet(ctx, key, "fleet_id", "is_moving", "engine_on").Result()
-			if hErr != nil {
-				continue
-			}
-			// Skip hashes from other fleets or with missing fields.
-			if len(vals) < 3 || vals[0] == nil {
-				continue
-			}
-			if fmt.Sprintf("%v", vals[0]) != fleetID {
-				continue
-			}
-
-			isMoving := parseBool(vals[1])
-			engineOn := parseBool(vals[2])
-
-			total++
-			switch {
-			case isMoving:
-				moving++
-			case engineOn && !isMoving:
-				idle++
-			default:
-				parked++
-			}
+	args := []interface{}{fleetID, from, to}
+	where := "WHERE fleet_id = $1 AND started_at >= $2 AND started_at < $3"
+	if v := q.Get("vehicle_id"); v != "" {
+		args = append(args, v)
+		where += fmt.Sprintf(" AND vehicle_id = $%d", len(args))
+	}
+	if v := q.Get("trip_id"); v != "" {
+		args = append(args, v)
+		where += fmt.Sprintf(" AND trip_id = $%d", len(args))
+	}
+	if v := q.Get("min_minutes"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 0 {
+			writeError(w, http.StatusBadRequest, "min_minutes must be a non-negative integer")
+			return
 		}
+		args = append(args, minutes*60)
+		where += fmt.Sprintf(" AND COALESCE(duration_seconds, EXTRACT(EPOCH FROM NOW() - started_at)::int) >= $%d", len(args))
+	}
 
-		if cursor == 0 {
-			break
+	ctx := r.Context()
+	var total int
+	if err := h.tsStore.QueryRow(ctx, `SELECT COUNT(*) FROM unplanned_stops `+where, args...).Scan(&total); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to count unplanned stops")
+		return
+	}
+
+	args = append(args, limit, (page-1)*limit)
+	rows, err := h.tsStore.Query(ctx, `
+		SELECT id, vehicle_id, trip_id, latitude, longitude, started_at, ended_at,
+		       COALESCE(duration_seconds, EXTRACT(EPOCH FROM NOW() - started_at)::int),
+		       alert_id
+		FROM unplanned_stops
+		`+where+fmt.Sprintf(`
+		ORDER BY started_at DESC
+		LIMIT $%d OFFSET $%d`, len(args)-1, len(args)),
+		args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query unplanned stops")
+		return
+	}
+	defer rows.Close()
+
+	stops := []UnplannedStop{}
+	for rows.Next() {
+		var us UnplannedStop
+		var startedAt time.Time
+		var endedAt *time.Time
+		if e := rows.Scan(
+			&us.ID, &us.VehicleID, &us.TripID, &us.Lat, &us.Lng,
+			&startedAt, &endedAt, &us.DurationSeconds, &us.AlertID,
+		); e != nil {
+			continue
+		}
+		us.StartedAt = startedAt.Format(time.RFC3339)
+		if endedAt != nil {
+			s := endedAt.Format(time.RFC3339)
+			us.EndedAt = &s
 		}
+		stops = append(stops, us)
 	}
-	return
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"fleet_id":        fleetID,
+		"from":            from.Format(time.RFC3339),
+		"to":              to.Format(time.RFC3339),
+		"unplanned_stops": stops,
+		"pagination":      domain.NewPagination(page, limit, total),
+	})
 }
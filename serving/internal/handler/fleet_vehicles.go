@@ -0,0 +1,482 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+
+	"fleet-monitor/serving/internal/domain"
+)
+
+// FleetVehicleHandler serves fleet-wide vehicle views:
+//
+//	GET /api/v1/fleet/{fleet_id}/vehicles       — filtered, searchable, paginated listing
+//	GET /api/v1/fleet/{fleet_id}/vehicles/live  — every vehicle's live state in one call (ETag)
+type FleetVehicleHandler struct {
+	redis   *redis.Client
+	tsStore *pgxpool.Pool
+}
+
+func NewFleetVehicleHandler(redisClient *redis.Client, tsStore *pgxpool.Pool) *FleetVehicleHandler {
+	return &FleetVehicleHandler{
+		redis:   redisClient,
+		tsStore: tsStore,
+	}
+}
+
+// fleetLiveStates returns the state hash of every vehicle in the fleet that
+// is reporting right now, keyed by vehicle ID. Members of fleet:{id}:vehicles
+// whose state has expired, or that have moved to another fleet, are pruned.
+func fleetLiveStates(ctx context.Context, rc *redis.Client, fleetID string) (map[string]map[string]string, error) {
+	setKey := fmt.Sprintf("fleet:%s:vehicles", fleetID)
+	members, err := rc.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]map[string]string, len(members))
+	if len(members) == 0 {
+		return states, nil
+	}
+
+	pipe := rc.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(members))
+	for i, vehicleID := range members {
+		cmds[i] = pipe.HGetAll(ctx, fmt.Sprintf("vehicle:%s:state", vehicleID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var stale []interface{}
+	for i, vehicleID := range members {
+		st := cmds[i].Val()
+		if len(st) == 0 || st["fleet_id"] != fleetID {
+			stale = append(stale, vehicleID)
+			continue
+		}
+		states[vehicleID] = st
+	}
+	if len(stale) > 0 {
+		rc.SRem(ctx, setKey, stale...)
+	}
+	return states, nil
+}
+
+// ── Live snapshot ─────────────────────────────────────────────────────────────
+
+// LiveVehicle is one vehicle of the live map snapshot.
+type LiveVehicle struct {
+	VehicleID          string               `json:"vehicle_id"`
+	DisplayName        string               `json:"display_name"`
+	RegistrationNumber string               `json:"registration_number"`
+	VehicleType        string               `json:"vehicle_type"`
+	OnlineStatus       string               `json:"online_status"`
+	Activity           string               `json:"activity,omitempty"` // moving | idle | parked, when reporting
+	State              *domain.VehicleState `json:"live_state"`         // last report; null if none in the past month
+	Deviating          bool                 `json:"deviating"`
+	ActiveTrip         *LiveTrip            `json:"active_trip"`
+	OpenAlerts         int                  `json:"open_alerts"` // unacknowledged and unresolved
+}
+
+type LiveTrip struct {
+	TripID    string  `json:"trip_id"`
+	RouteName string  `json:"route_name"`
+	ETA       *string `json:"eta,omitempty"` // next stop
+}
+
+// fleetVehicleSelect reads registry rows with their active trip and open
+// alert count; callers append WHERE/ORDER/LIMIT. $1 is the fleet, $2 the
+// in-progress trip status.
+const fleetVehicleSelect = `
+	SELECT v.vehicle_id, v.display_name, v.registration_number, v.vehicle_type,
+	       t.trip_id, r.route_name,
+	       COALESCE(a.open_alerts, 0)
+	FROM vehicle_registry v
+	LEFT JOIN trip t
+	       ON t.vehicle_id = v.vehicle_id AND t.status = $2
+	LEFT JOIN route_registry r ON r.route_id = t.route_id
+	LEFT JOIN (
+		SELECT vehicle_id, COUNT(*) AS open_alerts
+		FROM vehicle_alerts
+		WHERE fleet_id = $1 AND acknowledged_at IS NULL AND resolved_at IS NULL
+		GROUP BY vehicle_id
+	) a ON a.vehicle_id = v.vehicle_id
+`
+
+func (h *FleetVehicleHandler) loadVehicles(ctx context.Context, query string, args ...interface{}) ([]LiveVehicle, error) {
+	rows, err := h.tsStore.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vehicles := []LiveVehicle{}
+	for rows.Next() {
+		var lv LiveVehicle
+		var tripID, routeName *string
+		if e := rows.Scan(
+			&lv.VehicleID, &lv.DisplayName, &lv.RegistrationNumber, &lv.VehicleType,
+			&tripID, &routeName, &lv.OpenAlerts,
+		); e != nil {
+			continue
+		}
+		if tripID != nil {
+			lv.ActiveTrip = &LiveTrip{TripID: *tripID}
+			if routeName != nil {
+				lv.ActiveTrip.RouteName = *routeName
+			}
+		}
+		vehicles = append(vehicles, lv)
+	}
+	return vehicles, rows.Err()
+}
+
+// attachLive fills in the Redis side of each vehicle in one round trip.
+// states comes from fleetLiveStates; when nil, each vehicle's state hash is
+// read in the same pipeline instead, which is cheaper for a single page.
+// Vehicles that aren't reporting get their vehicle:{id}:last_known hash, so
+// they still have a position and a received_at on the map.
+func (h *FleetVehicleHandler) attachLive(ctx context.Context, fleetID string, vehicles []LiveVehicle, states map[string]map[string]string) error {
+	if len(vehicles) == 0 {
+		return nil
+	}
+	pipe := h.redis.Pipeline()
+	stateCmds := make([]*redis.MapStringStringCmd, len(vehicles))
+	lastKnown := make([]*redis.MapStringStringCmd, len(vehicles))
+	online := make([]*redis.StringCmd, len(vehicles))
+	deviation := make([]*redis.StringCmd, len(vehicles))
+	etas := make([]*redis.StringCmd, len(vehicles))
+	for i, lv := range vehicles {
+		if states == nil {
+			stateCmds[i] = pipe.HGetAll(ctx, fmt.Sprintf("vehicle:%s:state", lv.VehicleID))
+		}
+		if _, live := states[lv.VehicleID]; !live {
+			lastKnown[i] = pipe.HGetAll(ctx, fmt.Sprintf("vehicle:%s:last_known", lv.VehicleID))
+		}
+		online[i] = pipe.Get(ctx, fmt.Sprintf("vehicle:%s:online_status", lv.VehicleID))
+		deviation[i] = pipe.Get(ctx, fmt.Sprintf("vehicle:%s:deviation", lv.VehicleID))
+		if lv.ActiveTrip != nil {
+			etas[i] = pipe.Get(ctx, fmt.Sprintf("trip:%s:eta", lv.ActiveTrip.TripID))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return err
+	}
+
+	for i := range vehicles {
+		lv := &vehicles[i]
+		st := states[lv.VehicleID]
+		if stateCmds[i] != nil {
+			st = stateCmds[i].Val()
+		}
+		reporting := len(st) > 0 && st["fleet_id"] == fleetID
+		if reporting {
+			s := stateFromMap(lv.VehicleID, st)
+			lv.State = &s
+			lv.Activity = liveStatus(st)
+		} else if lastKnown[i] != nil {
+			if lk := lastKnown[i].Val(); len(lk) > 0 && lk["fleet_id"] == fleetID {
+				s := stateFromMap(lv.VehicleID, lk)
+				lv.State = &s
+			}
+		}
+		// No status key means the heartbeat monitor has not judged the
+		// vehicle yet; go by whether it is reporting.
+		lv.OnlineStatus = online[i].Val()
+		if lv.OnlineStatus == "" {
+			lv.OnlineStatus = string(domain.StatusOffline)
+			if reporting {
+				lv.OnlineStatus = string(domain.StatusOnline)
+			}
+		}
+		lv.Deviating = deviation[i].Val() == "true"
+		if etas[i] != nil {
+			if eta := etas[i].Val(); eta != "" {
+				lv.ActiveTrip.ETA = &eta
+			}
+		}
+	}
+	return nil
+}
+
+// GET /api/v1/fleet/{fleet_id}/vehicles/live
+//
+// Every active vehicle in the registry, reporting or not, so a dashboard can
+// draw its whole map before the first vehicle.position event. The ETag
+// changes with any field; poll with If-None-Match to get 304 while nothing
+// has.
+func (h *FleetVehicleHandler) HandleLive(w http.ResponseWriter, r *http.Request) {
+	fleetID := r.PathValue("fleet_id")
+	ctx := r.Context()
+
+	vehicles, err := h.loadVehicles(ctx,
+		fleetVehicleSelect+`WHERE v.fleet_id = $1 AND v.active = true ORDER BY v.vehicle_id`,
+		fleetID, domain.TripInProgress)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load vehicles")
+		return
+	}
+
+	states, err := fleetLiveStates(ctx, h.redis, fleetID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read live vehicle states")
+		return
+	}
+	if err := h.attachLive(ctx, fleetID, vehicles, states); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read vehicle status")
+		return
+	}
+
+	writeJSONWithETag(w, r, map[string]interface{}{
+		"fleet_id": fleetID,
+		"vehicles": vehicles,
+	})
+}
+
+// ── Listing ───────────────────────────────────────────────────────────────────
+
+// vehicleSortSQL maps a ?sort= key to its ORDER BY expression for keys the
+// registry query can sort on. Only keys in this map ever reach the query.
+var vehicleSortSQL = map[string]string{
+	"display_name":        "v.display_name",
+	"registration_number": "v.registration_number",
+	"vehicle_type":        "v.vehicle_type",
+	"open_alerts":         "COALESCE(a.open_alerts, 0)",
+}
+
+// vehicleSortLive holds the keys that need live state; sorting on them loads
+// the whole fleet. last_seen ranks offline vehicles by their last known
+// report too. Speed and fuel rank reporting vehicles only, since an offline
+// vehicle's last reading says nothing about now; the rest sort last either
+// way, as do vehicles with no report at all.
+var vehicleSortLive = map[string]struct {
+	key       func(s *domain.VehicleState) float64
+	reporting bool // only vehicles reporting right now are ranked
+}{
+	"last_seen": {key: func(s *domain.VehicleState) float64 { return float64(s.Timestamp) }},
+	"speed_kmh": {key: func(s *domain.VehicleState) float64 { return s.SpeedKmh }, reporting: true},
+	"fuel_pct":  {key: func(s *domain.VehicleState) float64 { return s.FuelPct }, reporting: true},
+}
+
+// parseBoolParam reads an optional true/false query param.
+func parseBoolParam(r *http.Request, name string) (val *bool, msg string) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, ""
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, name + " must be true or false"
+	}
+	return &b, ""
+}
+
+// GET /api/v1/fleet/{fleet_id}/vehicles
+//
+// Query params:
+//
+//	q                 substring of display_name or registration_number
+//	vehicle_type      exact match
+//	status            online | offline
+//	activity          moving | idle | parked (reporting vehicles only)
+//	has_alerts        true | false — open (unacknowledged, unresolved) alerts
+//	on_trip           true | false — a trip is IN_PROGRESS
+//	deviating         true | false
+//	include_inactive  true to list deactivated vehicles too
+//	sort              display_name (default), registration_number, vehicle_type,
+//	                  open_alerts, last_seen, speed_kmh, fuel_pct; prefix with
+//	                  - for descending
+//	page, limit
+//
+// Registry-side filters and sorts run in SQL and only the page is looked up
+// in Redis. status, activity, deviating and the live sorts need every
+// candidate's live state, read in two round trips via fleet:{id}:vehicles.
+func (h *FleetVehicleHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	fleetID := r.PathValue("fleet_id")
+	q := r.URL.Query()
+	ctx := r.Context()
+
+	status := q.Get("status")
+	if status != "" && status != string(domain.StatusOnline) && status != string(domain.StatusOffline) {
+		writeError(w, http.StatusBadRequest, "status must be online or offline")
+		return
+	}
+	activity := q.Get("activity")
+	if activity != "" && activity != "moving" && activity != "idle" && activity != "parked" {
+		writeError(w, http.StatusBadRequest, "activity must be moving, idle or parked")
+		return
+	}
+	var flags [4]*bool
+	for i, name := range []string{"has_alerts", "on_trip", "deviating", "include_inactive"} {
+		var msg string
+		if flags[i], msg = parseBoolParam(r, name); msg != "" {
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+	}
+	hasAlerts, onTrip, deviating, includeInactive := flags[0], flags[1], flags[2], flags[3]
+
+	sortKey, desc := q.Get("sort"), false
+	if strings.HasPrefix(sortKey, "-") {
+		sortKey, desc = sortKey[1:], true
+	}
+	if sortKey == "" {
+		sortKey = "display_name"
+	}
+	sortExpr, sqlSort := vehicleSortSQL[sortKey]
+	live, liveSort := vehicleSortLive[sortKey]
+	if !sqlSort && !liveSort {
+		writeError(w, http.StatusBadRequest, "unsupported sort: "+sortKey)
+		return
+	}
+
+	page, limit := parsePagination(q.Get("page"), q.Get("limit"))
+	offset := (page - 1) * limit
+
+	args := []interface{}{fleetID, domain.TripInProgress}
+	where := "WHERE v.fleet_id = $1"
+	if includeInactive == nil || !*includeInactive {
+		where += " AND v.active = true"
+	}
+	if v := q.Get("vehicle_type"); v != "" {
+		args = append(args, v)
+		where += fmt.Sprintf(" AND v.vehicle_type = $%d", len(args))
+	}
+	if v := strings.TrimSpace(q.Get("q")); v != "" {
+		// Escape LIKE metacharacters so the search is a plain substring match.
+		v = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(v)
+		args = append(args, "%"+v+"%")
+		where += fmt.Sprintf(" AND (v.display_name ILIKE $%d OR v.registration_number ILIKE $%d)", len(args), len(args))
+	}
+	if hasAlerts != nil {
+		if *hasAlerts {
+			where += " AND a.open_alerts > 0"
+		} else {
+			where += " AND a.open_alerts IS NULL"
+		}
+	}
+	if onTrip != nil {
+		if *onTrip {
+			where += " AND t.trip_id IS NOT NULL"
+		} else {
+			where += " AND t.trip_id IS NULL"
+		}
+	}
+
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	orderBy := " ORDER BY v.vehicle_id"
+	if sqlSort {
+		orderBy = fmt.Sprintf(" ORDER BY %s %s, v.vehicle_id", sortExpr, direction)
+	}
+
+	var vehicles []LiveVehicle
+	var total int
+	var err error
+
+	if status == "" && activity == "" && deviating == nil && sqlSort {
+		// Everything is decided by the registry: page in SQL.
+		err = h.tsStore.QueryRow(ctx, `
+			SELECT COUNT(*)
+			FROM vehicle_registry v
+			LEFT JOIN trip t
+			       ON t.vehicle_id = v.vehicle_id AND t.status = $2
+			LEFT JOIN (
+				SELECT vehicle_id, COUNT(*) AS open_alerts
+				FROM vehicle_alerts
+				WHERE fleet_id = $1 AND acknowledged_at IS NULL AND resolved_at IS NULL
+				GROUP BY vehicle_id
+			) a ON a.vehicle_id = v.vehicle_id
+			`+where, args...).Scan(&total)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to count vehicles")
+			return
+		}
+		args = append(args, limit, offset)
+		vehicles, err = h.loadVehicles(ctx,
+			fleetVehicleSelect+where+orderBy+fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args)),
+			args...)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load vehicles")
+			return
+		}
+		if err := h.attachLive(ctx, fleetID, vehicles, nil); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to read vehicle status")
+			return
+		}
+	} else {
+		candidates, err := h.loadVehicles(ctx, fleetVehicleSelect+where+orderBy, args...)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load vehicles")
+			return
+		}
+		states, err := fleetLiveStates(ctx, h.redis, fleetID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to read live vehicle states")
+			return
+		}
+		if err := h.attachLive(ctx, fleetID, candidates, states); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to read vehicle status")
+			return
+		}
+
+		matched := candidates[:0]
+		for _, lv := range candidates {
+			if (status != "" && lv.OnlineStatus != status) ||
+				(activity != "" && lv.Activity != activity) ||
+				(deviating != nil && lv.Deviating != *deviating) {
+				continue
+			}
+			matched = append(matched, lv)
+		}
+		if liveSort {
+			// Activity is only set from a live state hash, so it tells a
+			// reporting vehicle from one showing its last-known state.
+			ranked := func(lv LiveVehicle) bool {
+				return lv.State != nil && (!live.reporting || lv.Activity != "")
+			}
+			sort.SliceStable(matched, func(i, j int) bool {
+				a, b := matched[i], matched[j]
+				if !ranked(a) || !ranked(b) {
+					return ranked(a)
+				}
+				if desc {
+					return live.key(a.State) > live.key(b.State)
+				}
+				return live.key(a.State) < live.key(b.State)
+			})
+		}
+
+		total = len(matched)
+		vehicles = []LiveVehicle{}
+		if offset < total {
+			vehicles = matched[offset:min(offset+limit, total)]
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"fleet_id":   fleetID,
+		"vehicles":   vehicles,
+		"pagination": domain.NewPagination(page, limit, total),
+	})
+}
+
+// liveStatus classifies a reporting vehicle the way the summary counts it.
+func liveStatus(st map[string]string) string {
+	switch {
+	case parseMapBool(st, "is_moving"):
+		return "moving"
+	case parseMapBool(st, "engine_on"):
+		return "idle"
+	default:
+		return "parked"
+	}
+}
@@ -5,26 +5,38 @@ import (
 	"net/http"
 	"time"
 
+	"fleet-monitor/serving/internal/leader"
 	"fleet-monitor/serving/internal/store"
 )
 
+// drainState is satisfied by the WebSocket hub.
+type drainState interface {
+	Draining() bool
+}
+
 type HealthHandler struct {
 	tsStore *store.TimescaleStore
 	rdStore *store.RedisStore
+	drain   drainState
+	elector *leader.Elector
 }
 
-func NewHealthHandler(ts *store.TimescaleStore, rd *store.RedisStore) *HealthHandler {
+func NewHealthHandler(ts *store.TimescaleStore, rd *store.RedisStore, drain drainState, elector *leader.Elector) *HealthHandler {
 	return &HealthHandler{
 		tsStore: ts,
 		rdStore: rd,
+		drain:   drain,
+		elector: elector,
 	}
 }
 
 type healthResponse struct {
 	Status       string            `json:"status"`
 	Service      string            `json:"service"`
+	Instance     string            `json:"instance"`
 	Timestamp    time.Time         `json:"timestamp"`
 	Dependencies map[string]string `json:"dependencies"`
+	Jobs         []leader.Status   `json:"jobs"`
 }
 
 func (h *HealthHandler) Handle(w http.ResponseWriter, r *http.Request) {
@@ -51,12 +63,22 @@ func (h *HealthHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		status = "degraded"
 		httpStatus = http.StatusServiceUnavailable
 	}
+	// A draining instance is healthy but must stop receiving new sessions.
+	if h.drain.Draining() {
+		status = "draining"
+		httpStatus = http.StatusServiceUnavailable
+	}
+
+	// Leadership is informational: a replica leading nothing is still healthy.
+	jobs, _ := h.elector.Leadership(r.Context())
 
 	resp := healthResponse{
 		Status:       status,
 		Service:      "serving",
+		Instance:     h.elector.InstanceID(),
 		Timestamp:    time.Now().UTC(),
 		Dependencies: deps,
+		Jobs:         jobs,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(httpStatus)
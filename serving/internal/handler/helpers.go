@@ -1,10 +1,15 @@
 package handler
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+
+	"fleet-monitor/serving/internal/domain"
 )
 
 // ── JSON response writers ─────────────────────────────────────────────────────
@@ -24,6 +29,32 @@ func writeError(w http.ResponseWriter, status int, msg string) {
 	json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
+// writeJSONWithETag writes v with a strong ETag over its encoding, or a bare
+// 304 when the request's If-None-Match already names it. Lets dashboards
+// poll a large payload and only download it when something changed.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode response")
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache") // always revalidate
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+}
+
 // ── Pagination ────────────────────────────────────────────────────────────────
 
 // parsePagination reads ?page= and ?limit= from the request query string.
@@ -77,3 +108,23 @@ func parseMapBool(m map[string]string, key string) bool {
 	v := m[key]
 	return v == "1" || v == "true"
 }
+
+// stateFromMap converts a vehicle:{id}:state hash.
+func stateFromMap(vehicleID string, m map[string]string) domain.VehicleState {
+	ts, _ := strconv.ParseInt(m["timestamp"], 10, 64)
+	recv, _ := strconv.ParseInt(m["received_at"], 10, 64)
+	return domain.VehicleState{
+		VehicleID:   vehicleID,
+		FleetID:     m["fleet_id"],
+		Lat:         parseMapFloat(m, "lat"),
+		Lng:         parseMapFloat(m, "lng"),
+		SpeedKmh:    parseMapFloat(m, "speed_kmh"),
+		FuelPct:     parseMapFloat(m, "fuel_pct"),
+		EngineTempC: parseMapFloat(m, "engine_temp"),
+		Battery:     parseMapFloat(m, "battery"),
+		IsMoving:    parseMapBool(m, "is_moving"),
+		EngineOn:    parseMapBool(m, "engine_on"),
+		Timestamp:   ts,
+		ReceivedAt:  recv,
+	}
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"fleet-monitor/serving/internal/scheduler"
+)
+
+// JobHandler exposes the background job scheduler to platform operators.
+// Actions reach whichever replica leads the job.
+//
+//	GET  /api/v1/admin/jobs                — every job with its leader and last run
+//	GET  /api/v1/admin/jobs/{job}/runs     — run history, newest first
+//	POST /api/v1/admin/jobs/{job}/pause    — stop scheduled runs until resumed
+//	POST /api/v1/admin/jobs/{job}/resume
+//	POST /api/v1/admin/jobs/{job}/trigger  — run once now
+type JobHandler struct {
+	sched *scheduler.Scheduler
+}
+
+func NewJobHandler(sched *scheduler.Scheduler) *JobHandler {
+	return &JobHandler{sched: sched}
+}
+
+// GET /api/v1/admin/jobs
+func (h *JobHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	jobs, err := h.sched.Jobs(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load jobs")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": jobs})
+}
+
+// GET /api/v1/admin/jobs/{job}/runs?limit=
+func (h *JobHandler) HandleRuns(w http.ResponseWriter, r *http.Request) {
+	_, limit := parsePagination("", r.URL.Query().Get("limit"))
+	runs, err := h.sched.Runs(r.Context(), r.PathValue("job"), limit)
+	if errors.Is(err, scheduler.ErrUnknownJob) {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load job runs")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job":  r.PathValue("job"),
+		"runs": runs,
+	})
+}
+
+// POST /api/v1/admin/jobs/{job}/pause
+//
+// Pausing also stops a job's live-event processing. It persists across
+// restarts and failover until resumed.
+func (h *JobHandler) HandlePause(w http.ResponseWriter, r *http.Request) {
+	h.setPaused(w, r, true)
+}
+
+// POST /api/v1/admin/jobs/{job}/resume
+func (h *JobHandler) HandleResume(w http.ResponseWriter, r *http.Request) {
+	h.setPaused(w, r, false)
+}
+
+func (h *JobHandler) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	name := r.PathValue("job")
+	var err error
+	if paused {
+		err = h.sched.Pause(r.Context(), name)
+	} else {
+		err = h.sched.Resume(r.Context(), name)
+	}
+	if errors.Is(err, scheduler.ErrUnknownJob) {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update job")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job":    name,
+		"paused": paused,
+	})
+}
+
+// POST /api/v1/admin/jobs/{job}/trigger
+//
+// Runs even a paused job. Returns 202 once the job's leader has been asked;
+// the run itself shows up in /runs with trigger "manual". 409 when no
+// replica currently leads the job.
+func (h *JobHandler) HandleTrigger(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("job")
+	holder, err := h.sched.Trigger(r.Context(), name)
+	if errors.Is(err, scheduler.ErrUnknownJob) {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to trigger job")
+		return
+	}
+	if holder == "" {
+		writeError(w, http.StatusConflict, "job has no leader right now, retry shortly")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"job":    name,
+		"leader": holder,
+	})
+}
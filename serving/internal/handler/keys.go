@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"fleet-monitor/serving/internal/auth"
+	"fleet-monitor/serving/internal/middleware"
+)
+
+const (
+	defaultRotationOverlap = 24 * time.Hour
+	maxRotationOverlap     = 30 * 24 * time.Hour
+)
+
+// KeyHandler manages device API keys used by ingestion. fleet_admin operators
+// see and manage only their own fleet's keys; super_admin manages all of them.
+//
+//	POST   /api/v1/admin/keys                  — issue; the key is only ever returned here
+//	GET    /api/v1/admin/keys                  — list metadata (?fleet_id= to filter)
+//	POST   /api/v1/admin/keys/{key_id}/rotate  — issue a replacement, old key expires after overlap
+//	DELETE /api/v1/admin/keys/{key_id}         — revoke immediately on every instance
+type KeyHandler struct {
+	keys    *auth.KeyStore
+	tsStore *pgxpool.Pool
+}
+
+func NewKeyHandler(keys *auth.KeyStore, tsStore *pgxpool.Pool) *KeyHandler {
+	return &KeyHandler{keys: keys, tsStore: tsStore}
+}
+
+type issueKeyBody struct {
+	FleetID   string `json:"fleet_id"`
+	VehicleID string `json:"vehicle_id"` // empty issues a fleet gateway key
+	Label     string `json:"label"`
+	Signing   bool   `json:"signing"`
+}
+
+type rotateKeyBody struct {
+	OverlapSeconds *int `json:"overlap_seconds"`
+}
+
+// POST /api/v1/admin/keys
+func (h *KeyHandler) HandleIssue(w http.ResponseWriter, r *http.Request) {
+	var body issueKeyBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.FleetID == "" {
+		writeError(w, http.StatusBadRequest, `request body must contain "fleet_id"`)
+		return
+	}
+	if scope := middleware.FleetIDFromContext(r.Context()); scope != "" && scope != body.FleetID {
+		writeError(w, http.StatusNotFound, "fleet not found")
+		return
+	}
+
+	// Vehicle keys must match the registry, or ingestion would reject every
+	// payload the device sends.
+	if body.VehicleID != "" {
+		var fleetID string
+		err := h.tsStore.QueryRow(r.Context(), `
+			SELECT fleet_id FROM vehicle_registry WHERE vehicle_id = $1 AND active
+		`, body.VehicleID).Scan(&fleetID)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && fleetID != body.FleetID) {
+			writeError(w, http.StatusUnprocessableEntity, "vehicle is not registered to fleet "+body.FleetID)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to look up vehicle")
+			return
+		}
+	}
+
+	issued, err := h.keys.Issue(r.Context(), auth.IssueRequest{
+		FleetID:   body.FleetID,
+		VehicleID: body.VehicleID,
+		Label:     body.Label,
+		Signing:   body.Signing,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to issue key")
+		return
+	}
+	writeJSON(w, http.StatusCreated, issued)
+}
+
+// GET /api/v1/admin/keys
+func (h *KeyHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.List(r.Context(), r.URL.Query().Get("fleet_id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list keys")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// POST /api/v1/admin/keys/{key_id}/rotate
+func (h *KeyHandler) HandleRotate(w http.ResponseWriter, r *http.Request) {
+	overlap := defaultRotationOverlap
+	var body rotateKeyBody
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	if body.OverlapSeconds != nil {
+		overlap = time.Duration(*body.OverlapSeconds) * time.Second
+		if overlap < 0 || overlap > maxRotationOverlap {
+			writeError(w, http.StatusBadRequest, "overlap_seconds must be between 0 and 2592000")
+			return
+		}
+	}
+
+	issued, err := h.keys.Rotate(r.Context(), r.PathValue("key_id"), overlap)
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		writeError(w, http.StatusNotFound, "key not found or already revoked")
+		return
+	}
+	if errors.Is(err, auth.ErrKeyRotated) {
+		writeError(w, http.StatusConflict, "key has already been rotated; rotate its replacement instead")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to rotate key")
+		return
+	}
+	writeJSON(w, http.StatusCreated, issued)
+}
+
+// DELETE /api/v1/admin/keys/{key_id}
+func (h *KeyHandler) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	err := h.keys.Revoke(r.Context(), r.PathValue("key_id"))
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to revoke key")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
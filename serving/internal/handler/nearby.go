@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+
+	"fleet-monitor/serving/internal/domain"
+)
+
+// NearbyHandler answers "which vehicles are near this point":
+//
+//	GET /api/v1/fleet/{fleet_id}/vehicles/nearby          — latest positions (Redis GEO)
+//	GET /api/v1/fleet/{fleet_id}/vehicles/nearby/history  — who was near between two times (PostGIS)
+type NearbyHandler struct {
+	redis   *redis.Client
+	tsStore *pgxpool.Pool
+}
+
+func NewNearbyHandler(redisClient *redis.Client, tsStore *pgxpool.Pool) *NearbyHandler {
+	return &NearbyHandler{
+		redis:   redisClient,
+		tsStore: tsStore,
+	}
+}
+
+const (
+	nearbyDefaultLimit = 50
+	nearbyMaxLimit     = 500
+	nearbyMaxRadiusKm  = 200.0
+	// nearbyMaxHistory bounds the history search; the GIST index narrows by
+	// place, the time range is what keeps the chunk scan affordable.
+	nearbyMaxHistory = 7 * 24 * time.Hour
+	// nearbySearchRounds bounds the re-queries when stale GEO members fill
+	// part of a COUNT; each round prunes the ones it found.
+	nearbySearchRounds = 3
+)
+
+// NearbyVehicle is one live search result.
+type NearbyVehicle struct {
+	VehicleID   string              `json:"vehicle_id"`
+	DisplayName string              `json:"display_name"`
+	DistanceKm  float64             `json:"distance_km"`
+	State       domain.VehicleState `json:"live_state"`
+}
+
+// NearbyVisit is one vehicle's time within range in the history search.
+type NearbyVisit struct {
+	VehicleID        string  `json:"vehicle_id"`
+	DisplayName      string  `json:"display_name"`
+	ClosestKm        float64 `json:"closest_km"`
+	ClosestAt        string  `json:"closest_at"`
+	FirstSeenInRange string  `json:"first_seen_in_range"`
+	LastSeenInRange  string  `json:"last_seen_in_range"`
+	SamplesInRange   int     `json:"samples_in_range"`
+}
+
+// parseNearby reads lat, lng and radius_km. A non-empty msg describes the
+// first bad param.
+func parseNearby(r *http.Request) (lat, lng, radiusKm float64, msg string) {
+	q := r.URL.Query()
+	var err error
+	if lat, err = strconv.ParseFloat(q.Get("lat"), 64); err != nil || lat < -90 || lat > 90 {
+		return 0, 0, 0, "lat must be a number between -90 and 90"
+	}
+	if lng, err = strconv.ParseFloat(q.Get("lng"), 64); err != nil || lng < -180 || lng > 180 {
+		return 0, 0, 0, "lng must be a number between -180 and 180"
+	}
+	radiusKm, err = strconv.ParseFloat(q.Get("radius_km"), 64)
+	if err != nil || radiusKm <= 0 || radiusKm > nearbyMaxRadiusKm {
+		return 0, 0, 0, fmt.Sprintf("radius_km must be a number in (0, %g]", nearbyMaxRadiusKm)
+	}
+	return lat, lng, radiusKm, ""
+}
+
+// GET /api/v1/fleet/{fleet_id}/vehicles/nearby
+//
+// Query params: lat, lng, radius_km (all required), limit (default 50, max
+// 500). Nearest first. Only vehicles reporting right now are returned.
+func (h *NearbyHandler) HandleNearby(w http.ResponseWriter, r *http.Request) {
+	fleetID := r.PathValue("fleet_id")
+	lat, lng, radiusKm, msg := parseNearby(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	limit := nearbyDefaultLimit
+	if n, e := strconv.Atoi(r.URL.Query().Get("limit")); e == nil && n > 0 && n <= nearbyMaxLimit {
+		limit = n
+	}
+
+	ctx := r.Context()
+	var vehicles []NearbyVehicle
+	for round := 1; ; round++ {
+		var full bool
+		var err error
+		vehicles, full, err = h.searchLive(ctx, fleetID, lat, lng, radiusKm, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to search vehicle positions")
+			return
+		}
+		// A short page with a full COUNT means stale members took places
+		// that reporting vehicles further out should have; they are pruned
+		// now, so search again.
+		if !full || len(vehicles) == limit || round == nearbySearchRounds {
+			break
+		}
+	}
+
+	ids := make([]string, len(vehicles))
+	for i, v := range vehicles {
+		ids[i] = v.VehicleID
+	}
+	names := h.displayNames(ctx, ids)
+	for i := range vehicles {
+		vehicles[i].DisplayName = names[vehicles[i].VehicleID]
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"fleet_id":  fleetID,
+		"lat":       lat,
+		"lng":       lng,
+		"radius_km": radiusKm,
+		"vehicles":  vehicles,
+	})
+}
+
+// GET /api/v1/fleet/{fleet_id}/vehicles/nearby/history
+//
+// Query params: lat, lng, radius_km (all required), from, to (RFC3339,
+// default the last hour, at most 7 days apart). One row per vehicle that
+// reported from within range, closest approach first.
+func (h *NearbyHandler) HandleNearbyHistory(w http.ResponseWriter, r *http.Request) {
+	fleetID := r.PathValue("fleet_id")
+	lat, lng, radiusKm, msg := parseNearby(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	from, to, msg := parseRange(r, time.Hour)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if to.Sub(from) > nearbyMaxHistory {
+		writeError(w, http.StatusBadRequest, "from and to must be at most 7 days apart")
+		return
+	}
+
+	rows, err := h.tsStore.Query(r.Context(), `
+		WITH here AS (
+			SELECT ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography AS point
+		),
+		hits AS (
+			SELECT t.vehicle_id, t.timestamp,
+			       ST_Distance(t.location, here.point) AS meters
+			FROM vehicle_telemetry t, here
+			WHERE t.fleet_id = $1
+			  AND t.timestamp >= $5 AND t.timestamp < $6
+			  AND ST_DWithin(t.location, here.point, $4)
+		)
+		SELECT h.vehicle_id,
+		       COALESCE(v.display_name, h.vehicle_id),
+		       MIN(h.meters),
+		       (ARRAY_AGG(h.timestamp ORDER BY h.meters))[1],
+		       MIN(h.timestamp), MAX(h.timestamp), COUNT(*)
+		FROM hits h
+		LEFT JOIN vehicle_registry v ON v.vehicle_id = h.vehicle_id
+		GROUP BY h.vehicle_id, v.display_name
+		ORDER BY MIN(h.meters)
+	`, fleetID, lng, lat, radiusKm*1000, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to search telemetry history")
+		return
+	}
+	defer rows.Close()
+
+	visits := []NearbyVisit{}
+	for rows.Next() {
+		var v NearbyVisit
+		var meters float64
+		var closestAt, first, last time.Time
+		if e := rows.Scan(
+			&v.VehicleID, &v.DisplayName, &meters,
+			&closestAt, &first, &last, &v.SamplesInRange,
+		); e != nil {
+			continue
+		}
+		v.ClosestKm = math.Round(meters) / 1000
+		v.ClosestAt = closestAt.UTC().Format(time.RFC3339)
+		v.FirstSeenInRange = first.UTC().Format(time.RFC3339)
+		v.LastSeenInRange = last.UTC().Format(time.RFC3339)
+		visits = append(visits, v)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"fleet_id":  fleetID,
+		"lat":       lat,
+		"lng":       lng,
+		"radius_km": radiusKm,
+		"from":      from.Format(time.RFC3339),
+		"to":        to.Format(time.RFC3339),
+		"vehicles":  visits,
+	})
+}
+
+// searchLive returns the reporting vehicles among the limit nearest GEO
+// members, pruning members whose state has expired. full reports whether
+// the search hit the limit, i.e. there may be more members in range.
+func (h *NearbyHandler) searchLive(ctx context.Context, fleetID string, lat, lng, radiusKm float64, limit int) ([]NearbyVehicle, bool, error) {
+	geoKey := fmt.Sprintf("fleet:%s:geo", fleetID)
+	hits, err := h.redis.GeoSearchLocation(ctx, geoKey, &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{
+			Longitude:  lng,
+			Latitude:   lat,
+			Radius:     radiusKm,
+			RadiusUnit: "km",
+			Sort:       "ASC",
+			Count:      limit,
+		},
+		WithDist: true,
+	}).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	states, err := h.liveStates(ctx, hits)
+	if err != nil {
+		return nil, false, err
+	}
+
+	vehicles := []NearbyVehicle{}
+	var stale []interface{}
+	for i, hit := range hits {
+		st := states[i]
+		// The GEO member outlives the state hash; gone state means the
+		// vehicle stopped reporting, another fleet means it was moved.
+		if len(st) == 0 || st["fleet_id"] != fleetID {
+			stale = append(stale, hit.Name)
+			continue
+		}
+		vehicles = append(vehicles, NearbyVehicle{
+			VehicleID:  hit.Name,
+			DistanceKm: math.Round(hit.Dist*1000) / 1000,
+			State:      stateFromMap(hit.Name, st),
+		})
+	}
+	if len(stale) > 0 {
+		if err := h.redis.ZRem(ctx, geoKey, stale...).Err(); err != nil {
+			return nil, false, err
+		}
+	}
+	return vehicles, len(hits) == limit, nil
+}
+
+// liveStates reads the state hash of every hit in one round trip, in order.
+func (h *NearbyHandler) liveStates(ctx context.Context, hits []redis.GeoLocation) ([]map[string]string, error) {
+	pipe := h.redis.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(hits))
+	for i, hit := range hits {
+		cmds[i] = pipe.HGetAll(ctx, fmt.Sprintf("vehicle:%s:state", hit.Name))
+	}
+	if len(hits) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, err
+		}
+	}
+	out := make([]map[string]string, len(cmds))
+	for i, cmd := range cmds {
+		out[i] = cmd.Val()
+	}
+	return out, nil
+}
+
+func (h *NearbyHandler) displayNames(ctx context.Context, vehicleIDs []string) map[string]string {
+	names := make(map[string]string, len(vehicleIDs))
+	if len(vehicleIDs) == 0 {
+		return names
+	}
+	rows, err := h.tsStore.Query(ctx, `
+		SELECT vehicle_id, display_name FROM vehicle_registry WHERE vehicle_id = ANY($1)
+	`, vehicleIDs)
+	if err != nil {
+		return names
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, name string
+		if rows.Scan(&id, &name) == nil {
+			names[id] = name
+		}
+	}
+	return names
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"fleet-monitor/serving/internal/middleware"
+	"fleet-monitor/serving/internal/ws"
+)
+
+const maxOperatorMessageLen = 2000
+
+// SessionHandler reports live streaming sessions (WebSocket and SSE) across
+// every serving instance, and pushes messages to an operator's sessions.
+//
+//	GET  /api/v1/fleet/{fleet_id}/watchers  — who is watching the fleet right now
+//	POST /api/v1/fleet/{fleet_id}/messages  — message one operator's sessions
+type SessionHandler struct {
+	hub *ws.Hub
+}
+
+func NewSessionHandler(hub *ws.Hub) *SessionHandler {
+	return &SessionHandler{hub: hub}
+}
+
+type operatorMessageBody struct {
+	To   string `json:"to"` // operator subject
+	Text string `json:"text"`
+}
+
+// GET /api/v1/fleet/{fleet_id}/watchers
+//
+// Counts come from the instance registry and lag by up to one heartbeat.
+func (h *SessionHandler) HandleWatchers(w http.ResponseWriter, r *http.Request) {
+	watchers, err := h.hub.Watchers(r.Context(), r.PathValue("fleet_id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load session registry")
+		return
+	}
+	writeJSON(w, http.StatusOK, watchers)
+}
+
+// POST /api/v1/fleet/{fleet_id}/messages
+//
+// Delivered only to the operator's sessions on this fleet, so a message can
+// never leave the caller's fleet. It is always published: every instance
+// delivers to whichever of the operator's sessions it holds at that moment.
+// sessions is the registry's count, which lags by up to one heartbeat — a
+// hint for the sender, not a delivery receipt (null if the registry could
+// not be read). Nothing is queued for operators who are not connected.
+func (h *SessionHandler) HandleMessage(w http.ResponseWriter, r *http.Request) {
+	var body operatorMessageBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	body.Text = strings.TrimSpace(body.Text)
+	if body.To == "" || body.Text == "" {
+		writeError(w, http.StatusBadRequest, `request body must contain "to" and "text"`)
+		return
+	}
+	if len(body.Text) > maxOperatorMessageLen {
+		writeError(w, http.StatusBadRequest, "text must be at most 2000 bytes")
+		return
+	}
+
+	ctx := r.Context()
+	fleetID := r.PathValue("fleet_id")
+	from := middleware.PrincipalFromContext(ctx)
+	err := h.hub.SendToOperator(ctx, fleetID, body.To, ws.OperatorMessagePayload{
+		From:     from.Subject,
+		FromName: from.Name,
+		Text:     body.Text,
+		SentAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to send message")
+		return
+	}
+
+	var sessions *int
+	if n, err := h.hub.UserSessions(ctx, fleetID, body.To); err == nil {
+		sessions = &n
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"to":       body.To,
+		"sessions": sessions,
+	})
+}
@@ -1,20 +1,25 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 
 	"fleet-monitor/serving/internal/domain"
 )
 
-// TripHandler serves trip read endpoints:
+// TripHandler serves trip endpoints:
 //
-//	GET /api/v1/trips            — list trips (filter by fleet, status, date)
-//	GET /api/v1/trips/{trip_id}  — full trip detail with stops and ETA
+//	GET /api/v1/trips                    — list trips (filter by fleet, status, date)
+//	GET /api/v1/trips/{trip_id}          — full trip detail with stops and per-stop ETAs
+//	PUT /api/v1/trips/{trip_id}/windows  — set planned arrival windows per stop
 type TripHandler struct {
 	redis   *redis.Client
 	tsStore *pgxpool.Pool
@@ -243,7 +248,9 @@ func (h *TripHandler) HandleDetail(w http.ResponseWriter, r *http.Request) {
 	stopRows, err := h.tsStore.Query(ctx, `
 		SELECT rs.stop_id, rs.stop_name, rs.stop_sequence,
 		       COALESCE(tsp.status, $1),
-		       tsp.arrived_at, tsp.departed_at
+		       tsp.arrived_at, tsp.departed_at,
+		       tsp.window_start, tsp.window_end,
+		       tsp.punctuality, tsp.arrival_delta_seconds
 		FROM route_stops rs
 		LEFT JOIN trip_stop_progress tsp
 		       ON tsp.stop_id = rs.stop_id AND tsp.trip_id = $2
@@ -254,11 +261,13 @@ func (h *TripHandler) HandleDetail(w http.ResponseWriter, r *http.Request) {
 		defer stopRows.Close()
 		for stopRows.Next() {
 			var sp domain.StopProgress
-			var arrivedAt, departedAt *time.Time
+			var arrivedAt, departedAt, windowStart, windowEnd *time.Time
+			var punctuality *string
 			var statusStr string
 			if e := stopRows.Scan(
 				&sp.StopID, &sp.StopName, &sp.Sequence,
 				&statusStr, &arrivedAt, &departedAt,
+				&windowStart, &windowEnd, &punctuality, &sp.ArrivalDeltaSeconds,
 			); e == nil {
 				sp.Status = domain.StopStatus(statusStr)
 				if arrivedAt != nil {
@@ -269,6 +278,7 @@ func (h *TripHandler) HandleDetail(w http.ResponseWriter, r *http.Request) {
 					s := departedAt.Format(time.RFC3339)
 					sp.DepartedAt = &s
 				}
+				setStopWindow(&sp, windowStart, windowEnd, punctuality)
 				td.Stops = append(td.Stops, sp)
 				td.StopsTotal++
 				if sp.Status == domain.StopArrived || sp.Status == domain.StopDeparted {
@@ -285,6 +295,7 @@ func (h *TripHandler) HandleDetail(w http.ResponseWriter, r *http.Request) {
 	if etaVal, e := h.redis.Get(ctx, fmt.Sprintf("trip:%s:eta", tripID)).Result(); e == nil {
 		td.ETA = &etaVal
 	}
+	fillStopETAs(ctx, h.redis, tripID, td.Stops)
 
 	if devVal, _ := h.redis.Get(ctx, fmt.Sprintf("vehicle:%s:deviation", td.Vehicle.VehicleID)).Result(); devVal == "true" {
 		td.DeviationStatus = "deviated"
@@ -294,3 +305,131 @@ func (h *TripHandler) HandleDetail(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, td)
 }
+
+// ── Arrival windows ───────────────────────────────────────────────────────────
+
+type stopWindow struct {
+	StopID string     `json:"stop_id"`
+	Start  *time.Time `json:"start"` // both null clears the window
+	End    *time.Time `json:"end"`
+}
+
+type setWindowsBody struct {
+	Windows []stopWindow `json:"windows"`
+}
+
+// PUT /api/v1/trips/{trip_id}/windows
+//
+// Body: {"windows": [{"stop_id": "...", "start": RFC3339, "end": RFC3339}]}.
+// Stops not listed keep their window. Only trips that have not finished can
+// be changed; a stop already resolved keeps the punctuality it was given.
+func (h *TripHandler) HandleSetWindows(w http.ResponseWriter, r *http.Request) {
+	tripID := r.PathValue("trip_id")
+	ctx := r.Context()
+
+	var body setWindowsBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Windows) == 0 {
+		writeError(w, http.StatusBadRequest, `request body must contain a non-empty "windows" array`)
+		return
+	}
+
+	var routeID, status string
+	err := h.tsStore.QueryRow(ctx, `
+		SELECT route_id, status FROM trip WHERE trip_id = $1
+	`, tripID).Scan(&routeID, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "trip not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load trip")
+		return
+	}
+	if s := domain.TripStatus(status); s == domain.TripCompleted || s == domain.TripCancelled {
+		writeError(w, http.StatusConflict, "trip is "+status+", windows can no longer change")
+		return
+	}
+
+	routeStops := make(map[string]bool)
+	rows, err := h.tsStore.Query(ctx, `SELECT stop_id FROM route_stops WHERE route_id = $1`, routeID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load route stops")
+		return
+	}
+	for rows.Next() {
+		var stopID string
+		if rows.Scan(&stopID) == nil {
+			routeStops[stopID] = true
+		}
+	}
+	rows.Close()
+
+	for _, win := range body.Windows {
+		if !routeStops[win.StopID] {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("stop %q is not on route %s", win.StopID, routeID))
+			return
+		}
+		if (win.Start == nil) != (win.End == nil) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("stop %s: start and end must both be set or both be null", win.StopID))
+			return
+		}
+		if win.Start != nil && win.End.Before(*win.Start) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("stop %s: end must not be before start", win.StopID))
+			return
+		}
+	}
+
+	batch := &pgx.Batch{}
+	for _, win := range body.Windows {
+		batch.Queue(`
+			INSERT INTO trip_stop_progress (trip_id, stop_id, window_start, window_end)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (trip_id, stop_id) DO UPDATE
+			SET window_start = EXCLUDED.window_start,
+			    window_end   = EXCLUDED.window_end
+		`, tripID, win.StopID, win.Start, win.End)
+	}
+	if err := h.tsStore.SendBatch(ctx, batch).Close(); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save windows")
+		return
+	}
+
+	// A moved window deserves a fresh delay prediction.
+	keys := make([]string, 0, len(body.Windows))
+	for _, win := range body.Windows {
+		keys = append(keys, fmt.Sprintf("delay:%s:%s", tripID, win.StopID))
+	}
+	h.redis.Del(ctx, keys...)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"trip_id": tripID,
+		"updated": len(body.Windows),
+	})
+}
+
+// fillStopETAs sets each remaining stop's ETA from the estimator's
+// trip:{id}:stop_etas hash. Missing or expired ETAs leave stops untouched.
+func fillStopETAs(ctx context.Context, rc *redis.Client, tripID string, stops []domain.StopProgress) {
+	etas, err := rc.HGetAll(ctx, fmt.Sprintf("trip:%s:stop_etas", tripID)).Result()
+	if err != nil || len(etas) == 0 {
+		return
+	}
+	for i := range stops {
+		if eta, ok := etas[stops[i].StopID]; ok && stops[i].Status == domain.StopPending {
+			stops[i].ETA = &eta
+		}
+	}
+}
+
+// setStopWindow copies a stop's planned arrival window and punctuality, as
+// read from trip_stop_progress, onto sp.
+func setStopWindow(sp *domain.StopProgress, windowStart, windowEnd *time.Time, punctuality *string) {
+	if windowStart != nil && windowEnd != nil {
+		start, end := windowStart.Format(time.RFC3339), windowEnd.Format(time.RFC3339)
+		sp.WindowStart, sp.WindowEnd = &start, &end
+	}
+	if punctuality != nil {
+		p := domain.Punctuality(*punctuality)
+		sp.Punctuality = &p
+	}
+}
@@ -1,15 +1,20 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 
+	"fleet-monitor/serving/internal/archive"
 	"fleet-monitor/serving/internal/domain"
+	"fleet-monitor/serving/internal/geocode"
 )
 
 // VehicleHandler serves all vehicle-centric endpoints:
@@ -17,15 +22,20 @@ import (
 //	GET /api/v1/vehicles/{vehicle_id}/panel        — full drill-down panel
 //	GET /api/v1/vehicles/{vehicle_id}/active-trip  — shortcut used by the panel
 //	GET /api/v1/vehicles/{vehicle_id}/alerts       — paginated alert history
+//	GET /api/v1/vehicles/{vehicle_id}/telemetry    — position/sensor history
 type VehicleHandler struct {
-	redis   *redis.Client
-	tsStore *pgxpool.Pool // single TimescaleDB pool — all tables live here
+	redis    *redis.Client
+	tsStore  *pgxpool.Pool     // single TimescaleDB pool — all tables live here
+	archive  *archive.Archive  // nil when cold-storage archiving is disabled
+	geocoder *geocode.Geocoder // nil when reverse geocoding is disabled
 }
 
-func NewVehicleHandler(redisClient *redis.Client, tsStore *pgxpool.Pool) *VehicleHandler {
+func NewVehicleHandler(redisClient *redis.Client, tsStore *pgxpool.Pool, a *archive.Archive, geocoder *geocode.Geocoder) *VehicleHandler {
 	return &VehicleHandler{
-		redis:   redisClient,
-		tsStore: tsStore,
+		redis:    redisClient,
+		tsStore:  tsStore,
+		archive:  a,
+		geocoder: geocoder,
 	}
 }
 
@@ -52,6 +62,7 @@ type LiveTelemetry struct {
 	EngineOn     bool    `json:"engine_on"`
 	LastSeenAt   *int64  `json:"last_seen_at"` // unix timestamp, nil if never seen
 	OnlineStatus string  `json:"online_status"`
+	Place        string  `json:"place,omitempty"` // reverse-geocoded last position
 }
 
 // VehicleIdentity is the static registry data shown in the panel identity section.
@@ -107,6 +118,7 @@ func (h *VehicleHandler) HandlePanel(w http.ResponseWriter, r *http.Request) {
 		panel.LiveState.Battery = parseMapFloat(stateMap, "battery")
 		panel.LiveState.IsMoving = parseMapBool(stateMap, "is_moving")
 		panel.LiveState.EngineOn = parseMapBool(stateMap, "engine_on")
+		panel.LiveState.Place = h.geocoder.Label(ctx, parseMapFloat(stateMap, "lat"), parseMapFloat(stateMap, "lng"))
 		if ts, ok := stateMap["received_at"]; ok {
 			if n, e := strconv.ParseInt(ts, 10, 64); e == nil {
 				panel.LiveState.LastSeenAt = &n
@@ -164,7 +176,9 @@ func (h *VehicleHandler) HandlePanel(w http.ResponseWriter, r *http.Request) {
 		rows, qErr := h.tsStore.Query(ctx, `
 			SELECT rs.stop_id, rs.stop_name, rs.stop_sequence,
 			       COALESCE(tsp.status, $1),
-			       tsp.arrived_at, tsp.departed_at
+			       tsp.arrived_at, tsp.departed_at,
+			       tsp.window_start, tsp.window_end,
+			       tsp.punctuality, tsp.arrival_delta_seconds
 			FROM route_stops rs
 			LEFT JOIN trip_stop_progress tsp
 			       ON tsp.stop_id = rs.stop_id AND tsp.trip_id = $2
@@ -175,11 +189,13 @@ func (h *VehicleHandler) HandlePanel(w http.ResponseWriter, r *http.Request) {
 			defer rows.Close()
 			for rows.Next() {
 				var sp domain.StopProgress
-				var arrivedAt, departedAt *time.Time
+				var arrivedAt, departedAt, windowStart, windowEnd *time.Time
+				var punctuality *string
 				var statusStr string
 				if sErr := rows.Scan(
 					&sp.StopID, &sp.StopName, &sp.Sequence,
 					&statusStr, &arrivedAt, &departedAt,
+					&windowStart, &windowEnd, &punctuality, &sp.ArrivalDeltaSeconds,
 				); sErr == nil {
 					sp.Status = domain.StopStatus(statusStr)
 					if arrivedAt != nil {
@@ -190,6 +206,7 @@ func (h *VehicleHandler) HandlePanel(w http.ResponseWriter, r *http.Request) {
 						s := departedAt.Format(time.RFC3339)
 						sp.DepartedAt = &s
 					}
+					setStopWindow(&sp, windowStart, windowEnd, punctuality)
 					tripInfo.Stops = append(tripInfo.Stops, sp)
 					tripInfo.StopsTotal++
 					if sp.Status == domain.StopArrived || sp.Status == domain.StopDeparted {
@@ -214,6 +231,7 @@ func (h *VehicleHandler) HandlePanel(w http.ResponseWriter, r *http.Request) {
 		if etaVal, e := h.redis.Get(ctx, fmt.Sprintf("trip:%s:eta", tripID)).Result(); e == nil {
 			tripInfo.ETA = &etaVal
 		}
+		fillStopETAs(ctx, h.redis, tripID, tripInfo.Stops)
 
 		panel.ActiveTrip = &tripInfo
 
@@ -401,3 +419,182 @@ func (h *VehicleHandler) HandleVehicleAlerts(w http.ResponseWriter, r *http.Requ
 		"pagination": domain.NewPagination(page, limit, total),
 	})
 }
+
+// ── Telemetry history ─────────────────────────────────────────────────────────
+
+const (
+	telemetryHistoryDefaultLimit = 1000
+	telemetryHistoryMaxLimit     = 10000
+	// telemetryHistoryMaxRange bounds from/to; the limit caps the response,
+	// but archive reads are per fleet-day and happen before it applies.
+	telemetryHistoryMaxRange = 31 * 24 * time.Hour
+)
+
+// TelemetryPoint is one historical sample for the vehicle track replay.
+type TelemetryPoint struct {
+	Timestamp   string  `json:"timestamp"` // RFC3339 — vehicle clock
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	SpeedKmh    float64 `json:"speed_kmh"`
+	FuelPct     float64 `json:"fuel_pct"`
+	EngineTempC float64 `json:"engine_temp_celsius"`
+	Battery     float64 `json:"battery_voltage"`
+	OdometerKm  float64 `json:"odometer_km"`
+	IsMoving    bool    `json:"is_moving"`
+	EngineOn    bool    `json:"engine_on"`
+}
+
+// GET /api/v1/vehicles/{vehicle_id}/telemetry
+//
+// Query params: from, to (RFC3339, default last hour, at most 31 days apart),
+// limit (default 1000, max 10000).
+// Returns samples oldest first. The part of the range older than the oldest
+// TimescaleDB chunk is served from the Parquet archive when one is configured.
+func (h *VehicleHandler) HandleTelemetryHistory(w http.ResponseWriter, r *http.Request) {
+	vehicleID := r.PathValue("vehicle_id")
+	if vehicleID == "" {
+		writeError(w, http.StatusBadRequest, "vehicle_id path parameter is required")
+		return
+	}
+
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	to := time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "to must be RFC3339")
+			return
+		}
+		to = t.UTC()
+	}
+	from := to.Add(-time.Hour)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "from must be RFC3339")
+			return
+		}
+		from = t.UTC()
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+	if to.Sub(from) > telemetryHistoryMaxRange {
+		writeError(w, http.StatusBadRequest, "from and to must be at most 31 days apart")
+		return
+	}
+
+	limit := telemetryHistoryDefaultLimit
+	if n, e := strconv.Atoi(q.Get("limit")); e == nil && n > 0 && n <= telemetryHistoryMaxLimit {
+		limit = n
+	}
+
+	// Anything before the oldest live chunk has been dropped by retention.
+	// No chunks at all means the whole range is archive-only.
+	var oldestChunk *time.Time
+	err := h.tsStore.QueryRow(ctx, `
+		SELECT MIN(range_start)
+		FROM timescaledb_information.chunks
+		WHERE hypertable_name = 'vehicle_telemetry'
+	`).Scan(&oldestChunk)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read telemetry retention horizon")
+		return
+	}
+	dbFrom := from
+	if oldestChunk == nil {
+		dbFrom = to
+	} else if oldestChunk.After(dbFrom) {
+		dbFrom = *oldestChunk
+	}
+
+	points := []TelemetryPoint{}
+	sources := []string{}
+
+	// ── Archived range ───────────────────────────────────────────────────────
+	if from.Before(dbFrom) && h.archive != nil {
+		archived, err := h.readArchive(ctx, vehicleID, from, dbFrom)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to read archived telemetry")
+			return
+		}
+		for _, a := range archived {
+			if len(points) >= limit {
+				break
+			}
+			points = append(points, TelemetryPoint{
+				Timestamp:   a.Timestamp.UTC().Format(time.RFC3339),
+				Lat:         a.Latitude,
+				Lng:         a.Longitude,
+				SpeedKmh:    a.SpeedKmh,
+				FuelPct:     a.FuelPct,
+				EngineTempC: a.EngineTempC,
+				Battery:     a.BatteryVoltage,
+				OdometerKm:  a.OdometerKm,
+				IsMoving:    a.IsMoving,
+				EngineOn:    a.EngineOn,
+			})
+		}
+		sources = append(sources, "archive")
+	}
+
+	// ── Live range ───────────────────────────────────────────────────────────
+	if dbFrom.Before(to) && len(points) < limit {
+		rows, err := h.tsStore.Query(ctx, `
+			SELECT timestamp, latitude, longitude, speed_kmh, fuel_pct,
+			       engine_temp_celsius, battery_voltage, odometer_km,
+			       is_moving, engine_on
+			FROM vehicle_telemetry
+			WHERE vehicle_id = $1 AND timestamp >= $2 AND timestamp < $3
+			ORDER BY timestamp
+			LIMIT $4
+		`, vehicleID, dbFrom, to, limit-len(points))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to query telemetry history")
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p TelemetryPoint
+			var ts time.Time
+			if e := rows.Scan(
+				&ts, &p.Lat, &p.Lng, &p.SpeedKmh, &p.FuelPct,
+				&p.EngineTempC, &p.Battery, &p.OdometerKm,
+				&p.IsMoving, &p.EngineOn,
+			); e != nil {
+				continue
+			}
+			p.Timestamp = ts.UTC().Format(time.RFC3339)
+			points = append(points, p)
+		}
+		sources = append(sources, "timescaledb")
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"vehicle_id": vehicleID,
+		"from":       from.Format(time.RFC3339),
+		"to":         to.Format(time.RFC3339),
+		"sources":    sources,
+		"truncated":  len(points) >= limit,
+		"points":     points,
+	})
+}
+
+// readArchive resolves the vehicle's fleet (archive files are partitioned by
+// fleet) and reads its rows from the Parquet archive.
+func (h *VehicleHandler) readArchive(ctx context.Context, vehicleID string, from, to time.Time) ([]archive.Row, error) {
+	var fleetID string
+	err := h.tsStore.QueryRow(ctx, `
+		SELECT fleet_id FROM vehicle_registry WHERE vehicle_id = $1
+	`, vehicleID).Scan(&fleetID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil // unregistered vehicle — nothing archived under a known fleet
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.archive.ReadVehicle(ctx, fleetID, vehicleID, from, to)
+}
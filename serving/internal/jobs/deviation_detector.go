@@ -4,55 +4,117 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"strconv"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 
+	"fleet-monitor/serving/internal/leader"
+	"fleet-monitor/serving/internal/metrics"
+	"fleet-monitor/serving/internal/scheduler"
 	"fleet-monitor/serving/internal/ws"
 )
 
+// deviationExitRatio is the hysteresis band: a vehicle is off route once it
+// is beyond the corridor radius, and back on only once it is within this
+// share of it. Without the band, GPS jitter at the corridor edge flips the
+// verdict on every position, resolving and re-raising the alert each time.
+const deviationExitRatio = 0.8
+
 type DeviationDetector struct {
 	redis    *redis.Client
 	db       *pgxpool.Pool
 	hub      *ws.Hub
 	interval time.Duration
+
+	// trips is keyed by vehicle ID; deviating records the last verdict per
+	// vehicle so live positions only write and broadcast when it changes.
+	// mu serialises the sweep and the live watcher.
+	mu        sync.Mutex
+	trips     map[string]activeTrip
+	deviating map[string]bool
 }
 
 func NewDeviationDetector(rc *redis.Client, db *pgxpool.Pool, hub *ws.Hub, intervalSec int) *DeviationDetector {
 	return &DeviationDetector{
-		redis:    rc,
-		db:       db,
-		hub:      hub,
-		interval: time.Duration(intervalSec) * time.Second,
+		redis:     rc,
+		db:        db,
+		hub:       hub,
+		interval:  time.Duration(intervalSec) * time.Second,
+		trips:     make(map[string]activeTrip),
+		deviating: make(map[string]bool),
 	}
 }
 
-func (d *DeviationDetector) Run(ctx context.Context) {
-	log.Printf("deviation: started (interval=%s)", d.interval)
-	ticker := time.NewTicker(d.interval)
-	defer ticker.Stop()
-	d.tick(ctx)
+// Job evaluates live positions as they arrive; the scheduled pass is the
+// safety net.
+func (d *DeviationDetector) Job() scheduler.Job {
+	return scheduler.Job{Name: "deviation-detector", Interval: d.interval, Run: d.tick, Watch: d.watch}
+}
+
+func (d *DeviationDetector) watch(ctx context.Context) {
+	positions := subscribePositions(ctx, d.redis, "deviation")
+	refresh := time.NewTicker(tripRefreshInterval)
+	defer refresh.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			d.tick(ctx)
+		case p, ok := <-positions:
+			if !ok {
+				return
+			}
+			d.mu.Lock()
+			if t, ok := d.trips[p.VehicleID]; ok {
+				metrics.JobPositions.WithLabelValues("deviation").Inc()
+				d.checkTrip(ctx, t, p.Lat, p.Lng, false)
+			}
+			d.mu.Unlock()
+		case <-refresh.C:
+			d.mu.Lock()
+			if err := d.refreshTrips(ctx); err != nil {
+				log.Printf("deviation: refresh trips: %v", err)
+			}
+			d.mu.Unlock()
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (d *DeviationDetector) tick(ctx context.Context) {
+// tick is the safety-net sweep. It re-asserts every trip's state, so a
+// deviation is re-broadcast each sweep for as long as it lasts. It reports
+// the number of trips checked.
+func (d *DeviationDetector) tick(ctx context.Context) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.refreshTrips(ctx); err != nil {
+		return 0, fmt.Errorf("load trips: %w", err)
+	}
+	checked := 0
+	for _, t := range d.trips {
+		if lat, lng, ok := lastPosition(ctx, d.redis, t.vehicleID); ok {
+			d.checkTrip(ctx, t, lat, lng, true)
+			checked++
+		}
+	}
+	return checked, nil
+}
+
+func (d *DeviationDetector) refreshTrips(ctx context.Context) error {
 	trips, err := d.loadActiveTrips(ctx)
 	if err != nil {
-		log.Printf("deviation: load trips: %v", err)
-		return
+		return err
 	}
+	d.trips = make(map[string]activeTrip, len(trips))
 	for _, t := range trips {
-		d.checkTrip(ctx, t)
+		d.trips[t.vehicleID] = t
+	}
+	for vehicleID := range d.deviating {
+		if _, ok := d.trips[vehicleID]; !ok {
+			delete(d.deviating, vehicleID)
+		}
 	}
+	return nil
 }
 
 type activeTrip struct {
@@ -68,22 +130,22 @@ type stopPoint struct {
 	lng float64
 }
 
-func (d *DeviationDetector) checkTrip(ctx context.Context, t activeTrip) {
-	stateKey := fmt.Sprintf("vehicle:%s:state", t.vehicleID)
-	vals, err := d.redis.HMGet(ctx, stateKey, "lat", "lng").Result()
-	if err != nil || vals[0] == nil || vals[1] == nil {
-		return
+func (d *DeviationDetector) checkTrip(ctx context.Context, t activeTrip, lat, lng float64, sweep bool) {
+	minDist := d.minDistanceToRoute(lat, lng, t.stops)
+	devKey := fmt.Sprintf("vehicle:%s:deviation", t.vehicleID)
+	was, known := d.deviating[t.vehicleID]
+	threshold := t.corridorRadiusKm
+	if was {
+		threshold *= deviationExitRatio
 	}
-	lat, err1 := strconv.ParseFloat(fmt.Sprintf("%v", vals[0]), 64)
-	lng, err2 := strconv.ParseFloat(fmt.Sprintf("%v", vals[1]), 64)
-	if err1 != nil || err2 != nil {
+	off := minDist > threshold
+
+	if !sweep && known && was == off {
 		return
 	}
+	d.deviating[t.vehicleID] = off
 
-	minDist := d.minDistanceToRoute(lat, lng, t.stops)
-	devKey := fmt.Sprintf("vehicle:%s:deviation", t.vehicleID)
-
-	if minDist > t.corridorRadiusKm {
+	if off {
 		d.redis.Set(ctx, devKey, "true", 0)
 		d.fireAlert(ctx, t, lat, lng, minDist)
 		d.hub.BroadcastDeviation(t.fleetID, ws.VehicleDeviationPayload{
@@ -127,24 +189,36 @@ func (d *DeviationDetector) fireAlert(ctx context.Context, t activeTrip, lat, ln
 		severity = "CRITICAL"
 	}
 
-	_, err := d.db.Exec(ctx, `
+	fenced, fenceArgs := leader.Guard(ctx, 4)
+	tag, err := d.db.Exec(ctx, `
 		INSERT INTO vehicle_alerts (vehicle_id, fleet_id, alert_type, severity, triggered_value, created_at)
 		SELECT $1, fleet_id, 'ROUTE_DEVIATION', $2, $3, NOW()
-		FROM vehicle_registry WHERE vehicle_id = $1
-	`, t.vehicleID, severity, deviationKm)
+		FROM vehicle_registry WHERE vehicle_id = $1 AND `+fenced,
+		append([]any{t.vehicleID, severity, deviationKm}, fenceArgs...)...)
 	if err != nil {
 		log.Printf("deviation: insert alert for %s: %v", t.vehicleID, err)
+		d.redis.Del(ctx, dedupKey) // let the next pass retry
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		// Vehicle gone, or this instance no longer leads — release the key
+		// so the leader's own alert is not suppressed.
+		d.redis.Del(ctx, dedupKey)
+		return
 	}
+	metrics.AlertsFired.WithLabelValues("ROUTE_DEVIATION", t.fleetID).Inc()
 }
 
 func (d *DeviationDetector) autoResolve(ctx context.Context, vehicleID, tripID string) {
+	fenced, fenceArgs := leader.Guard(ctx, 2)
 	_, err := d.db.Exec(ctx, `
 		UPDATE vehicle_alerts
 		SET    resolved_at = NOW(), resolved_by = 'system'
 		WHERE  vehicle_id  = $1
 		  AND  alert_type  = 'ROUTE_DEVIATION'
 		  AND  resolved_at IS NULL
-	`, vehicleID)
+		  AND  `+fenced,
+		append([]any{vehicleID}, fenceArgs...)...)
 	if err != nil {
 		log.Printf("deviation: auto-resolve for %s: %v", vehicleID, err)
 		return
@@ -152,13 +226,18 @@ func (d *DeviationDetector) autoResolve(ctx context.Context, vehicleID, tripID s
 	d.redis.Del(ctx, fmt.Sprintf("deviation:%s:%s", vehicleID, tripID))
 }
 
+// loadActiveTrips reads every in-progress trip with its route stops in one
+// query. It runs under mu, so live positions wait on it.
 func (d *DeviationDetector) loadActiveTrips(ctx context.Context) ([]activeTrip, error) {
 	rows, err := d.db.Query(ctx, `
-		SELECT t.trip_id, t.vehicle_id, vr.fleet_id, r.corridor_radius_km
+		SELECT t.trip_id, t.vehicle_id, vr.fleet_id, r.corridor_radius_km,
+		       rs.lat, rs.lng
 		FROM trip t
 		JOIN vehicle_registry vr ON vr.vehicle_id = t.vehicle_id
 		JOIN route_registry r    ON r.route_id    = t.route_id
+		LEFT JOIN route_stops rs ON rs.route_id   = t.route_id
 		WHERE t.status = 'IN_PROGRESS'
+		ORDER BY t.trip_id, rs.stop_sequence
 	`)
 	if err != nil {
 		return nil, err
@@ -168,38 +247,17 @@ func (d *DeviationDetector) loadActiveTrips(ctx context.Context) ([]activeTrip,
 	var trips []activeTrip
 	for rows.Next() {
 		var at activeTrip
-		if err := rows.Scan(&at.tripID, &at.vehicleID, &at.fleetID, &at.corridorRadiusKm); err != nil {
+		var lat, lng *float64
+		if err := rows.Scan(&at.tripID, &at.vehicleID, &at.fleetID, &at.corridorRadiusKm, &lat, &lng); err != nil {
 			continue
 		}
-		stops, err := d.loadRouteStops(ctx, at.tripID)
-		if err != nil {
-			log.Printf("deviation: load stops for trip %s: %v", at.tripID, err)
-			continue
+		if n := len(trips); n == 0 || trips[n-1].tripID != at.tripID {
+			trips = append(trips, at)
 		}
-		at.stops = stops
-		trips = append(trips, at)
-	}
-	return trips, rows.Err()
-}
-
-func (d *DeviationDetector) loadRouteStops(ctx context.Context, tripID string) ([]stopPoint, error) {
-	rows, err := d.db.Query(ctx, `
-		SELECT rs.lat, rs.lng
-		FROM route_stops rs
-		JOIN trip t ON t.route_id = rs.route_id
-		WHERE t.trip_id = $1
-		ORDER BY rs.stop_sequence
-	`, tripID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var stops []stopPoint
-	for rows.Next() {
-		var s stopPoint
-		if rows.Scan(&s.lat, &s.lng) == nil {
-			stops = append(stops, s)
+		if lat != nil && lng != nil {
+			last := &trips[len(trips)-1]
+			last.stops = append(last.stops, stopPoint{lat: *lat, lng: *lng})
 		}
 	}
-	return stops, rows.Err()
+	return trips, rows.Err()
 }
@@ -7,105 +7,410 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
+
+	"fleet-monitor/serving/internal/domain"
+	"fleet-monitor/serving/internal/leader"
+	"fleet-monitor/serving/internal/metrics"
+	"fleet-monitor/serving/internal/scheduler"
+	"fleet-monitor/serving/internal/ws"
+)
+
+// ETAEstimator predicts arrival at every remaining stop of each active trip.
+// The route is walked stop to stop: the segment the vehicle is on is costed
+// from its remaining distance, later segments in full, each at the hour of
+// the week the vehicle is expected to start it. A segment's time blends the
+// learned median from route_segment_stats with the vehicle's smoothed speed;
+// the more samples a bucket has, the more it counts. Learned dwell at each
+// intermediate stop is added on.
+//
+// A stop whose ETA falls more than the delay margin past the end of its
+// planned window raises one DELAY_PREDICTED alert and event per trip stop.
+//
+//	trip:{id}:eta        next stop's ETA, RFC 3339
+//	trip:{id}:stop_etas  HASH stop_id → ETA, RFC 3339, remaining stops only
+const (
+	etaTTL = 10 * time.Minute
+	// defaultSpeedKmh stands in when there is neither history nor a moving
+	// vehicle to learn from.
+	defaultSpeedKmh = 40.0
+	// movingSpeedKmh — below this the vehicle is treated as stopped (lights,
+	// queues) and its speed is not used for the segment it is on.
+	movingSpeedKmh = 5.0
+	// speedSmoothing is the EWMA weight given to each new speed reading.
+	speedSmoothing = 0.3
+	// priorSamples is how many historical samples it takes for history to
+	// carry as much weight as the live speed.
+	priorSamples = 3.0
+	// delayAlertTTL outlives any trip, so a stop is flagged at most once.
+	delayAlertTTL = 24 * time.Hour
 )
 
 type ETAEstimator struct {
 	redis    *redis.Client
 	db       *pgxpool.Pool
+	hub      *ws.Hub
 	interval time.Duration
+	margin   time.Duration
+
+	// speeds is each vehicle's smoothed speed in km/h. Only the scheduled
+	// pass touches it.
+	speeds map[string]float64
 }
 
-func NewETAEstimator(rc *redis.Client, db *pgxpool.Pool, intervalSec int) *ETAEstimator {
+func NewETAEstimator(rc *redis.Client, db *pgxpool.Pool, hub *ws.Hub, intervalSec, delayMarginSec int) *ETAEstimator {
 	return &ETAEstimator{
 		redis:    rc,
 		db:       db,
+		hub:      hub,
 		interval: time.Duration(intervalSec) * time.Second,
+		margin:   time.Duration(delayMarginSec) * time.Second,
+		speeds:   make(map[string]float64),
+	}
+}
+
+func (e *ETAEstimator) Job() scheduler.Job {
+	return scheduler.Job{Name: "eta-estimator", Interval: e.interval, Run: e.tick}
+}
+
+type etaStop struct {
+	stopID    string
+	stopName  string
+	lat       float64
+	lng       float64
+	status    string
+	windowEnd *time.Time
+}
+
+type etaTrip struct {
+	tripID    string
+	vehicleID string
+	fleetID   string
+	routeID   string
+	stops     []etaStop // in sequence order
+}
+
+// segmentStat is one hour-of-week bucket of route_segment_stats.
+type segmentStat struct {
+	samples       float64
+	travelSeconds float64
+	dwellSeconds  float64 // 0 when unknown
+}
+
+// segmentKey identifies a segment by its route and starting stop.
+type segmentKey struct {
+	routeID    string
+	fromStopID string
+}
+
+// segmentModel holds the learned stats for the routes being estimated:
+// per hour of the week, and pooled across the week for sparse buckets.
+type segmentModel struct {
+	byHour map[segmentKey]map[int]segmentStat
+	pooled map[segmentKey]segmentStat
+}
+
+// tick reports the number of trips given ETAs.
+func (e *ETAEstimator) tick(ctx context.Context) (int, error) {
+	trips, err := e.loadTrips(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("load trips: %w", err)
+	}
+	routes := make([]string, 0, len(trips))
+	for _, t := range trips {
+		routes = append(routes, t.routeID)
+	}
+	model, err := e.loadModel(ctx, routes)
+	if err != nil {
+		return 0, fmt.Errorf("load segment stats: %w", err)
+	}
+
+	active := make(map[string]bool, len(trips))
+	estimated := 0
+	for _, t := range trips {
+		active[t.vehicleID] = true
+		if e.estimateTrip(ctx, t, model) {
+			estimated++
+		}
+	}
+	for vehicleID := range e.speeds {
+		if !active[vehicleID] {
+			delete(e.speeds, vehicleID)
+		}
 	}
+	return estimated, nil
 }
 
-func (e *ETAEstimator) Run(ctx context.Context) {
-	log.Printf("eta-estimator: started (interval=%s)", e.interval)
-	ticker := time.NewTicker(e.interval)
-	defer ticker.Stop()
-	e.tick(ctx)
-	for {
-		select {
-		case <-ticker.C:
-			e.tick(ctx)
-		case <-ctx.Done():
-			return
+func (e *ETAEstimator) estimateTrip(ctx context.Context, t etaTrip, model segmentModel) bool {
+	vals, err := e.redis.HMGet(ctx, fmt.Sprintf("vehicle:%s:state", t.vehicleID), "lat", "lng", "speed_kmh").Result()
+	if err != nil || vals[0] == nil || vals[1] == nil {
+		return false
+	}
+	vLat, err1 := strconv.ParseFloat(fmt.Sprintf("%v", vals[0]), 64)
+	vLng, err2 := strconv.ParseFloat(fmt.Sprintf("%v", vals[1]), 64)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	speed := e.smoothSpeed(t.vehicleID, vals[2])
+
+	// The vehicle is heading for the first PENDING stop after the last one
+	// it reached; earlier PENDING stops are about to be marked MISSED.
+	next := 0
+	for i, st := range t.stops {
+		if st.status == "ARRIVED" || st.status == "DEPARTED" || st.status == "MISSED" {
+			next = i + 1
 		}
 	}
+	if next >= len(t.stops) {
+		return false
+	}
+
+	now := time.Now().UTC()
+	clock := now
+	etas := make(map[string]interface{}, len(t.stops)-next)
+	for i := next; i < len(t.stops); i++ {
+		to := t.stops[i]
+		var seconds float64
+		if i == next {
+			seconds = e.currentLegSeconds(t, i, vLat, vLng, speed, clock, model)
+		} else {
+			from := t.stops[i-1]
+			stat, ok := model.lookup(segmentKey{t.routeID, from.stopID}, clock)
+			seconds = legSeconds(haversineKm(from.lat, from.lng, to.lat, to.lng), 1, speed, stat, ok, false)
+		}
+		clock = clock.Add(time.Duration(seconds * float64(time.Second)))
+		etas[to.stopID] = clock.Format(time.RFC3339)
+		if to.windowEnd != nil && clock.Sub(*to.windowEnd) > e.margin {
+			e.flagDelay(ctx, t, to, clock)
+		}
+
+		// Dwell at this stop before leaving for the next one.
+		if i+1 < len(t.stops) {
+			if stat, ok := model.lookup(segmentKey{t.routeID, to.stopID}, clock); ok {
+				clock = clock.Add(time.Duration(stat.dwellSeconds * float64(time.Second)))
+			}
+		}
+	}
+
+	etaKey := fmt.Sprintf("trip:%s:eta", t.tripID)
+	stopsKey := fmt.Sprintf("trip:%s:stop_etas", t.tripID)
+	pipe := e.redis.TxPipeline()
+	pipe.Set(ctx, etaKey, etas[t.stops[next].stopID], etaTTL)
+	pipe.Del(ctx, stopsKey)
+	pipe.HSet(ctx, stopsKey, etas)
+	pipe.Expire(ctx, stopsKey, etaTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("eta-estimator: store etas for trip %s: %v", t.tripID, err)
+		return false
+	}
+	return true
 }
 
-func (e *ETAEstimator) tick(ctx context.Context) {
+// flagDelay raises DELAY_PREDICTED for a stop the first time its ETA breaches
+// the window. Severity is CRITICAL once the delay reaches three margins.
+func (e *ETAEstimator) flagDelay(ctx context.Context, t etaTrip, st etaStop, eta time.Time) {
+	dedupKey := fmt.Sprintf("delay:%s:%s", t.tripID, st.stopID)
+	if set, _ := e.redis.SetNX(ctx, dedupKey, "1", delayAlertTTL).Result(); !set {
+		return
+	}
+
+	delay := eta.Sub(*st.windowEnd)
+	severity := domain.SeverityWarning
+	if delay >= 3*e.margin {
+		severity = domain.SeverityCritical
+	}
+
+	fenced, fenceArgs := leader.Guard(ctx, 4)
+	var alertID int64
+	var createdAt time.Time
+	err := e.db.QueryRow(ctx, `
+		INSERT INTO vehicle_alerts (vehicle_id, fleet_id, alert_type, severity, triggered_value, created_at)
+		SELECT $1, fleet_id, 'DELAY_PREDICTED', $2, $3, NOW()
+		FROM vehicle_registry WHERE vehicle_id = $1 AND `+fenced+`
+		RETURNING id, created_at`,
+		append([]any{t.vehicleID, string(severity), delay.Minutes()}, fenceArgs...)...,
+	).Scan(&alertID, &createdAt)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Printf("eta-estimator: insert delay alert for %s/%s: %v", t.tripID, st.stopID, err)
+		}
+		e.redis.Del(ctx, dedupKey) // try again next pass
+		return
+	}
+	metrics.AlertsFired.WithLabelValues(string(domain.AlertDelayPredicted), t.fleetID).Inc()
+
+	e.hub.BroadcastDelayPredicted(t.fleetID, ws.DelayPredictedPayload{
+		AlertID:      alertID,
+		VehicleID:    t.vehicleID,
+		TripID:       t.tripID,
+		StopID:       st.stopID,
+		StopName:     st.stopName,
+		Severity:     string(severity),
+		WindowEnd:    st.windowEnd.UTC(),
+		ETA:          eta,
+		DelaySeconds: int(delay.Seconds()),
+	})
+}
+
+// currentLegSeconds costs the rest of the segment the vehicle is on. Before
+// the first stop there is no segment to learn from, so only distance and
+// speed count.
+func (e *ETAEstimator) currentLegSeconds(t etaTrip, i int, vLat, vLng, speed float64, at time.Time, model segmentModel) float64 {
+	to := t.stops[i]
+	remainingKm := haversineKm(vLat, vLng, to.lat, to.lng)
+	if i == 0 {
+		return legSeconds(remainingKm, 1, speed, segmentStat{}, false, true)
+	}
+	from := t.stops[i-1]
+	segmentKm := haversineKm(from.lat, from.lng, to.lat, to.lng)
+	fraction := 1.0
+	if segmentKm > 0 {
+		fraction = min(remainingKm/segmentKm, 1)
+	}
+	stat, ok := model.lookup(segmentKey{t.routeID, from.stopID}, at)
+	return legSeconds(remainingKm, fraction, speed, stat, ok, true)
+}
+
+// legSeconds blends history with live speed for distKm of a segment, which
+// is fraction of the whole. On the current leg a stopped vehicle's speed says
+// nothing about the rest of the leg, so history alone is used if it exists.
+func legSeconds(distKm, fraction, speedKmh float64, stat segmentStat, haveStat, current bool) float64 {
+	live := speedKmh
+	if live < movingSpeedKmh {
+		if current && haveStat {
+			return stat.travelSeconds * fraction
+		}
+		live = defaultSpeedKmh
+	}
+	liveSeconds := distKm / live * 3600
+	if !haveStat {
+		return liveSeconds
+	}
+	weight := stat.samples / (stat.samples + priorSamples)
+	return weight*stat.travelSeconds*fraction + (1-weight)*liveSeconds
+}
+
+// smoothSpeed folds the latest reading into the vehicle's EWMA speed. A
+// missing reading leaves the average as it was.
+func (e *ETAEstimator) smoothSpeed(vehicleID string, raw interface{}) float64 {
+	prev, seen := e.speeds[vehicleID]
+	if raw == nil {
+		return prev
+	}
+	reading, err := strconv.ParseFloat(fmt.Sprintf("%v", raw), 64)
+	if err != nil || reading < 0 {
+		return prev
+	}
+	if !seen {
+		e.speeds[vehicleID] = reading
+		return reading
+	}
+	smoothed := speedSmoothing*reading + (1-speedSmoothing)*prev
+	e.speeds[vehicleID] = smoothed
+	return smoothed
+}
+
+func hourOfWeek(t time.Time) int {
+	t = t.UTC()
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+// lookup returns the segment's stats for the hour of the week at t, falling
+// back to the whole week when that hour has never been seen.
+func (m segmentModel) lookup(k segmentKey, t time.Time) (segmentStat, bool) {
+	if st, ok := m.byHour[k][hourOfWeek(t)]; ok {
+		return st, true
+	}
+	st, ok := m.pooled[k]
+	return st, ok
+}
+
+func (e *ETAEstimator) loadTrips(ctx context.Context) ([]etaTrip, error) {
 	rows, err := e.db.Query(ctx, `
-		SELECT t.trip_id, t.vehicle_id, rs.lat, rs.lng
+		SELECT t.trip_id, t.vehicle_id, vr.fleet_id, t.route_id,
+		       rs.stop_id, rs.stop_name, rs.lat, rs.lng,
+		       COALESCE(tsp.status, 'PENDING'), tsp.window_end
 		FROM trip t
-		JOIN route_stops rs ON rs.route_id = t.route_id
+		JOIN vehicle_registry vr ON vr.vehicle_id = t.vehicle_id
+		JOIN route_stops rs      ON rs.route_id   = t.route_id
 		LEFT JOIN trip_stop_progress tsp
 		       ON tsp.trip_id = t.trip_id AND tsp.stop_id = rs.stop_id
 		WHERE t.status = 'IN_PROGRESS'
-		  AND COALESCE(tsp.status, 'PENDING') = 'PENDING'
 		ORDER BY t.trip_id, rs.stop_sequence
 	`)
 	if err != nil {
-		log.Printf("eta-estimator: query: %v", err)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
-	type nextStop struct {
-		vehicleID string
-		lat       float64
-		lng       float64
-	}
-	next := make(map[string]nextStop) // tripID → first pending stop
-
+	var trips []etaTrip
 	for rows.Next() {
-		var tripID, vehicleID string
-		var lat, lng float64
-		if rows.Scan(&tripID, &vehicleID, &lat, &lng) != nil {
+		var tripID, vehicleID, fleetID, routeID string
+		var st etaStop
+		if rows.Scan(
+			&tripID, &vehicleID, &fleetID, &routeID,
+			&st.stopID, &st.stopName, &st.lat, &st.lng,
+			&st.status, &st.windowEnd,
+		) != nil {
 			continue
 		}
-		if _, seen := next[tripID]; !seen {
-			next[tripID] = nextStop{vehicleID: vehicleID, lat: lat, lng: lng}
+		if n := len(trips); n == 0 || trips[n-1].tripID != tripID {
+			trips = append(trips, etaTrip{tripID: tripID, vehicleID: vehicleID, fleetID: fleetID, routeID: routeID})
 		}
+		last := &trips[len(trips)-1]
+		last.stops = append(last.stops, st)
 	}
-
-	for tripID, ns := range next {
-		e.computeAndStore(ctx, tripID, ns.vehicleID, ns.lat, ns.lng)
-	}
+	return trips, rows.Err()
 }
 
-func (e *ETAEstimator) computeAndStore(ctx context.Context, tripID, vehicleID string, destLat, destLng float64) {
-	stateKey := fmt.Sprintf("vehicle:%s:state", vehicleID)
-	vals, err := e.redis.HMGet(ctx, stateKey, "lat", "lng", "speed_kmh").Result()
-	if err != nil || vals[0] == nil || vals[1] == nil {
-		return
+func (e *ETAEstimator) loadModel(ctx context.Context, routeIDs []string) (segmentModel, error) {
+	model := segmentModel{
+		byHour: make(map[segmentKey]map[int]segmentStat),
+		pooled: make(map[segmentKey]segmentStat),
 	}
-
-	vLat, err1 := strconv.ParseFloat(fmt.Sprintf("%v", vals[0]), 64)
-	vLng, err2 := strconv.ParseFloat(fmt.Sprintf("%v", vals[1]), 64)
-	if err1 != nil || err2 != nil {
-		return
+	if len(routeIDs) == 0 {
+		return model, nil
 	}
-
-	speedKmh := 60.0 // default
-	if vals[2] != nil {
-		if s, err := strconv.ParseFloat(fmt.Sprintf("%v", vals[2]), 64); err == nil && s > 5 {
-			speedKmh = s
-		}
+	rows, err := e.db.Query(ctx, `
+		SELECT route_id, from_stop_id, hour_of_week, samples, travel_seconds, COALESCE(dwell_seconds, 0)
+		FROM route_segment_stats
+		WHERE route_id = ANY($1)
+	`, routeIDs)
+	if err != nil {
+		return model, err
 	}
+	defer rows.Close()
 
-	distKm := haversineKm(vLat, vLng, destLat, destLng)
-	etaMinutes := (distKm / speedKmh) * 60
-	eta := time.Now().UTC().Add(time.Duration(etaMinutes) * time.Minute)
+	// Pooled stats are sample-weighted means of the hourly medians.
+	type sums struct{ samples, travel, dwell float64 }
+	pooled := make(map[segmentKey]*sums)
+	for rows.Next() {
+		var k segmentKey
+		var hour int
+		var st segmentStat
+		if rows.Scan(&k.routeID, &k.fromStopID, &hour, &st.samples, &st.travelSeconds, &st.dwellSeconds) != nil {
+			continue
+		}
+		if model.byHour[k] == nil {
+			model.byHour[k] = make(map[int]segmentStat)
+		}
+		model.byHour[k][hour] = st
 
-	etaKey := fmt.Sprintf("trip:%s:eta", tripID)
-	if err := e.redis.Set(ctx, etaKey, eta.Format(time.RFC3339), 10*time.Minute).Err(); err != nil {
-		log.Printf("eta-estimator: store eta for trip %s: %v", tripID, err)
+		p := pooled[k]
+		if p == nil {
+			p = &sums{}
+			pooled[k] = p
+		}
+		p.samples += st.samples
+		p.travel += st.samples * st.travelSeconds
+		p.dwell += st.samples * st.dwellSeconds
+	}
+	for k, p := range pooled {
+		if p.samples > 0 {
+			model.pooled[k] = segmentStat{samples: p.samples, travelSeconds: p.travel / p.samples, dwellSeconds: p.dwell / p.samples}
+		}
 	}
+	return model, rows.Err()
 }
@@ -1,6 +1,13 @@
 package jobs
 
-import "math"
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
 
 func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
 	const r = 6371.0
@@ -23,3 +30,25 @@ func pointToSegmentDistanceKm(pLat, pLng, aLat, aLng, bLat, bLng float64) float6
 	t = math.Max(0, math.Min(1, t))
 	return haversineKm(pLat, pLng, aLat+t*(bLat-aLat), aLng+t*(bLng-aLng))
 }
+
+// lastPosition reads a vehicle's latest position from its live state hash.
+func lastPosition(ctx context.Context, rc *redis.Client, vehicleID string) (lat, lng float64, ok bool) {
+	return hashPosition(ctx, rc, fmt.Sprintf("vehicle:%s:state", vehicleID))
+}
+
+// lastKnownPosition reads a vehicle's latest position from its
+// vehicle:{id}:last_known hash, which outlives the 30s state hash by a month
+// — use it for vehicles that have stopped reporting.
+func lastKnownPosition(ctx context.Context, rc *redis.Client, vehicleID string) (lat, lng float64, ok bool) {
+	return hashPosition(ctx, rc, fmt.Sprintf("vehicle:%s:last_known", vehicleID))
+}
+
+func hashPosition(ctx context.Context, rc *redis.Client, key string) (lat, lng float64, ok bool) {
+	vals, err := rc.HMGet(ctx, key, "lat", "lng").Result()
+	if err != nil || vals[0] == nil || vals[1] == nil {
+		return 0, 0, false
+	}
+	lat, err1 := strconv.ParseFloat(fmt.Sprintf("%v", vals[0]), 64)
+	lng, err2 := strconv.ParseFloat(fmt.Sprintf("%v", vals[1]), 64)
+	return lat, lng, err1 == nil && err2 == nil
+}
@@ -10,52 +10,53 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 
+	"fleet-monitor/serving/internal/geocode"
+	"fleet-monitor/serving/internal/scheduler"
 	"fleet-monitor/serving/internal/ws"
 )
 
+// offlineLookups bounds the place lookups for offline events in flight at
+// once. A mass outage sends the rest without a place rather than queueing
+// them on the provider's rate limit.
+const offlineLookups = 4
+
 type HeartbeatMonitor struct {
 	redis            *redis.Client
 	db               *pgxpool.Pool
 	hub              *ws.Hub
+	geocoder         *geocode.Geocoder // nil = offline events without a place
+	lookups          chan struct{}
 	interval         time.Duration
 	defaultThreshold time.Duration
 }
 
-func NewHeartbeatMonitor(rc *redis.Client, db *pgxpool.Pool, hub *ws.Hub, intervalSec, thresholdSec int) *HeartbeatMonitor {
+func NewHeartbeatMonitor(rc *redis.Client, db *pgxpool.Pool, hub *ws.Hub, geocoder *geocode.Geocoder, intervalSec, thresholdSec int) *HeartbeatMonitor {
 	return &HeartbeatMonitor{
 		redis:            rc,
 		db:               db,
 		hub:              hub,
+		geocoder:         geocoder,
+		lookups:          make(chan struct{}, offlineLookups),
 		interval:         time.Duration(intervalSec) * time.Second,
 		defaultThreshold: time.Duration(thresholdSec) * time.Second,
 	}
 }
 
-func (h *HeartbeatMonitor) Run(ctx context.Context) {
-	log.Printf("heartbeat: started (interval=%s)", h.interval)
-	ticker := time.NewTicker(h.interval)
-	defer ticker.Stop()
-	h.tick(ctx)
-	for {
-		select {
-		case <-ticker.C:
-			h.tick(ctx)
-		case <-ctx.Done():
-			return
-		}
-	}
+func (h *HeartbeatMonitor) Job() scheduler.Job {
+	return scheduler.Job{Name: "heartbeat-monitor", Interval: h.interval, Run: h.tick}
 }
 
-func (h *HeartbeatMonitor) tick(ctx context.Context) {
+// tick reports the number of vehicles checked.
+func (h *HeartbeatMonitor) tick(ctx context.Context) (int, error) {
 	thresholds, _ := h.loadFleetThresholds(ctx)
 
 	rows, err := h.db.Query(ctx, `SELECT vehicle_id, fleet_id FROM vehicle_registry WHERE active = true`)
 	if err != nil {
-		log.Printf("heartbeat: load vehicles: %v", err)
-		return
+		return 0, fmt.Errorf("load vehicles: %w", err)
 	}
 	defer rows.Close()
 
+	checked := 0
 	for rows.Next() {
 		var vehicleID, fleetID string
 		if err := rows.Scan(&vehicleID, &fleetID); err != nil {
@@ -66,7 +67,9 @@ func (h *HeartbeatMonitor) tick(ctx context.Context) {
 			threshold = t
 		}
 		h.check(ctx, vehicleID, fleetID, threshold)
+		checked++
 	}
+	return checked, rows.Err()
 }
 
 func (h *HeartbeatMonitor) check(ctx context.Context, vehicleID, fleetID string, threshold time.Duration) {
@@ -75,7 +78,13 @@ func (h *HeartbeatMonitor) check(ctx context.Context, vehicleID, fleetID string,
 
 	recvStr, err := h.redis.HGet(ctx, stateKey, "received_at").Result()
 	if err == redis.Nil {
-		h.setOffline(ctx, vehicleID, fleetID, onlineKey, time.Time{})
+		// The state hash has expired; the last-known record still says when
+		// the vehicle was last heard from.
+		var lastSeen time.Time
+		if n, e := h.redis.HGet(ctx, fmt.Sprintf("vehicle:%s:last_known", vehicleID), "received_at").Int64(); e == nil {
+			lastSeen = time.Unix(n, 0)
+		}
+		h.setOffline(ctx, vehicleID, fleetID, onlineKey, lastSeen)
 		return
 	}
 	if err != nil {
@@ -105,7 +114,28 @@ func (h *HeartbeatMonitor) setOffline(ctx context.Context, vehicleID, fleetID, o
 	}
 	h.redis.Set(ctx, onlineKey, "offline", 0)
 	log.Printf("heartbeat: %s offline", vehicleID)
-	h.hub.BroadcastOffline(fleetID, ws.VehicleOfflinePayload{VehicleID: vehicleID, LastSeenAt: lastSeen})
+	payload := ws.VehicleOfflinePayload{VehicleID: vehicleID, LastSeenAt: lastSeen}
+	if h.geocoder == nil {
+		h.hub.BroadcastOffline(fleetID, payload)
+		return
+	}
+	select {
+	case h.lookups <- struct{}{}:
+	default:
+		h.hub.BroadcastOffline(fleetID, payload)
+		return
+	}
+	// The state hash expires well before the staleness threshold, so the
+	// place comes from the last-known record. A cache miss can take seconds
+	// with the HTTP provider; look it up off the tick so other vehicles are
+	// not held.
+	go func(ctx context.Context) {
+		defer func() { <-h.lookups }()
+		if lat, lng, ok := lastKnownPosition(ctx, h.redis, vehicleID); ok {
+			payload.Place = h.geocoder.Label(ctx, lat, lng)
+		}
+		h.hub.BroadcastOffline(fleetID, payload)
+	}(context.WithoutCancel(ctx))
 }
 
 func (h *HeartbeatMonitor) loadFleetThresholds(ctx context.Context) (map[string]time.Duration, error) {
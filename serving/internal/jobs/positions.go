@@ -0,0 +1,62 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Trip-bound jobs evaluate every position as ingestion publishes it, so a
+// vehicle that crosses a stop radius between sweeps is still seen there. The
+// periodic sweep stays as a safety net for positions lost while a job was
+// not subscribed (leader failover, Redis reconnects).
+const (
+	positionChannels = "fleet:*:telemetry"
+	positionBuffer   = 1024
+	// tripRefreshInterval bounds how long a newly started trip goes
+	// unevaluated by the live feed.
+	tripRefreshInterval = 10 * time.Second
+)
+
+type position struct {
+	VehicleID string  `json:"vehicle_id"`
+	FleetID   string  `json:"fleet_id"`
+	Lat       float64 `json:"lat"`
+	Lng       float64 `json:"lng"`
+}
+
+// subscribePositions delivers every fleet's live positions until ctx is done.
+// Undecodable messages are dropped.
+func subscribePositions(ctx context.Context, rc *redis.Client, job string) <-chan position {
+	out := make(chan position, positionBuffer)
+	sub := rc.PSubscribe(ctx, positionChannels)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		msgs := sub.Channel(redis.WithChannelSize(positionBuffer))
+		for {
+			select {
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				var p position
+				if err := json.Unmarshal([]byte(msg.Payload), &p); err != nil || p.VehicleID == "" {
+					log.Printf("%s: bad position on %s: %v", job, msg.Channel, err)
+					continue
+				}
+				select {
+				case out <- p:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
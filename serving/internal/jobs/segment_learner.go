@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"fleet-monitor/serving/internal/scheduler"
+)
+
+// SegmentLearner rebuilds route_segment_stats from completed trips: median
+// travel time between consecutive stops, and dwell at the first of them, per
+// hour of the week. Each pass recomputes from the trips completed within
+// window, so the model follows seasonal change and forgets old roadworks.
+type SegmentLearner struct {
+	db       *pgxpool.Pool
+	interval time.Duration
+	window   time.Duration
+}
+
+func NewSegmentLearner(db *pgxpool.Pool, intervalSec, windowDays int) *SegmentLearner {
+	return &SegmentLearner{
+		db:       db,
+		interval: time.Duration(intervalSec) * time.Second,
+		window:   time.Duration(windowDays) * 24 * time.Hour,
+	}
+}
+
+func (l *SegmentLearner) Job() scheduler.Job {
+	return scheduler.Job{Name: "segment-learner", Interval: l.interval, Timeout: 5 * time.Minute, Run: l.tick}
+}
+
+// tick reports the number of (segment, hour) buckets written or dropped.
+// Every bucket that still has trips in the window is rewritten with
+// updated_at = NOW(), the transaction's start, so any bucket left older than
+// that has none and is dropped in the same transaction; the estimator then
+// falls back as for a bucket that never had data.
+func (l *SegmentLearner) tick(ctx context.Context) (int, error) {
+	tx, err := l.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		WITH legs AS (
+			SELECT t.route_id,
+			       a.stop_id AS from_stop_id,
+			       b.stop_id AS to_stop_id,
+			       (EXTRACT(DOW  FROM pa.departed_at AT TIME ZONE 'UTC') * 24 +
+			        EXTRACT(HOUR FROM pa.departed_at AT TIME ZONE 'UTC'))::smallint AS hour_of_week,
+			       EXTRACT(EPOCH FROM pb.arrived_at - pa.departed_at) AS travel_seconds,
+			       EXTRACT(EPOCH FROM pa.departed_at - pa.arrived_at) AS dwell_seconds
+			FROM trip t
+			JOIN route_stops a ON a.route_id = t.route_id
+			JOIN route_stops b ON b.route_id = t.route_id AND b.stop_sequence = a.stop_sequence + 1
+			JOIN trip_stop_progress pa ON pa.trip_id = t.trip_id AND pa.stop_id = a.stop_id
+			JOIN trip_stop_progress pb ON pb.trip_id = t.trip_id AND pb.stop_id = b.stop_id
+			WHERE t.status = 'COMPLETED'
+			  AND t.completed_at > $1
+			  AND pa.departed_at IS NOT NULL
+			  AND pb.arrived_at  > pa.departed_at
+		)
+		INSERT INTO route_segment_stats
+			(route_id, from_stop_id, to_stop_id, hour_of_week, samples, travel_seconds, dwell_seconds, updated_at)
+		SELECT route_id, from_stop_id, to_stop_id, hour_of_week,
+		       COUNT(*),
+		       percentile_cont(0.5) WITHIN GROUP (ORDER BY travel_seconds),
+		       percentile_cont(0.5) WITHIN GROUP (ORDER BY dwell_seconds),
+		       NOW()
+		FROM legs
+		GROUP BY route_id, from_stop_id, to_stop_id, hour_of_week
+		ON CONFLICT (route_id, from_stop_id, hour_of_week) DO UPDATE
+		SET to_stop_id     = EXCLUDED.to_stop_id,
+		    samples        = EXCLUDED.samples,
+		    travel_seconds = EXCLUDED.travel_seconds,
+		    dwell_seconds  = EXCLUDED.dwell_seconds,
+		    updated_at     = EXCLUDED.updated_at
+	`, time.Now().Add(-l.window))
+	if err != nil {
+		return 0, err
+	}
+	dropped, err := tx.Exec(ctx, `DELETE FROM route_segment_stats WHERE updated_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected() + dropped.RowsAffected()), nil
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"strconv"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 
+	"fleet-monitor/serving/internal/leader"
+	"fleet-monitor/serving/internal/metrics"
+	"fleet-monitor/serving/internal/scheduler"
 	"fleet-monitor/serving/internal/ws"
 )
 
@@ -18,6 +21,12 @@ type StopDetector struct {
 	db       *pgxpool.Pool
 	hub      *ws.Hub
 	interval time.Duration
+
+	// trips holds each active trip's stops keyed by vehicle ID, reloaded from
+	// the database every tripRefreshInterval. mu serialises the sweep and
+	// the live watcher.
+	mu    sync.Mutex
+	trips map[string][]tripStopRow
 }
 
 func NewStopDetector(rc *redis.Client, db *pgxpool.Pool, hub *ws.Hub, intervalSec int) *StopDetector {
@@ -26,33 +35,66 @@ func NewStopDetector(rc *redis.Client, db *pgxpool.Pool, hub *ws.Hub, intervalSe
 		db:       db,
 		hub:      hub,
 		interval: time.Duration(intervalSec) * time.Second,
+		trips:    make(map[string][]tripStopRow),
 	}
 }
 
-func (s *StopDetector) Run(ctx context.Context) {
-	log.Printf("stop-detector: started (interval=%s)", s.interval)
-	ticker := time.NewTicker(s.interval)
-	defer ticker.Stop()
-	s.tick(ctx)
+// Job evaluates live positions as they arrive; the scheduled pass is the
+// safety net.
+func (s *StopDetector) Job() scheduler.Job {
+	return scheduler.Job{Name: "stop-detector", Interval: s.interval, Run: s.tick, Watch: s.watch}
+}
+
+func (s *StopDetector) watch(ctx context.Context) {
+	positions := subscribePositions(ctx, s.redis, "stop-detector")
+	refresh := time.NewTicker(tripRefreshInterval)
+	defer refresh.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			s.tick(ctx)
+		case p, ok := <-positions:
+			if !ok {
+				return
+			}
+			s.onPosition(ctx, p)
+		case <-refresh.C:
+			s.mu.Lock()
+			if err := s.refreshTrips(ctx); err != nil {
+				log.Printf("stop-detector: refresh trips: %v", err)
+			}
+			s.mu.Unlock()
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (s *StopDetector) tick(ctx context.Context) {
-	trips, err := s.loadActiveTrips(ctx)
-	if err != nil {
-		log.Printf("stop-detector: load trips: %v", err)
-		return
+// tick is the safety-net sweep: every active trip against its vehicle's
+// last known position. It reports the number of trips evaluated.
+func (s *StopDetector) tick(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refreshTrips(ctx); err != nil {
+		return 0, fmt.Errorf("load trips: %w", err)
 	}
-	for _, t := range trips {
-		s.processTrip(ctx, t)
+	evaluated := 0
+	for vehicleID := range s.trips {
+		lat, lng, ok := lastPosition(ctx, s.redis, vehicleID)
+		if ok {
+			s.evaluate(ctx, vehicleID, lat, lng)
+			evaluated++
+		}
 	}
+	return evaluated, nil
+}
+
+func (s *StopDetector) onPosition(ctx context.Context, p position) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.trips[p.VehicleID]; !ok {
+		return
+	}
+	metrics.JobPositions.WithLabelValues("stop-detector").Inc()
+	s.evaluate(ctx, p.VehicleID, p.Lat, p.Lng)
 }
 
 type tripStopRow struct {
@@ -69,26 +111,15 @@ type tripStopRow struct {
 	isFinalStop     bool
 }
 
-func (s *StopDetector) processTrip(ctx context.Context, tripID string) {
-	stops, err := s.loadTripStops(ctx, tripID)
-	if err != nil || len(stops) == 0 {
+// evaluate advances the vehicle's trip for one position, updating the cached
+// stop statuses to match what it writes.
+func (s *StopDetector) evaluate(ctx context.Context, vehicleID string, lat, lng float64) {
+	stops := s.trips[vehicleID]
+	if len(stops) == 0 {
 		return
 	}
-
-	vehicleID := stops[0].vehicleID
 	fleetID := stops[0].fleetID
 
-	stateKey := fmt.Sprintf("vehicle:%s:state", vehicleID)
-	vals, err := s.redis.HMGet(ctx, stateKey, "lat", "lng").Result()
-	if err != nil || vals[0] == nil || vals[1] == nil {
-		return
-	}
-	lat, err1 := strconv.ParseFloat(fmt.Sprintf("%v", vals[0]), 64)
-	lng, err2 := strconv.ParseFloat(fmt.Sprintf("%v", vals[1]), 64)
-	if err1 != nil || err2 != nil {
-		return
-	}
-
 	// Find max sequence that has been ARRIVED/DEPARTED to detect missed stops.
 	maxArrivedSeq := 0
 	for _, st := range stops {
@@ -97,18 +128,21 @@ func (s *StopDetector) processTrip(ctx context.Context, tripID string) {
 		}
 	}
 
-	for i, st := range stops {
+	for i := range stops {
+		st := &stops[i]
 		dist := haversineKm(lat, lng, st.stopLat, st.stopLng)
 
 		switch st.status {
 		case "PENDING":
 			if st.stopSequence < maxArrivedSeq {
 				s.markStop(ctx, st.tripID, st.stopID, "MISSED", nil, nil)
+				st.status = "MISSED"
 				continue
 			}
 			if dist <= st.arrivalRadiusKm {
 				now := time.Now().UTC()
 				s.markStop(ctx, st.tripID, st.stopID, "ARRIVED", &now, nil)
+				st.status = "ARRIVED"
 				s.hub.BroadcastStopArrived(fleetID, ws.StopArrivedPayload{
 					VehicleID: vehicleID,
 					TripID:    st.tripID,
@@ -118,6 +152,8 @@ func (s *StopDetector) processTrip(ctx context.Context, tripID string) {
 				})
 				if st.isFinalStop {
 					s.completeTrip(ctx, st.tripID)
+					delete(s.trips, vehicleID)
+					return
 				}
 			}
 
@@ -125,30 +161,48 @@ func (s *StopDetector) processTrip(ctx context.Context, tripID string) {
 			if dist > st.arrivalRadiusKm {
 				now := time.Now().UTC()
 				s.markStop(ctx, st.tripID, st.stopID, "DEPARTED", nil, &now)
+				st.status = "DEPARTED"
 			}
-			_ = i
 		}
 	}
 }
 
+// markStop records a stop's new status. Arriving at or missing a stop with a
+// planned window also records its punctuality.
 func (s *StopDetector) markStop(ctx context.Context, tripID, stopID, status string, arrivedAt, departedAt *time.Time) {
+	fenced, fenceArgs := leader.Guard(ctx, 6)
 	_, err := s.db.Exec(ctx, `
 		UPDATE trip_stop_progress
 		SET    status      = $1,
 		       arrived_at  = COALESCE($2, arrived_at),
-		       departed_at = COALESCE($3, departed_at)
-		WHERE  trip_id = $4 AND stop_id = $5
-	`, status, arrivedAt, departedAt, tripID, stopID)
+		       departed_at = COALESCE($3, departed_at),
+		       punctuality = CASE
+		           WHEN window_end IS NULL     THEN punctuality
+		           WHEN $1 = 'MISSED'          THEN 'MISSED'
+		           WHEN $1 <> 'ARRIVED'        THEN punctuality
+		           WHEN $2 < window_start      THEN 'EARLY'
+		           WHEN $2 > window_end        THEN 'LATE'
+		           ELSE 'ON_TIME'
+		       END,
+		       arrival_delta_seconds = CASE
+		           WHEN window_end IS NULL OR $1 <> 'ARRIVED' THEN arrival_delta_seconds
+		           WHEN $2 < window_start THEN -EXTRACT(EPOCH FROM window_start - $2)::int
+		           WHEN $2 > window_end   THEN  EXTRACT(EPOCH FROM $2 - window_end)::int
+		           ELSE 0
+		       END
+		WHERE  trip_id = $4 AND stop_id = $5 AND `+fenced,
+		append([]any{status, arrivedAt, departedAt, tripID, stopID}, fenceArgs...)...)
 	if err != nil {
 		log.Printf("stop-detector: markStop %s/%s → %s: %v", tripID, stopID, status, err)
 	}
 }
 
 func (s *StopDetector) completeTrip(ctx context.Context, tripID string) {
+	fenced, fenceArgs := leader.Guard(ctx, 2)
 	_, err := s.db.Exec(ctx, `
 		UPDATE trip SET status = 'COMPLETED', completed_at = NOW()
-		WHERE trip_id = $1
-	`, tripID)
+		WHERE trip_id = $1 AND `+fenced,
+		append([]any{tripID}, fenceArgs...)...)
 	if err != nil {
 		log.Printf("stop-detector: complete trip %s: %v", tripID, err)
 	} else {
@@ -156,23 +210,9 @@ func (s *StopDetector) completeTrip(ctx context.Context, tripID string) {
 	}
 }
 
-func (s *StopDetector) loadActiveTrips(ctx context.Context) ([]string, error) {
-	rows, err := s.db.Query(ctx, `SELECT trip_id FROM trip WHERE status = 'IN_PROGRESS'`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var ids []string
-	for rows.Next() {
-		var id string
-		if rows.Scan(&id) == nil {
-			ids = append(ids, id)
-		}
-	}
-	return ids, rows.Err()
-}
-
-func (s *StopDetector) loadTripStops(ctx context.Context, tripID string) ([]tripStopRow, error) {
+// refreshTrips reloads every in-progress trip's stops in one query. Callers
+// hold mu.
+func (s *StopDetector) refreshTrips(ctx context.Context) error {
 	rows, err := s.db.Query(ctx, `
 		SELECT t.trip_id, t.vehicle_id, vr.fleet_id,
 		       rs.stop_id, rs.stop_name, rs.stop_sequence,
@@ -184,14 +224,14 @@ func (s *StopDetector) loadTripStops(ctx context.Context, tripID string) ([]trip
 		JOIN route_stops rs           ON rs.route_id   = t.route_id
 		LEFT JOIN trip_stop_progress tsp
 		       ON tsp.trip_id = t.trip_id AND tsp.stop_id = rs.stop_id
-		WHERE t.trip_id = $1
-		ORDER BY rs.stop_sequence
-	`, tripID)
+		WHERE t.status = 'IN_PROGRESS'
+		ORDER BY t.trip_id, rs.stop_sequence
+	`)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
-	var out []tripStopRow
+	trips := make(map[string][]tripStopRow)
 	for rows.Next() {
 		var r tripStopRow
 		if err := rows.Scan(
@@ -202,7 +242,11 @@ func (s *StopDetector) loadTripStops(ctx context.Context, tripID string) ([]trip
 		); err != nil {
 			continue
 		}
-		out = append(out, r)
+		trips[r.vehicleID] = append(trips[r.vehicleID], r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
-	return out, rows.Err()
+	s.trips = trips
+	return nil
 }
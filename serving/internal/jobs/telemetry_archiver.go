@@ -0,0 +1,191 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"fleet-monitor/serving/internal/archive"
+	"fleet-monitor/serving/internal/scheduler"
+)
+
+// TelemetryArchiver exports vehicle_telemetry chunks to Parquet before
+// retention drops them. A chunk is picked up once its range_end is within
+// lead of the retention horizon, so the archive is written while the data is
+// still in TimescaleDB. telemetry_archive records what has been exported,
+// and the drop_archived_chunks job (init_db step 7) drops only chunks listed
+// there — a chunk this job has not reached yet is kept, however old.
+type TelemetryArchiver struct {
+	db       *pgxpool.Pool
+	archive  *archive.Archive
+	interval time.Duration
+	lead     time.Duration
+}
+
+func NewTelemetryArchiver(db *pgxpool.Pool, a *archive.Archive, intervalSec, leadHours int) *TelemetryArchiver {
+	return &TelemetryArchiver{
+		db:       db,
+		archive:  a,
+		interval: time.Duration(intervalSec) * time.Second,
+		lead:     time.Duration(leadHours) * time.Hour,
+	}
+}
+
+// Job allows a pass up to a day: a backlog of chunks is worked through in
+// one pass, however short the interval.
+func (a *TelemetryArchiver) Job() scheduler.Job {
+	return scheduler.Job{Name: "telemetry-archiver", Interval: a.interval, Timeout: 24 * time.Hour, Run: a.tick}
+}
+
+type pendingChunk struct {
+	name       string
+	rangeStart time.Time
+	rangeEnd   time.Time
+}
+
+// tick reports the number of chunks archived.
+func (a *TelemetryArchiver) tick(ctx context.Context) (int, error) {
+	chunks, err := a.loadPendingChunks(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("load chunks: %w", err)
+	}
+	for i, c := range chunks {
+		if err := a.archiveChunk(ctx, c); err != nil {
+			// keep chunk order — retry from here next tick
+			return i, fmt.Errorf("chunk %s: %w", c.name, err)
+		}
+	}
+	return len(chunks), nil
+}
+
+// loadPendingChunks returns unarchived chunks that are within the lead window
+// of the retention horizon, or past it, oldest first. The horizon comes from
+// the drop_archived_chunks job, or the plain retention policy on installs
+// that opted out of archive-gated retention. No retention means nothing is
+// ever dropped, so nothing is returned.
+func (a *TelemetryArchiver) loadPendingChunks(ctx context.Context) ([]pendingChunk, error) {
+	rows, err := a.db.Query(ctx, `
+		WITH policy AS (
+			SELECT (config->>'drop_after')::INTERVAL AS drop_after
+			FROM timescaledb_information.jobs
+			WHERE proc_name = 'drop_archived_chunks'
+			   OR (proc_name = 'policy_retention' AND hypertable_name = 'vehicle_telemetry')
+			LIMIT 1
+		)
+		SELECT c.chunk_name, c.range_start, c.range_end
+		FROM timescaledb_information.chunks c, policy p
+		WHERE c.hypertable_name = 'vehicle_telemetry'
+		  AND c.range_end < NOW() - p.drop_after + make_interval(secs => $1)
+		  AND NOT EXISTS (
+		        SELECT 1 FROM telemetry_archive ta WHERE ta.chunk_name = c.chunk_name
+		      )
+		ORDER BY c.range_start
+	`, a.lead.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []pendingChunk
+	for rows.Next() {
+		var c pendingChunk
+		if rows.Scan(&c.name, &c.rangeStart, &c.rangeEnd) == nil {
+			out = append(out, c)
+		}
+	}
+	return out, rows.Err()
+}
+
+// archiveChunk streams the chunk ordered by fleet and time, cutting a new
+// Parquet file at every (fleet, UTC day) boundary so only one partition is
+// held in memory at a time.
+func (a *TelemetryArchiver) archiveChunk(ctx context.Context, c pendingChunk) error {
+	started := time.Now()
+
+	rows, err := a.db.Query(ctx, `
+		SELECT timestamp, received_at, vehicle_id, fleet_id,
+		       latitude, longitude, speed_kmh, fuel_pct,
+		       engine_temp_celsius, battery_voltage, odometer_km,
+		       is_moving, engine_on
+		FROM vehicle_telemetry
+		WHERE timestamp >= $1 AND timestamp < $2
+		ORDER BY fleet_id, timestamp
+	`, c.rangeStart, c.rangeEnd)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	manifest := archive.Manifest{
+		ChunkName:  c.name,
+		RangeStart: c.rangeStart,
+		RangeEnd:   c.rangeEnd,
+	}
+
+	var batch []archive.Row
+	var batchFleet string
+	var batchDay time.Time
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		file, err := a.archive.WritePartition(ctx, c.name, batchFleet, batchDay, batch)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+		manifest.RowCount += int64(len(batch))
+		batch = nil
+		return nil
+	}
+
+	for rows.Next() {
+		var r archive.Row
+		if err := rows.Scan(
+			&r.Timestamp, &r.ReceivedAt, &r.VehicleID, &r.FleetID,
+			&r.Latitude, &r.Longitude, &r.SpeedKmh, &r.FuelPct,
+			&r.EngineTempC, &r.BatteryVoltage, &r.OdometerKm,
+			&r.IsMoving, &r.EngineOn,
+		); err != nil {
+			return err
+		}
+		day := r.Timestamp.UTC().Truncate(24 * time.Hour)
+		if r.FleetID != batchFleet || !day.Equal(batchDay) {
+			if err := flush(); err != nil {
+				return err
+			}
+			batchFleet, batchDay = r.FleetID, day
+		}
+		batch = append(batch, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	manifest.ArchivedAt = time.Now().UTC()
+	if err := a.archive.WriteManifest(ctx, manifest); err != nil {
+		return err
+	}
+
+	_, err = a.db.Exec(ctx, `
+		INSERT INTO telemetry_archive
+			(chunk_name, range_start, range_end, row_count, file_count, manifest_key, archived_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (chunk_name) DO NOTHING
+	`, c.name, c.rangeStart, c.rangeEnd, manifest.RowCount, len(manifest.Files),
+		archive.ManifestKey(c.name), manifest.ArchivedAt)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("archiver: chunk %s archived (%d rows, %d files, %s)",
+		c.name, manifest.RowCount, len(manifest.Files), time.Since(started).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,380 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+
+	"fleet-monitor/serving/internal/domain"
+	"fleet-monitor/serving/internal/leader"
+	"fleet-monitor/serving/internal/metrics"
+	"fleet-monitor/serving/internal/scheduler"
+	"fleet-monitor/serving/internal/ws"
+)
+
+// UnplannedStopDetector finds vehicles on a trip that sit still away from
+// every planned stop. A halt starts at the first position of a stationary
+// run and lasts while later positions stay within radius of it; the sweep
+// extends it for vehicles that have gone quiet, which a parked vehicle with
+// its engine off does.
+//
+// A halt longer than minDuration outside every route_stops arrival radius is
+// recorded in unplanned_stops; one longer than alertAfter also raises a
+// WARNING UNPLANNED_STOP alert and event. While a recorded halt is open:
+//
+//	vehicle:{id}:unplanned_stop  unplanned_stops.id
+const haltKeyTTL = 5 * time.Minute
+
+type UnplannedStopDetector struct {
+	redis       *redis.Client
+	db          *pgxpool.Pool
+	store       haltStore
+	hub         *ws.Hub
+	interval    time.Duration
+	minDuration time.Duration
+	alertAfter  time.Duration
+	radiusKm    float64
+
+	// position is where the sweep finds a quiet vehicle: its last-known
+	// record, since the state hash is gone 30s after the last report.
+	position func(ctx context.Context, vehicleID string) (lat, lng float64, ok bool)
+
+	// trips and halts are keyed by vehicle ID. mu serialises the sweep and
+	// the live watcher.
+	mu    sync.Mutex
+	trips map[string]haltTrip
+	halts map[string]*halt
+}
+
+func NewUnplannedStopDetector(rc *redis.Client, db *pgxpool.Pool, hub *ws.Hub, intervalSec, minMinutes, alertMinutes, radiusMeters int) *UnplannedStopDetector {
+	return &UnplannedStopDetector{
+		redis:       rc,
+		db:          db,
+		store:       pgHaltStore{db: db},
+		hub:         hub,
+		interval:    time.Duration(intervalSec) * time.Second,
+		minDuration: time.Duration(minMinutes) * time.Minute,
+		alertAfter:  time.Duration(alertMinutes) * time.Minute,
+		radiusKm:    float64(radiusMeters) / 1000,
+		trips:       make(map[string]haltTrip),
+		halts:       make(map[string]*halt),
+		position: func(ctx context.Context, vehicleID string) (float64, float64, bool) {
+			return lastKnownPosition(ctx, rc, vehicleID)
+		},
+	}
+}
+
+func (u *UnplannedStopDetector) Job() scheduler.Job {
+	return scheduler.Job{Name: "unplanned-stop-detector", Interval: u.interval, Run: u.tick, Watch: u.watch}
+}
+
+type plannedStop struct {
+	lat, lng        float64
+	arrivalRadiusKm float64
+}
+
+type haltTrip struct {
+	tripID  string
+	fleetID string
+	stops   []plannedStop
+}
+
+type halt struct {
+	tripID   string
+	lat, lng float64 // where the vehicle came to rest
+	since    time.Time
+	lastSeen time.Time // last position still within radius
+	id       int64     // unplanned_stops row, 0 until the halt qualifies
+	alerted  bool
+}
+
+func (u *UnplannedStopDetector) watch(ctx context.Context) {
+	positions := subscribePositions(ctx, u.redis, "unplanned-stop-detector")
+	refresh := time.NewTicker(tripRefreshInterval)
+	defer refresh.Stop()
+	for {
+		select {
+		case p, ok := <-positions:
+			if !ok {
+				return
+			}
+			u.onPosition(ctx, p)
+		case <-refresh.C:
+			u.mu.Lock()
+			if err := u.refresh(ctx); err != nil {
+				log.Printf("unplanned-stop-detector: refresh: %v", err)
+			}
+			u.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tick refreshes the trips and runs the sweep. It reports the number of
+// vehicles evaluated.
+func (u *UnplannedStopDetector) tick(ctx context.Context) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.refresh(ctx); err != nil {
+		return 0, err
+	}
+	return u.sweep(ctx, time.Now().UTC()), nil
+}
+
+// sweep re-evaluates every open halt at the vehicle's last known position,
+// so a halt keeps growing after the vehicle stops reporting. Vehicles
+// without a halt are left to the live watcher. Callers hold mu.
+func (u *UnplannedStopDetector) sweep(ctx context.Context, now time.Time) int {
+	evaluated := 0
+	for vehicleID, h := range u.halts {
+		if u.trips[vehicleID].tripID != h.tripID {
+			continue
+		}
+		if lat, lng, ok := u.position(ctx, vehicleID); ok {
+			u.evaluate(ctx, vehicleID, lat, lng, now)
+			evaluated++
+		}
+	}
+	return evaluated
+}
+
+func (u *UnplannedStopDetector) onPosition(ctx context.Context, p position) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.trips[p.VehicleID]; !ok {
+		return
+	}
+	metrics.JobPositions.WithLabelValues("unplanned-stop-detector").Inc()
+	u.evaluate(ctx, p.VehicleID, p.Lat, p.Lng, time.Now().UTC())
+}
+
+func (u *UnplannedStopDetector) evaluate(ctx context.Context, vehicleID string, lat, lng float64, now time.Time) {
+	t := u.trips[vehicleID]
+	h := u.halts[vehicleID]
+	if h == nil || h.tripID != t.tripID || haversineKm(lat, lng, h.lat, h.lng) > u.radiusKm {
+		if h != nil {
+			u.closeHalt(ctx, vehicleID, h)
+		}
+		u.halts[vehicleID] = &halt{tripID: t.tripID, lat: lat, lng: lng, since: now, lastSeen: now}
+		return
+	}
+	h.lastSeen = now
+	stationary := now.Sub(h.since)
+
+	if h.id == 0 {
+		if stationary < u.minDuration || t.nearPlannedStop(h.lat, h.lng) {
+			return
+		}
+		if !u.openHalt(ctx, vehicleID, t, h) {
+			return
+		}
+	}
+	u.redis.Set(ctx, fmt.Sprintf("vehicle:%s:unplanned_stop", vehicleID), h.id, haltKeyTTL)
+
+	if !h.alerted && stationary >= u.alertAfter {
+		u.fireAlert(ctx, vehicleID, t, h, stationary)
+	}
+}
+
+func (t haltTrip) nearPlannedStop(lat, lng float64) bool {
+	for _, st := range t.stops {
+		if haversineKm(lat, lng, st.lat, st.lng) <= st.arrivalRadiusKm {
+			return true
+		}
+	}
+	return false
+}
+
+// openHalt records a qualifying halt. It reports false when nothing was
+// written, so the next position tries again.
+func (u *UnplannedStopDetector) openHalt(ctx context.Context, vehicleID string, t haltTrip, h *halt) bool {
+	id, err := u.store.open(ctx, vehicleID, t, h)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Printf("unplanned-stop-detector: record halt for %s: %v", vehicleID, err)
+		}
+		return false
+	}
+	h.id = id
+	return true
+}
+
+// closeHalt forgets a halt and, if it was recorded, ends it.
+func (u *UnplannedStopDetector) closeHalt(ctx context.Context, vehicleID string, h *halt) {
+	delete(u.halts, vehicleID)
+	if h.id == 0 {
+		return
+	}
+	u.redis.Del(ctx, fmt.Sprintf("vehicle:%s:unplanned_stop", vehicleID))
+	if err := u.store.close(ctx, h); err != nil {
+		log.Printf("unplanned-stop-detector: close halt %d: %v", h.id, err)
+	}
+}
+
+// fireAlert raises UNPLANNED_STOP once per halt, when it passes alertAfter.
+func (u *UnplannedStopDetector) fireAlert(ctx context.Context, vehicleID string, t haltTrip, h *halt, stationary time.Duration) {
+	severity := domain.SeverityWarning
+	alertID, err := u.store.alert(ctx, vehicleID, t, h, severity, stationary)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Printf("unplanned-stop-detector: insert alert for %s: %v", vehicleID, err)
+		}
+		return
+	}
+	h.alerted = true
+	metrics.AlertsFired.WithLabelValues(string(domain.AlertUnplannedStop), t.fleetID).Inc()
+
+	u.hub.BroadcastUnplannedStop(t.fleetID, ws.UnplannedStopPayload{
+		AlertID:         alertID,
+		UnplannedStopID: h.id,
+		VehicleID:       vehicleID,
+		TripID:          t.tripID,
+		Lat:             h.lat,
+		Lng:             h.lng,
+		StartedAt:       h.since,
+		DurationSeconds: int(stationary.Seconds()),
+		Severity:        string(severity),
+	})
+}
+
+// refresh reloads the vehicles on a trip and their planned stops, ends halts
+// of trips that are over, and picks up halts a previous leader left open.
+// Callers hold mu.
+func (u *UnplannedStopDetector) refresh(ctx context.Context) error {
+	rows, err := u.db.Query(ctx, `
+		SELECT t.trip_id, t.vehicle_id, vr.fleet_id,
+		       rs.lat, rs.lng, rs.arrival_radius_km
+		FROM trip t
+		JOIN vehicle_registry vr ON vr.vehicle_id = t.vehicle_id
+		JOIN route_stops rs      ON rs.route_id   = t.route_id
+		WHERE t.status = 'IN_PROGRESS'
+	`)
+	if err != nil {
+		return fmt.Errorf("load trips: %w", err)
+	}
+	trips := make(map[string]haltTrip)
+	for rows.Next() {
+		var tripID, vehicleID, fleetID string
+		var st plannedStop
+		if rows.Scan(&tripID, &vehicleID, &fleetID, &st.lat, &st.lng, &st.arrivalRadiusKm) != nil {
+			continue
+		}
+		t := trips[vehicleID]
+		t.tripID, t.fleetID = tripID, fleetID
+		t.stops = append(t.stops, st)
+		trips[vehicleID] = t
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load trips: %w", err)
+	}
+	u.trips = trips
+
+	for vehicleID, h := range u.halts {
+		if t, ok := trips[vehicleID]; !ok || t.tripID != h.tripID {
+			u.closeHalt(ctx, vehicleID, h)
+		}
+	}
+
+	// Halts left open by a previous leader: resume those whose trip is still
+	// running, end the rest when their trip ended.
+	fenced, fenceArgs := leader.Guard(ctx, 1)
+	_, err = u.db.Exec(ctx, `
+		UPDATE unplanned_stops us
+		SET    ended_at         = GREATEST(us.started_at, COALESCE(t.completed_at, NOW())),
+		       duration_seconds = EXTRACT(EPOCH FROM GREATEST(us.started_at, COALESCE(t.completed_at, NOW())) - us.started_at)::int
+		FROM   trip t
+		WHERE  t.trip_id = us.trip_id
+		  AND  us.ended_at IS NULL
+		  AND  t.status <> 'IN_PROGRESS'
+		  AND  `+fenced,
+		fenceArgs...)
+	if err != nil {
+		return fmt.Errorf("end halts of finished trips: %w", err)
+	}
+
+	rows, err = u.db.Query(ctx, `
+		SELECT id, vehicle_id, trip_id, latitude, longitude, started_at, alert_id IS NOT NULL
+		FROM unplanned_stops
+		WHERE ended_at IS NULL
+	`)
+	if err != nil {
+		return fmt.Errorf("load open halts: %w", err)
+	}
+	defer rows.Close()
+	now := time.Now().UTC()
+	for rows.Next() {
+		var vehicleID string
+		h := &halt{lastSeen: now}
+		if rows.Scan(&h.id, &vehicleID, &h.tripID, &h.lat, &h.lng, &h.since, &h.alerted) != nil {
+			continue
+		}
+		if _, known := u.halts[vehicleID]; known || trips[vehicleID].tripID != h.tripID {
+			continue
+		}
+		u.halts[vehicleID] = h
+	}
+	return rows.Err()
+}
+
+// haltStore writes halts and their alerts. Each write is fenced on the
+// leader lease and reports pgx.ErrNoRows when the fence or the row is gone.
+type haltStore interface {
+	open(ctx context.Context, vehicleID string, t haltTrip, h *halt) (int64, error)
+	close(ctx context.Context, h *halt) error
+	alert(ctx context.Context, vehicleID string, t haltTrip, h *halt, severity domain.AlertSeverity, stationary time.Duration) (int64, error)
+}
+
+type pgHaltStore struct {
+	db *pgxpool.Pool
+}
+
+func (s pgHaltStore) open(ctx context.Context, vehicleID string, t haltTrip, h *halt) (int64, error) {
+	fenced, fenceArgs := leader.Guard(ctx, 7)
+	var id int64
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO unplanned_stops (vehicle_id, fleet_id, trip_id, latitude, longitude, started_at)
+		SELECT $1, $2, $3, $4, $5, $6
+		WHERE `+fenced+`
+		RETURNING id`,
+		append([]any{vehicleID, t.fleetID, t.tripID, h.lat, h.lng, h.since}, fenceArgs...)...,
+	).Scan(&id)
+	return id, err
+}
+
+// close ends a recorded halt at the last position seen inside it.
+func (s pgHaltStore) close(ctx context.Context, h *halt) error {
+	fenced, fenceArgs := leader.Guard(ctx, 3)
+	_, err := s.db.Exec(ctx, `
+		UPDATE unplanned_stops
+		SET    ended_at         = $2,
+		       duration_seconds = EXTRACT(EPOCH FROM $2 - started_at)::int
+		WHERE  id = $1 AND ended_at IS NULL AND `+fenced,
+		append([]any{h.id, h.lastSeen}, fenceArgs...)...)
+	return err
+}
+
+func (s pgHaltStore) alert(ctx context.Context, vehicleID string, t haltTrip, h *halt, severity domain.AlertSeverity, stationary time.Duration) (int64, error) {
+	fenced, fenceArgs := leader.Guard(ctx, 6)
+	var alertID int64
+	err := s.db.QueryRow(ctx, `
+		WITH alert AS (
+			INSERT INTO vehicle_alerts (vehicle_id, fleet_id, alert_type, severity, triggered_value, created_at)
+			SELECT $1, $2, 'UNPLANNED_STOP', $3, $4, NOW()
+			WHERE `+fenced+`
+			RETURNING id
+		)
+		UPDATE unplanned_stops SET alert_id = (SELECT id FROM alert)
+		WHERE id = $5 AND EXISTS (SELECT 1 FROM alert)
+		RETURNING alert_id`,
+		append([]any{vehicleID, t.fleetID, string(severity), stationary.Minutes(), h.id}, fenceArgs...)...,
+	).Scan(&alertID)
+	return alertID, err
+}
@@ -0,0 +1,129 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"fleet-monitor/serving/internal/domain"
+	"fleet-monitor/serving/internal/ws"
+)
+
+// fakeHaltStore records what the detector writes.
+type fakeHaltStore struct {
+	opened  []string
+	closed  []int64
+	alerted []domain.AlertSeverity
+}
+
+func (s *fakeHaltStore) open(_ context.Context, vehicleID string, _ haltTrip, _ *halt) (int64, error) {
+	s.opened = append(s.opened, vehicleID)
+	return int64(len(s.opened)), nil
+}
+
+func (s *fakeHaltStore) close(_ context.Context, h *halt) error {
+	s.closed = append(s.closed, h.id)
+	return nil
+}
+
+func (s *fakeHaltStore) alert(_ context.Context, _ string, _ haltTrip, _ *halt, severity domain.AlertSeverity, _ time.Duration) (int64, error) {
+	s.alerted = append(s.alerted, severity)
+	return int64(len(s.alerted)), nil
+}
+
+const (
+	testVehicle = "truck-1"
+	restLat     = 52.52
+	restLng     = 13.405
+	plannedLat  = 52.60
+	plannedLng  = 13.50
+)
+
+// newTestDetector has one vehicle on a trip whose only planned stop is well
+// away from restLat/restLng. Redis points nowhere: the halt key and event
+// writes fail and are ignored, as they are when Redis is down.
+func newTestDetector(t *testing.T, lastKnown map[string][2]float64) (*UnplannedStopDetector, *fakeHaltStore) {
+	rc := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 50 * time.Millisecond})
+	t.Cleanup(func() { rc.Close() })
+
+	store := &fakeHaltStore{}
+	u := NewUnplannedStopDetector(rc, nil, ws.NewHub(rc, nil, ws.Config{}), 60, 10, 30, 100)
+	u.store = store
+	u.position = func(_ context.Context, vehicleID string) (float64, float64, bool) {
+		p, ok := lastKnown[vehicleID]
+		return p[0], p[1], ok
+	}
+	u.trips[testVehicle] = haltTrip{
+		tripID:  "trip-1",
+		fleetID: "fleet-a",
+		stops:   []plannedStop{{lat: plannedLat, lng: plannedLng, arrivalRadiusKm: 0.2}},
+	}
+	return u, store
+}
+
+// A vehicle that parks and goes quiet sends its last positions and then
+// nothing; only the sweep sees the halt grow past minDuration and alertAfter.
+func TestSweepExtendsHaltAfterPositionsStop(t *testing.T) {
+	u, store := newTestDetector(t, map[string][2]float64{testVehicle: {restLat, restLng}})
+	ctx := context.Background()
+	start := time.Now().UTC()
+
+	u.evaluate(ctx, testVehicle, restLat, restLng, start)
+	u.evaluate(ctx, testVehicle, restLat, restLng, start.Add(20*time.Second))
+
+	if n := u.sweep(ctx, start.Add(5*time.Minute)); n != 1 {
+		t.Fatalf("sweep evaluated %d vehicles, want 1", n)
+	}
+	if len(store.opened) != 0 {
+		t.Fatalf("halt recorded after 5m, before minDuration")
+	}
+
+	u.sweep(ctx, start.Add(11*time.Minute))
+	if len(store.opened) != 1 {
+		t.Fatalf("halt not recorded after 11m without positions; opened = %v", store.opened)
+	}
+	if len(store.alerted) != 0 {
+		t.Fatalf("alert raised before alertAfter")
+	}
+
+	u.sweep(ctx, start.Add(31*time.Minute))
+	u.sweep(ctx, start.Add(61*time.Minute))
+	if len(store.alerted) != 1 || store.alerted[0] != domain.SeverityWarning {
+		t.Fatalf("alerts = %v, want one WARNING", store.alerted)
+	}
+	if h := u.halts[testVehicle]; h == nil || !h.since.Equal(start) || !h.lastSeen.Equal(start.Add(61*time.Minute)) {
+		t.Fatalf("halt = %+v, want it to run from the first position to the last sweep", h)
+	}
+}
+
+// Without a halt the sweep leaves the vehicle to the live watcher rather than
+// starting one at a position that may be a month old.
+func TestSweepSkipsVehiclesWithoutHalt(t *testing.T) {
+	u, store := newTestDetector(t, map[string][2]float64{testVehicle: {restLat, restLng}})
+
+	if n := u.sweep(context.Background(), time.Now().UTC()); n != 0 {
+		t.Fatalf("sweep evaluated %d vehicles, want 0", n)
+	}
+	if len(u.halts) != 0 || len(store.opened) != 0 {
+		t.Fatalf("sweep started a halt for a vehicle without one")
+	}
+}
+
+// Moving away from the rest position ends the recorded halt.
+func TestSweepClosesHaltWhenVehicleMoved(t *testing.T) {
+	lastKnown := map[string][2]float64{testVehicle: {restLat, restLng}}
+	u, store := newTestDetector(t, lastKnown)
+	ctx := context.Background()
+	start := time.Now().UTC()
+
+	u.evaluate(ctx, testVehicle, restLat, restLng, start)
+	u.sweep(ctx, start.Add(11*time.Minute))
+
+	lastKnown[testVehicle] = [2]float64{restLat + 0.01, restLng}
+	u.sweep(ctx, start.Add(12*time.Minute))
+	if len(store.closed) != 1 || store.closed[0] != 1 {
+		t.Fatalf("closed = %v, want halt 1", store.closed)
+	}
+}
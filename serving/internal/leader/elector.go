@@ -0,0 +1,250 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// Each background job has its own lease, so different jobs can lead on
+// different replicas:
+//
+//	leader:{job}        STRING "{instance}|{token}", PX ttl
+//	leader:{job}:fence  counter — the fencing token, +1 per acquisition
+//
+// A leader renews every ttl/3 and stops its job as soon as a renewal fails
+// or it has gone ttl/2 without one, so it always stops before another
+// replica can acquire. The token is also written to job_lease in Postgres,
+// where job writes check it (see Guard) — that covers a leader paused past
+// its lease, e.g. by a long GC or a frozen VM.
+
+var acquireScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then return 0 end
+local token = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], ARGV[1] .. '|' .. token, 'PX', ARGV[2])
+return token
+`)
+
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Status is one job's leadership as seen from this instance.
+type Status struct {
+	Job          string     `json:"job"`
+	Leader       string     `json:"leader"` // "" = vacant
+	FencingToken int64      `json:"fencing_token,omitempty"`
+	IsLocal      bool       `json:"is_local"`
+	Since        *time.Time `json:"since,omitempty"` // local leadership only
+}
+
+type Elector struct {
+	redis      *redis.Client
+	db         *pgxpool.Pool
+	instanceID string
+	ttl        time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*Status
+}
+
+func NewElector(redisClient *redis.Client, db *pgxpool.Pool, instanceID string, ttl time.Duration) *Elector {
+	if ttl <= 0 {
+		ttl = 15 * time.Second
+	}
+	return &Elector{
+		redis:      redisClient,
+		db:         db,
+		instanceID: instanceID,
+		ttl:        ttl,
+		jobs:       make(map[string]*Status),
+	}
+}
+
+func leaseKey(job string) string { return "leader:" + job }
+func fenceKey(job string) string { return "leader:" + job + ":fence" }
+
+// Run campaigns for job until ctx is done. While this instance leads, run is
+// called with a context that is cancelled the moment leadership is lost; run
+// must return promptly when it is.
+func (e *Elector) Run(ctx context.Context, job string, run func(ctx context.Context)) {
+	e.mu.Lock()
+	e.jobs[job] = &Status{Job: job}
+	e.mu.Unlock()
+
+	retry := time.NewTicker(e.ttl / 3)
+	defer retry.Stop()
+	for {
+		if token, err := e.acquire(ctx, job); err != nil {
+			log.Printf("leader: %s: acquire: %v", job, err)
+		} else if token > 0 {
+			e.lead(ctx, job, token, run)
+		}
+
+		select {
+		case <-retry.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *Elector) acquire(ctx context.Context, job string) (int64, error) {
+	token, err := acquireScript.Run(ctx, e.redis,
+		[]string{leaseKey(job), fenceKey(job)},
+		e.instanceID, e.ttl.Milliseconds(),
+	).Int64()
+	if err != nil || token == 0 {
+		return 0, err
+	}
+
+	// Record the token where job writes can check it. A newer token already
+	// there means this lease is stale on arrival.
+	tag, err := e.db.Exec(ctx, `
+		INSERT INTO job_lease (job, holder, fencing_token, acquired_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (job) DO UPDATE
+		SET holder = EXCLUDED.holder, fencing_token = EXCLUDED.fencing_token, acquired_at = NOW()
+		WHERE job_lease.fencing_token < EXCLUDED.fencing_token
+	`, job, e.instanceID, token)
+	if err != nil || tag.RowsAffected() == 0 {
+		e.release(job, token)
+		if err == nil {
+			err = fmt.Errorf("fencing token %d superseded", token)
+		}
+		return 0, err
+	}
+	return token, nil
+}
+
+// lead runs the job and renews the lease until either stops.
+func (e *Elector) lead(ctx context.Context, job string, token int64, run func(ctx context.Context)) {
+	log.Printf("leader: %s: acquired (token %d)", job, token)
+	e.setLocal(job, token, true)
+	defer e.setLocal(job, 0, false)
+
+	jobCtx, cancel := context.WithCancel(withFence(ctx, job, token))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(jobCtx)
+	}()
+
+	renew := time.NewTicker(e.ttl / 3)
+	defer renew.Stop()
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-renew.C:
+			ok, err := e.renew(ctx, job, token)
+			if ok {
+				lastRenew = time.Now()
+				continue
+			}
+			// A definite "not ours" ends leadership now; a Redis error only
+			// once the lease may have lapsed for others.
+			if err == nil || time.Since(lastRenew) >= e.ttl/2 {
+				log.Printf("leader: %s: lost lease (token %d): %v", job, token, err)
+				cancel()
+				<-done
+				return
+			}
+			log.Printf("leader: %s: renew failed, retrying: %v", job, err)
+
+		case <-done:
+			// The job returned on its own; give the lease up for others.
+			cancel()
+			e.release(job, token)
+			return
+
+		case <-ctx.Done():
+			cancel()
+			<-done
+			e.release(job, token)
+			return
+		}
+	}
+}
+
+func (e *Elector) renew(ctx context.Context, job string, token int64) (bool, error) {
+	n, err := renewScript.Run(ctx, e.redis, []string{leaseKey(job)},
+		e.holderValue(token), e.ttl.Milliseconds()).Int64()
+	return n == 1, err
+}
+
+// release deletes the lease if still held so a successor need not wait out
+// the TTL. Uses its own context: it runs during shutdown.
+func (e *Elector) release(job string, token int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	releaseScript.Run(ctx, e.redis, []string{leaseKey(job)}, e.holderValue(token))
+}
+
+func (e *Elector) holderValue(token int64) string {
+	return e.instanceID + "|" + strconv.FormatInt(token, 10)
+}
+
+func (e *Elector) setLocal(job string, token int64, leading bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := e.jobs[job]
+	st.IsLocal = leading
+	st.FencingToken = token
+	st.Since = nil
+	if leading {
+		now := time.Now().UTC()
+		st.Since = &now
+	}
+}
+
+// Leadership reports every job this instance campaigns for, with the current
+// leader read from Redis so it is correct across replicas.
+func (e *Elector) Leadership(ctx context.Context) ([]Status, error) {
+	e.mu.Lock()
+	out := make([]Status, 0, len(e.jobs))
+	for _, st := range e.jobs {
+		out = append(out, *st)
+	}
+	e.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Job < out[j].Job })
+
+	pipe := e.redis.Pipeline()
+	cmds := make([]*redis.StringCmd, len(out))
+	for i, st := range out {
+		cmds[i] = pipe.Get(ctx, leaseKey(st.Job))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return out, err
+	}
+	for i := range out {
+		holder, token, _ := strings.Cut(cmds[i].Val(), "|")
+		out[i].Leader = holder
+		if !out[i].IsLocal {
+			out[i].FencingToken, _ = strconv.ParseInt(token, 10, 64)
+		}
+	}
+	return out, nil
+}
+
+// InstanceID is this replica's identity in leases.
+func (e *Elector) InstanceID() string {
+	return e.instanceID
+}
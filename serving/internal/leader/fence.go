@@ -0,0 +1,37 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+)
+
+type fenceKeyType struct{}
+
+type fence struct {
+	job   string
+	token int64
+}
+
+func withFence(ctx context.Context, job string, token int64) context.Context {
+	return context.WithValue(ctx, fenceKeyType{}, fence{job: job, token: token})
+}
+
+// FenceFromContext returns the job and fencing token a leader's job context
+// was started with. ok is false outside an elected job.
+func FenceFromContext(ctx context.Context) (job string, token int64, ok bool) {
+	f, ok := ctx.Value(fenceKeyType{}).(fence)
+	return f.job, f.token, ok
+}
+
+// Guard returns a SQL condition that holds only while ctx's lease is still
+// the newest, plus its arguments numbered from $n. AND it into the WHERE of a
+// job's UPDATE or INSERT ... SELECT so a deposed leader's write matches no
+// rows. Outside an elected job the condition is TRUE.
+func Guard(ctx context.Context, n int) (string, []any) {
+	f, ok := ctx.Value(fenceKeyType{}).(fence)
+	if !ok {
+		return "TRUE", nil
+	}
+	cond := fmt.Sprintf("EXISTS (SELECT 1 FROM job_lease WHERE job = $%d AND fencing_token = $%d)", n, n+1)
+	return cond, []any{f.job, f.token}
+}
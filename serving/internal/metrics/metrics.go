@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "serving_http_request_duration_seconds",
+		Help:    "HTTP request latency by route pattern and status code.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"route", "method", "status"})
+
+	WSClients = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "serving_ws_clients",
+		Help: "WebSocket clients connected to this instance, by fleet.",
+	}, []string{"fleet_id"})
+
+	WSEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "serving_ws_slow_client_evictions_total",
+		Help: "WebSocket clients dropped because their send buffer was full.",
+	}, []string{"fleet_id"})
+
+	WSAuthRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "serving_ws_auth_rejections_total",
+		Help: "WebSocket connections refused or closed for failed authentication, by reason.",
+	}, []string{"reason"})
+
+	JobTickDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "serving_job_tick_duration_seconds",
+		Help:    "Background job tick latency.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"job"})
+
+	JobTickErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "serving_job_tick_errors_total",
+		Help: "Background job ticks that ended in an error.",
+	}, []string{"job"})
+
+	JobPositions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "serving_job_positions_evaluated_total",
+		Help: "Live positions evaluated by event-driven jobs, for vehicles on an active trip.",
+	}, []string{"job"})
+
+	AlertsFired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "serving_alerts_fired_total",
+		Help: "Alerts raised by serving-side jobs, by type and fleet.",
+	}, []string{"alert_type", "fleet_id"})
+)
+
+// TrackJobTick runs one tick of a background job and records its duration
+// and outcome.
+func TrackJobTick(job string, tick func() error) {
+	start := time.Now()
+	err := tick()
+	JobTickDuration.WithLabelValues(job).Observe(time.Since(start).Seconds())
+	if err != nil {
+		JobTickErrors.WithLabelValues(job).Inc()
+	}
+}
+
+// Instrument records HTTP request duration. It must wrap the ServeMux so that
+// r.Pattern has been filled in by the time the handler returns; requests that
+// match no route are reported as "unmatched".
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// A hijacked connection is a WebSocket session, and an event-stream
+		// response an SSE one — their lifetime is not a request latency.
+		if rec.hijacked || rec.Header().Get("Content-Type") == "text/event-stream" {
+			return
+		}
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequestDuration.
+			WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Hijack passes through to the underlying writer — gorilla/websocket
+// type-asserts http.Hijacker to perform the upgrade.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("metrics: underlying ResponseWriter does not support hijacking")
+	}
+	s.hijacked = true
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to extend
+// write deadlines on long-lived SSE streams.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+var exposition = promhttp.Handler()
+
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	exposition.ServeHTTP(w, r)
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
+
+	"fleet-monitor/serving/internal/oidc"
 )
 
 type contextKey int
@@ -12,6 +16,28 @@ const (
 	contextKeyAPIKey contextKey = iota
 
 	contextKeyFleetID
+	contextKeyPrincipal
+)
+
+// Principal is whoever made the request — an operator with a bearer token, or
+// a caller using an API key.
+type Principal struct {
+	Subject string
+	Name    string
+	Role    oidc.Role
+	FleetID string // empty = every fleet
+	Method  string // "token" | "api_key"
+
+	ExpiresAt time.Time // token expiry; zero for API keys
+}
+
+// API-key callers have no operator identity and are read-only. Static config
+// keys are shared service accounts, so they read every fleet but never reach
+// dispatcher, key-management or platform routes; those need an operator
+// token. Fleet keys read their own fleet.
+const (
+	staticKeySubject = "service:static-key"
+	fleetKeySubject  = "service:fleet-key"
 )
 
 type Validator interface {
@@ -29,35 +55,90 @@ func writeError(w http.ResponseWriter, status int, msg string) {
 	json.NewEncoder(w).Encode(errorResponse{Error: msg})
 }
 
-func Auth(v Validator) func(http.Handler) http.Handler {
+// Auth accepts an operator bearer token or an X-API-Key. tokens may be nil
+// when no OIDC issuer is configured, in which case only API keys work.
+func Auth(v Validator, tokens *oidc.Verifier) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			apiKey := r.Header.Get("X-API-Key")
-			if apiKey == "" {
-				writeError(w, http.StatusUnauthorized, "missing X-API-Key header")
-				return
+			var p Principal
+			ctx := r.Context()
+
+			if raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				if tokens == nil {
+					writeError(w, http.StatusUnauthorized, "bearer tokens are not enabled")
+					return
+				}
+				claims, err := tokens.Verify(ctx, raw)
+				if err != nil {
+					writeError(w, http.StatusUnauthorized, "invalid bearer token")
+					return
+				}
+				p = Principal{
+					Subject: claims.Subject,
+					Name:    claims.Name,
+					Role:    claims.Role,
+					FleetID: claims.FleetID,
+					Method:  "token",
+
+					ExpiresAt: claims.ExpiresAt.Time,
+				}
+				if p.Role == oidc.RoleSuperAdmin {
+					p.FleetID = ""
+				}
+			} else {
+				apiKey := r.Header.Get("X-API-Key")
+				if apiKey == "" {
+					writeError(w, http.StatusUnauthorized, "missing bearer token or X-API-Key header")
+					return
+				}
+
+				fleetID, ok := v.FleetID(ctx, apiKey)
+				if !ok {
+					writeError(w, http.StatusUnauthorized, "invalid API key")
+					return
+				}
+				p = Principal{Subject: fleetKeySubject, Role: oidc.RoleViewer, FleetID: fleetID, Method: "api_key"}
+				if fleetID == "" {
+					p = Principal{Subject: staticKeySubject, Role: oidc.RoleViewer, Method: "api_key"}
+				}
+				ctx = context.WithValue(ctx, contextKeyAPIKey, apiKey)
 			}
 
-			fleetID, ok := v.FleetID(r.Context(), apiKey)
-			if !ok {
-				writeError(w, http.StatusUnauthorized, "invalid API key")
+			ctx = context.WithValue(ctx, contextKeyFleetID, p.FleetID)
+			ctx = context.WithValue(ctx, contextKeyPrincipal, p)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireRole rejects principals below min. Must run behind Auth.
+func RequireRole(min oidc.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !PrincipalFromContext(r.Context()).Role.AtLeast(min) {
+				writeError(w, http.StatusForbidden, "requires role "+string(min))
 				return
 			}
-
-			ctx := context.WithValue(r.Context(), contextKeyAPIKey, apiKey)
-			ctx = context.WithValue(ctx, contextKeyFleetID, fleetID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// FleetScoped restricts a route to callers in the fleet it names. A route
+// whose pattern has the parameter is scoped by the path alone — the handler
+// reads PathValue, so a ?fleet_id= that disagrees is rejected rather than
+// checked in its place. Routes without it take the query parameter.
 func FleetScoped(fleetIDParam string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			requestedFleet := r.URL.Query().Get(fleetIDParam)
-			if requestedFleet == "" {
-				// Path value fallback (Go 1.22+).
-				requestedFleet = r.PathValue(fleetIDParam)
+			requestedFleet := r.PathValue(fleetIDParam)
+			queryFleet := r.URL.Query().Get(fleetIDParam)
+			switch {
+			case requestedFleet == "":
+				requestedFleet = queryFleet
+			case queryFleet != "" && queryFleet != requestedFleet:
+				writeError(w, http.StatusBadRequest, fleetIDParam+" in the query does not match the path")
+				return
 			}
 
 			if requestedFleet == "" {
@@ -67,9 +148,10 @@ func FleetScoped(fleetIDParam string) func(http.Handler) http.Handler {
 
 			keyFleet := FleetIDFromContext(r.Context())
 
+			// 404, not 403 — other fleets' IDs should be indistinguishable
+			// from fleets that do not exist.
 			if keyFleet != "" && keyFleet != requestedFleet {
-				writeError(w, http.StatusForbidden,
-					"API key is not authorised for fleet "+requestedFleet)
+				writeError(w, http.StatusNotFound, "fleet not found")
 				return
 			}
 
@@ -87,3 +169,8 @@ func FleetIDFromContext(ctx context.Context) string {
 	v, _ := ctx.Value(contextKeyFleetID).(string)
 	return v
 }
+
+func PrincipalFromContext(ctx context.Context) Principal {
+	p, _ := ctx.Value(contextKeyPrincipal).(Principal)
+	return p
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+
+	"fleet-monitor/serving/internal/oidc"
+)
+
+// Scope declares how a route enforces the fleet boundary. Every
+// authenticated route must name one, and must account for each path
+// parameter in its pattern — NewRouter's Handle refuses to start the server
+// otherwise, so a new /vehicles/{vehicle_id}/... route cannot ship unscoped.
+type Scope struct {
+	name    string
+	params  []string
+	minRole oidc.Role
+	wrap    func(http.Handler) http.Handler
+}
+
+// FleetParam — the route names a fleet in its path or query.
+func FleetParam(param string) Scope {
+	return Scope{name: "fleet", params: []string{param}, wrap: FleetScoped(param)}
+}
+
+// OwnedBy — the route names a fleet-owned resource in its path.
+func OwnedBy(res OwnerResolver, kind, param string) Scope {
+	return Scope{name: "owned:" + kind, params: []string{param}, wrap: Owned(res, kind, param)}
+}
+
+// CallerFleetFilter — a list endpoint filtered to the caller's fleet.
+func CallerFleetFilter(param string) Scope {
+	return Scope{name: "caller_fleet", wrap: CallerFleet(param)}
+}
+
+// HandlerScoped — the handler checks the fleet itself, e.g. one named in the
+// request body. reason is recorded in the route table.
+func HandlerScoped(reason string) Scope {
+	return Scope{name: "handler:" + reason}
+}
+
+// Self — the route only ever describes the caller.
+func Self() Scope {
+	return Scope{name: "self"}
+}
+
+// Platform — cross-fleet data, restricted to super_admin. params names path
+// parameters for platform resources that belong to no fleet, e.g. a job.
+func Platform(params ...string) Scope {
+	return Scope{name: "platform", params: params, minRole: oidc.RoleSuperAdmin}
+}
+
+// RouteInfo is one registered route and its access policy.
+type RouteInfo struct {
+	Pattern string
+	Role    oidc.Role // empty for public routes
+	Scope   string
+}
+
+// Router registers routes on a ServeMux with their auth, role and fleet
+// scope applied in a fixed order: Auth → RequireRole → scope → handler.
+type Router struct {
+	mux    *http.ServeMux
+	auth   func(http.Handler) http.Handler
+	routes []RouteInfo
+}
+
+func NewRouter(mux *http.ServeMux, auth func(http.Handler) http.Handler) *Router {
+	return &Router{mux: mux, auth: auth}
+}
+
+var pathParam = regexp.MustCompile(`\{([a-z_]+)\.{0,3}\}`)
+
+// Public registers an unauthenticated route. Public routes cannot address
+// resources by path parameter.
+func (rt *Router) Public(pattern string, h http.HandlerFunc) {
+	if m := pathParam.FindStringSubmatch(pattern); m != nil {
+		log.Fatalf("route %q: public routes cannot take path parameter {%s}", pattern, m[1])
+	}
+	rt.mux.HandleFunc(pattern, h)
+	rt.routes = append(rt.routes, RouteInfo{Pattern: pattern, Scope: "public"})
+}
+
+// Handle registers an authenticated route. It exits the process if the route
+// policy is incomplete.
+func (rt *Router) Handle(pattern string, role oidc.Role, scope Scope, h http.HandlerFunc) {
+	if !role.Valid() {
+		log.Fatalf("route %q: unknown role %q", pattern, role)
+	}
+	if scope.name == "" {
+		log.Fatalf("route %q: no fleet scope declared", pattern)
+	}
+	if scope.minRole != "" && !role.AtLeast(scope.minRole) {
+		log.Fatalf("route %q: %s scope requires role %s, got %s", pattern, scope.name, scope.minRole, role)
+	}
+	for _, m := range pathParam.FindAllStringSubmatch(pattern, -1) {
+		if !contains(scope.params, m[1]) {
+			log.Fatalf("route %q: path parameter {%s} is not covered by its %s scope", pattern, m[1], scope.name)
+		}
+	}
+
+	var next http.Handler = h
+	if scope.wrap != nil {
+		next = scope.wrap(next)
+	}
+	next = RequireRole(role)(next)
+	rt.mux.Handle(pattern, rt.auth(next))
+	rt.routes = append(rt.routes, RouteInfo{Pattern: pattern, Role: role, Scope: scope.name})
+}
+
+func (rt *Router) Routes() []RouteInfo {
+	return rt.routes
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package oidc
+
+import "github.com/golang-jwt/jwt/v5"
+
+// Role is an operator's permission level. Each role includes everything the
+// roles below it can do.
+type Role string
+
+const (
+	RoleViewer     Role = "viewer"      // read dashboards and history
+	RoleDispatcher Role = "dispatcher"  // + acknowledge and resolve alerts
+	RoleFleetAdmin Role = "fleet_admin" // + manage the fleet's device keys
+	RoleSuperAdmin Role = "super_admin" // + every fleet, storage and platform admin
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:     1,
+	RoleDispatcher: 2,
+	RoleFleetAdmin: 3,
+	RoleSuperAdmin: 4,
+}
+
+func (r Role) Valid() bool {
+	return roleRank[r] > 0
+}
+
+// AtLeast reports whether r grants everything min does.
+func (r Role) AtLeast(min Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[min]
+}
+
+// Claims are the token claims the serving layer relies on. role and fleet_id
+// are custom claims; an external identity provider must be configured to emit
+// them.
+type Claims struct {
+	jwt.RegisteredClaims
+	Name    string `json:"name,omitempty"`
+	Role    Role   `json:"role"`
+	FleetID string `json:"fleet_id,omitempty"` // empty only for super_admin
+}
@@ -0,0 +1,164 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRefreshInterval stops a flood of tokens with unknown kids from turning
+// into a flood of JWKS fetches.
+const minRefreshInterval = time.Minute
+
+// RemoteKeys fetches signing keys from an external issuer via OIDC discovery
+// and refreshes them when a token arrives with an unknown kid.
+type RemoteKeys struct {
+	issuer string
+	client *http.Client
+
+	mu          sync.RWMutex
+	jwksURI     string
+	keys        map[string]*rsa.PublicKey
+	lastRefresh time.Time
+}
+
+func NewRemoteKeys(issuer string) *RemoteKeys {
+	return &RemoteKeys{
+		issuer: strings.TrimSuffix(issuer, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+func (k *RemoteKeys) PublicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(k.lastRefresh) < minRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if err := k.refresh(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// refresh must be called with mu held.
+func (k *RemoteKeys) refresh(ctx context.Context) error {
+	k.lastRefresh = time.Now()
+
+	if k.jwksURI == "" {
+		var doc discoveryDocument
+		if err := k.getJSON(ctx, k.issuer+"/.well-known/openid-configuration", &doc); err != nil {
+			return fmt.Errorf("oidc discovery: %w", err)
+		}
+		if doc.JWKSURI == "" {
+			return fmt.Errorf("oidc discovery: no jwks_uri")
+		}
+		k.jwksURI = doc.JWKSURI
+	}
+
+	var set jwkSet
+	if err := k.getJSON(ctx, k.jwksURI, &set); err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		pub, err := jwk.rsaPublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = pub
+	}
+	k.keys = keys
+	return nil
+}
+
+func (k *RemoteKeys) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// ── Wire types ────────────────────────────────────────────────────────────────
+
+type discoveryDocument struct {
+	Issuer                string   `json:"issuer"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenEndpoint         string   `json:"token_endpoint,omitempty"`
+	GrantTypesSupported   []string `json:"grant_types_supported,omitempty"`
+	SigningAlgsSupported  []string `json:"id_token_signing_alg_values_supported"`
+	SubjectTypesSupported []string `json:"subject_types_supported"`
+	ClaimsSupported       []string `json:"claims_supported,omitempty"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (j jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(j.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(j.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func jwkFromPublicKey(kid string, pub *rsa.PublicKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
@@ -0,0 +1,301 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type LocalConfig struct {
+	Issuer         string // public base URL, e.g. http://localhost:8002
+	Audience       string
+	SigningKeyFile string // PEM RSA private key, shared by every replica
+	EphemeralKey   bool   // no key file: generate one per process — single replica only
+	TokenTTL       time.Duration
+
+	// Password grant throttling. A username is locked for LockoutPeriod after
+	// MaxLoginFailures failed attempts in a row; a client address may make
+	// LoginsPerMinute attempts. Zero disables either check.
+	MaxLoginFailures int
+	LockoutPeriod    time.Duration
+	LoginsPerMinute  int
+}
+
+// LocalIssuer is a minimal OIDC provider for development and single-tenant
+// installs. It serves discovery and JWKS so external tools can verify its
+// tokens, and issues them from operator_account via the password grant.
+type LocalIssuer struct {
+	cfg   LocalConfig
+	key   *rsa.PrivateKey
+	kid   string
+	db    *pgxpool.Pool
+	redis *redis.Client
+}
+
+// Login throttling state:
+//
+//	oidc:login:fail:{username}  STRING  consecutive failures, TTL = lockout
+//	oidc:login:addr:{address}   STRING  attempts this minute
+const (
+	loginFailPrefix = "oidc:login:fail:"
+	loginAddrPrefix = "oidc:login:addr:"
+)
+
+func NewLocalIssuer(cfg LocalConfig, db *pgxpool.Pool, rc *redis.Client) (*LocalIssuer, error) {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+
+	var key *rsa.PrivateKey
+	switch {
+	case cfg.SigningKeyFile != "":
+		pemBytes, err := os.ReadFile(cfg.SigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read signing key: %w", err)
+		}
+		if key, err = parseRSAPrivateKey(pemBytes); err != nil {
+			return nil, err
+		}
+	case cfg.EphemeralKey:
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			return nil, fmt.Errorf("generate signing key: %w", err)
+		}
+		log.Println("oidc: using an ephemeral signing key — tokens will not survive a restart or verify on another replica")
+	default:
+		// Each replica verifies tokens with its own key; per-process keys
+		// would reject every token issued by another replica.
+		return nil, errors.New("local issuer requires a signing key file shared by every replica " +
+			"(or an ephemeral key, for a single replica only)")
+	}
+
+	sum := sha256.Sum256(key.PublicKey.N.Bytes())
+	return &LocalIssuer{
+		cfg:   cfg,
+		key:   key,
+		kid:   hex.EncodeToString(sum[:8]),
+		db:    db,
+		redis: rc,
+	}, nil
+}
+
+// PublicKey implements KeySource, so tokens from this issuer are verified
+// without a network round-trip.
+func (l *LocalIssuer) PublicKey(_ context.Context, kid string) (*rsa.PublicKey, error) {
+	if kid != l.kid {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return &l.key.PublicKey, nil
+}
+
+// GET /.well-known/openid-configuration
+func (l *LocalIssuer) HandleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, discoveryDocument{
+		Issuer:                l.cfg.Issuer,
+		JWKSURI:               l.cfg.Issuer + "/oauth/jwks",
+		TokenEndpoint:         l.cfg.Issuer + "/oauth/token",
+		GrantTypesSupported:   []string{"password"},
+		SigningAlgsSupported:  []string{"RS256"},
+		SubjectTypesSupported: []string{"public"},
+		ClaimsSupported:       []string{"sub", "name", "role", "fleet_id"},
+	})
+}
+
+// GET /oauth/jwks
+func (l *LocalIssuer) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jwkSet{Keys: []jwk{jwkFromPublicKey(l.kid, &l.key.PublicKey)}})
+}
+
+// POST /oauth/token — grant_type=password&username=...&password=...
+// Errors follow RFC 6749 §5.2.
+func (l *LocalIssuer) HandleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "password" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	if wait := l.throttled(r.Context(), username, clientAddress(r)); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{
+			"error":             "invalid_request",
+			"error_description": "too many login attempts, try again later",
+		})
+		return
+	}
+
+	claims, err := l.authenticate(r.Context(), username, r.PostForm.Get("password"))
+	if errors.Is(err, errInvalidCredentials) {
+		l.recordFailure(r.Context(), username)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"error":             "invalid_grant",
+			"error_description": "invalid username or password",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("oidc: token request failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	l.redis.Del(r.Context(), loginFailPrefix+username)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = l.kid
+	signed, err := token.SignedString(l.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": signed,
+		"id_token":     signed,
+		"token_type":   "Bearer",
+		"expires_in":   int(l.cfg.TokenTTL.Seconds()),
+	})
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// throttled returns how long the caller must wait, or 0 to proceed. It
+// counts this attempt against the address. Redis errors let the attempt
+// through: the password check still applies.
+func (l *LocalIssuer) throttled(ctx context.Context, username, addr string) time.Duration {
+	if l.cfg.MaxLoginFailures > 0 && username != "" {
+		key := loginFailPrefix + username
+		if n, err := l.redis.Get(ctx, key).Int(); err == nil && n >= l.cfg.MaxLoginFailures {
+			if ttl, err := l.redis.TTL(ctx, key).Result(); err == nil && ttl > 0 {
+				return ttl
+			}
+		}
+	}
+	if l.cfg.LoginsPerMinute > 0 {
+		key := loginAddrPrefix + addr
+		pipe := l.redis.TxPipeline()
+		incr := pipe.Incr(ctx, key)
+		pipe.ExpireNX(ctx, key, time.Minute)
+		ttl := pipe.TTL(ctx, key)
+		if _, err := pipe.Exec(ctx); err == nil && incr.Val() > int64(l.cfg.LoginsPerMinute) {
+			return max(ttl.Val(), time.Second)
+		}
+	}
+	return 0
+}
+
+// recordFailure counts a failed password against the username; the count
+// lapses LockoutPeriod after the last failure.
+func (l *LocalIssuer) recordFailure(ctx context.Context, username string) {
+	if l.cfg.MaxLoginFailures <= 0 || username == "" {
+		return
+	}
+	key := loginFailPrefix + username
+	pipe := l.redis.TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, l.cfg.LockoutPeriod)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("oidc: record login failure: %v", err)
+	}
+}
+
+// clientAddress is the connection's remote host. Forwarding headers are
+// not trusted — a client could set them to dodge the limit.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (l *LocalIssuer) authenticate(ctx context.Context, username, password string) (*Claims, error) {
+	if username == "" || password == "" {
+		return nil, errInvalidCredentials
+	}
+
+	var (
+		subject, name, role, hash string
+		fleetID                   *string
+	)
+	err := l.db.QueryRow(ctx, `
+		SELECT subject, display_name, role, fleet_id, password_hash
+		FROM operator_account
+		WHERE username = $1 AND active
+	`, username).Scan(&subject, &name, &role, &fleetID, &hash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("look up operator: %w", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, errInvalidCredentials
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    l.cfg.Issuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{l.cfg.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(l.cfg.TokenTTL)),
+		},
+		Name: name,
+		Role: Role(role),
+	}
+	if fleetID != nil {
+		claims.FleetID = *fleetID
+	}
+	return claims, nil
+}
+
+func parseRSAPrivateKey(pemBytes []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("signing key: no PEM block")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key: not an RSA key")
+	}
+	return key, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,61 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownRole  = errors.New("token role is not recognised")
+	ErrMissingFleet = errors.New("token has no fleet_id claim")
+	ErrNoSubject    = errors.New("token has no subject")
+)
+
+// KeySource resolves the public key a token was signed with.
+type KeySource interface {
+	PublicKey(ctx context.Context, kid string) (*rsa.PublicKey, error)
+}
+
+// Verifier validates RS256 operator tokens from a single issuer.
+type Verifier struct {
+	issuer   string
+	audience string
+	keys     KeySource
+}
+
+func NewVerifier(issuer, audience string, keys KeySource) *Verifier {
+	return &Verifier{issuer: strings.TrimSuffix(issuer, "/"), audience: audience, keys: keys}
+}
+
+func (v *Verifier) Verify(ctx context.Context, raw string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(raw, claims,
+		func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			return v.keys.PublicKey(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("verify token: %w", err)
+	}
+
+	if claims.Subject == "" {
+		return nil, ErrNoSubject
+	}
+	if !claims.Role.Valid() {
+		return nil, ErrUnknownRole
+	}
+	if claims.Role != RoleSuperAdmin && claims.FleetID == "" {
+		return nil, ErrMissingFleet
+	}
+	return claims, nil
+}
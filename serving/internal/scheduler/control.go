@@ -0,0 +1,251 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// JobStatus is one job as the admin API reports it.
+type JobStatus struct {
+	Name            string     `json:"name"`
+	IntervalSeconds float64    `json:"interval_seconds"`
+	TimeoutSeconds  float64    `json:"timeout_seconds"`
+	Live            bool       `json:"live"` // also reacts to live events
+	Paused          bool       `json:"paused"`
+	Leader          string     `json:"leader"` // "" = no replica holds the lease
+	Running         bool       `json:"running"`
+	NextRunAt       *time.Time `json:"next_run_at"` // known only on the leader
+	LastRun         *Run       `json:"last_run"`
+}
+
+// Run is one row of job_runs.
+type Run struct {
+	RunID      int64     `json:"run_id"`
+	Job        string    `json:"job"`
+	InstanceID string    `json:"instance_id"`
+	Trigger    string    `json:"trigger"` // schedule | manual
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int       `json:"duration_ms"`
+	Items      int       `json:"items"`
+	Error      *string   `json:"error"`
+}
+
+// watchControl applies operator actions published by any replica.
+func (s *Scheduler) watchControl(ctx context.Context) {
+	sub := s.redis.Subscribe(ctx, controlChannel)
+	defer sub.Close()
+	for {
+		select {
+		case msg, ok := <-sub.Channel():
+			if !ok {
+				return
+			}
+			action, name, _ := strings.Cut(msg.Payload, "|")
+			e, ok := s.jobs[name]
+			if !ok {
+				continue
+			}
+			switch action {
+			case "pause":
+				e.paused.Store(true)
+			case "resume":
+				e.paused.Store(false)
+			}
+			// Only the leading loop acts; elsewhere a queued action would
+			// replay on the next leadership term.
+			if e.leading.Load() {
+				select {
+				case e.control <- action:
+				default:
+					log.Printf("scheduler: %s: dropped %s, control queue full", name, action)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Scheduler) publish(ctx context.Context, action, name string) error {
+	return s.redis.Publish(ctx, controlChannel, action+"|"+name).Err()
+}
+
+// Pause stops scheduled passes and any live watcher on every replica until
+// Resume. A manual trigger still runs a paused job.
+func (s *Scheduler) Pause(ctx context.Context, name string) error {
+	if _, ok := s.jobs[name]; !ok {
+		return ErrUnknownJob
+	}
+	if err := s.redis.SAdd(ctx, pausedKey, name).Err(); err != nil {
+		return err
+	}
+	return s.publish(ctx, "pause", name)
+}
+
+func (s *Scheduler) Resume(ctx context.Context, name string) error {
+	if _, ok := s.jobs[name]; !ok {
+		return ErrUnknownJob
+	}
+	if err := s.redis.SRem(ctx, pausedKey, name).Err(); err != nil {
+		return err
+	}
+	return s.publish(ctx, "resume", name)
+}
+
+// Trigger asks the job's leader to run one pass now, and returns that
+// leader. An empty leader means no replica holds the lease, so nothing runs.
+func (s *Scheduler) Trigger(ctx context.Context, name string) (string, error) {
+	if _, ok := s.jobs[name]; !ok {
+		return "", ErrUnknownJob
+	}
+	holder, err := s.leaderOf(ctx, name)
+	if err != nil || holder == "" {
+		return "", err
+	}
+	return holder, s.publish(ctx, "trigger", name)
+}
+
+func (s *Scheduler) leaderOf(ctx context.Context, name string) (string, error) {
+	statuses, err := s.elector.Leadership(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, st := range statuses {
+		if st.Job == name {
+			return st.Leader, nil
+		}
+	}
+	return "", nil
+}
+
+// Jobs reports every registered job with its leader and latest run.
+func (s *Scheduler) Jobs(ctx context.Context) ([]JobStatus, error) {
+	paused, err := s.redis.SMembers(ctx, pausedKey).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	pausedSet := make(map[string]bool, len(paused))
+	for _, name := range paused {
+		pausedSet[name] = true
+	}
+
+	leaders := make(map[string]string)
+	statuses, err := s.elector.Leadership(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range statuses {
+		leaders[st.Job] = st.Leader
+	}
+
+	lastRuns, err := s.lastRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]JobStatus, 0, len(s.order))
+	for _, name := range s.order {
+		e := s.jobs[name]
+		st := JobStatus{
+			Name:            name,
+			IntervalSeconds: e.job.Interval.Seconds(),
+			TimeoutSeconds:  e.job.Timeout.Seconds(),
+			Live:            e.job.Watch != nil,
+			Paused:          pausedSet[name],
+			Leader:          leaders[name],
+			LastRun:         lastRuns[name],
+		}
+		e.mu.Lock()
+		st.Running = e.running
+		if !e.nextRun.IsZero() {
+			next := e.nextRun
+			st.NextRunAt = &next
+		}
+		e.mu.Unlock()
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// ── job_runs ──────────────────────────────────────────────────────────────────
+
+func (s *Scheduler) record(name, trigger string, started time.Time, took time.Duration, items int, runErr error) {
+	// Runs cut short by shutdown are still recorded.
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	var errText *string
+	if runErr != nil {
+		msg := runErr.Error()
+		errText = &msg
+	}
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO job_runs (job, instance_id, trigger, started_at, duration_ms, items, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, name, s.elector.InstanceID(), trigger, started.UTC(), took.Milliseconds(), items, errText)
+	if err != nil {
+		log.Printf("scheduler: record %s run: %v", name, err)
+	}
+}
+
+func (s *Scheduler) lastRuns(ctx context.Context) (map[string]*Run, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT DISTINCT ON (job)
+		       run_id, job, instance_id, trigger, started_at, duration_ms, items, error
+		FROM job_runs
+		WHERE job = ANY($1)
+		ORDER BY job, started_at DESC
+	`, s.order)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]*Run)
+	for rows.Next() {
+		var r Run
+		if err := rows.Scan(&r.RunID, &r.Job, &r.InstanceID, &r.Trigger, &r.StartedAt, &r.DurationMs, &r.Items, &r.Error); err != nil {
+			return nil, err
+		}
+		out[r.Job] = &r
+	}
+	return out, rows.Err()
+}
+
+// Runs returns a job's most recent runs, newest first.
+func (s *Scheduler) Runs(ctx context.Context, name string, limit int) ([]Run, error) {
+	if _, ok := s.jobs[name]; !ok {
+		return nil, ErrUnknownJob
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT run_id, job, instance_id, trigger, started_at, duration_ms, items, error
+		FROM job_runs
+		WHERE job = $1
+		ORDER BY started_at DESC
+		LIMIT $2
+	`, name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := []Run{}
+	for rows.Next() {
+		var r Run
+		if err := rows.Scan(&r.RunID, &r.Job, &r.InstanceID, &r.Trigger, &r.StartedAt, &r.DurationMs, &r.Items, &r.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+func (s *Scheduler) pruneHistory(ctx context.Context, keep time.Duration) (int, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM job_runs WHERE started_at < $1`, time.Now().Add(-keep))
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
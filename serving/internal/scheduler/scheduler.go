@@ -0,0 +1,279 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+
+	"fleet-monitor/serving/internal/leader"
+	"fleet-monitor/serving/internal/metrics"
+)
+
+// Every replica registers the same jobs; each job runs only on the replica
+// holding its lease (see package leader). Operator actions go through Redis
+// so they reach whichever replica leads:
+//
+//	jobs:paused    SET     names of paused jobs — survives failover
+//	jobs:control   pub/sub "{action}|{job}", action = pause | resume | trigger
+const (
+	pausedKey      = "jobs:paused"
+	controlChannel = "jobs:control"
+
+	// jitterFraction spreads runs by up to ±10% of the interval so replicas
+	// restarted together do not hit the database in lockstep.
+	jitterFraction = 0.1
+	recordTimeout  = 2 * time.Second
+)
+
+var ErrUnknownJob = errors.New("unknown job")
+
+// Job is one unit of background work, run every Interval on the elected
+// replica.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Timeout bounds a single run; zero means Interval.
+	Timeout time.Duration
+	// Run does one pass and reports how many items it processed. Its context
+	// is cancelled at Timeout or when leadership is lost.
+	Run func(ctx context.Context) (items int, err error)
+	// Watch, if set, runs alongside the scheduled passes for as long as this
+	// replica leads and the job is not paused — for jobs that also react to
+	// live events. It runs on its own goroutine, concurrently with Run.
+	Watch func(ctx context.Context)
+}
+
+type entry struct {
+	job     Job
+	paused  atomic.Bool
+	leading atomic.Bool
+	control chan string // pause | resume | trigger, for the leading loop
+
+	mu      sync.Mutex
+	running bool
+	nextRun time.Time
+}
+
+type Scheduler struct {
+	redis   *redis.Client
+	db      *pgxpool.Pool
+	elector *leader.Elector
+
+	jobs  map[string]*entry
+	order []string
+}
+
+// New returns a scheduler with its own history-pruning job registered;
+// job_runs rows older than historyDays are deleted hourly.
+func New(redisClient *redis.Client, db *pgxpool.Pool, elector *leader.Elector, historyDays int) *Scheduler {
+	s := &Scheduler{
+		redis:   redisClient,
+		db:      db,
+		elector: elector,
+		jobs:    make(map[string]*entry),
+	}
+	s.Register(Job{
+		Name:     "job-history",
+		Interval: time.Hour,
+		Timeout:  time.Minute,
+		Run: func(ctx context.Context) (int, error) {
+			return s.pruneHistory(ctx, time.Duration(historyDays)*24*time.Hour)
+		},
+	})
+	return s
+}
+
+// Register adds a job. It must be called before Run.
+func (s *Scheduler) Register(job Job) {
+	if _, dup := s.jobs[job.Name]; dup {
+		log.Fatalf("scheduler: job %q registered twice", job.Name)
+	}
+	if job.Interval <= 0 || job.Run == nil {
+		log.Fatalf("scheduler: job %q needs an interval and a Run func", job.Name)
+	}
+	if job.Timeout <= 0 {
+		job.Timeout = job.Interval
+	}
+	s.jobs[job.Name] = &entry{job: job, control: make(chan string, 4)}
+	s.order = append(s.order, job.Name)
+}
+
+// Run campaigns for every registered job and dispatches operator actions
+// until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	if paused, err := s.redis.SMembers(ctx, pausedKey).Result(); err == nil {
+		for _, name := range paused {
+			if e, ok := s.jobs[name]; ok {
+				e.paused.Store(true)
+			}
+		}
+	}
+
+	for _, name := range s.order {
+		e := s.jobs[name]
+		go s.elector.Run(ctx, name, func(lctx context.Context) { s.lead(lctx, e) })
+	}
+	s.watchControl(ctx)
+}
+
+// lead runs one job for as long as this replica holds its lease.
+func (s *Scheduler) lead(ctx context.Context, e *entry) {
+	e.leading.Store(true)
+	defer e.leading.Store(false)
+
+	// Pause state may have changed while another replica led.
+	if paused, err := s.redis.SIsMember(ctx, pausedKey, e.job.Name).Result(); err == nil {
+		e.paused.Store(paused)
+	}
+
+	var watch *watcher
+	startWatch := func() {
+		if e.job.Watch != nil && (watch == nil || watch.exited()) {
+			watch = startWatcher(ctx, e.job)
+		}
+	}
+	stopWatch := func() {
+		if watch != nil {
+			watch.stop()
+			watch = nil
+		}
+	}
+	defer stopWatch()
+	if !e.paused.Load() {
+		startWatch()
+	}
+
+	// The first pass follows leadership closely: a new leader catches up on
+	// whatever its predecessor missed.
+	timer := time.NewTimer(s.schedule(e, time.Duration(rand.Float64()*jitterFraction*float64(e.job.Interval))))
+	defer timer.Stop()
+	defer s.schedule(e, 0)
+
+	for {
+		select {
+		case <-timer.C:
+			if !e.paused.Load() {
+				s.execute(ctx, e, "schedule")
+			}
+			timer.Reset(s.schedule(e, jitter(e.job.Interval)))
+
+		case action := <-e.control:
+			switch action {
+			case "trigger":
+				s.execute(ctx, e, "manual")
+			case "pause":
+				stopWatch()
+			case "resume":
+				startWatch()
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// schedule records when the next scheduled pass is due; 0 clears it.
+func (s *Scheduler) schedule(e *entry, in time.Duration) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextRun = time.Time{}
+	if in > 0 {
+		e.nextRun = time.Now().Add(in).UTC()
+	}
+	return in
+}
+
+func jitter(d time.Duration) time.Duration {
+	spread := (rand.Float64()*2 - 1) * jitterFraction * float64(d)
+	return d + time.Duration(spread)
+}
+
+// execute runs one pass, isolating panics and recording it in job_runs.
+func (s *Scheduler) execute(ctx context.Context, e *entry, trigger string) {
+	e.mu.Lock()
+	e.running = true
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.running = false
+		e.mu.Unlock()
+	}()
+
+	started := time.Now()
+	var items int
+	var runErr error
+	metrics.TrackJobTick(e.job.Name, func() error {
+		items, runErr = runSafely(ctx, e.job)
+		return runErr
+	})
+	if runErr != nil {
+		log.Printf("scheduler: %s (%s): %v", e.job.Name, trigger, runErr)
+	}
+	s.record(e.job.Name, trigger, started, time.Since(started), items, runErr)
+}
+
+func runSafely(ctx context.Context, job Job) (items int, err error) {
+	rctx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("scheduler: %s panicked: %v\n%s", job.Name, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	items, err = job.Run(rctx)
+	if err == nil && errors.Is(rctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", job.Timeout)
+	}
+	return items, err
+}
+
+// watcher is a running Watch func.
+type watcher struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func startWatcher(ctx context.Context, job Job) *watcher {
+	wctx, cancel := context.WithCancel(ctx)
+	w := &watcher{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		defer func() {
+			// A crashed watcher leaves the scheduled passes running; it is
+			// restarted on resume or the next leadership term.
+			if r := recover(); r != nil {
+				log.Printf("scheduler: %s watcher panicked: %v\n%s", job.Name, r, debug.Stack())
+			}
+		}()
+		job.Watch(wctx)
+	}()
+	return w
+}
+
+func (w *watcher) exited() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop cancels the watcher and waits for it, so no write of an old
+// leadership term outlives it.
+func (w *watcher) stop() {
+	w.cancel()
+	<-w.done
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// ownerCacheTTL — resources practically never change fleet, but a vehicle
+// moved between fleets should not stay visible to the old one for long.
+const ownerCacheTTL = 5 * time.Minute
+
+type ownerEntry struct {
+	fleetID   string
+	expiresAt time.Time
+}
+
+// Ownership resolves which fleet owns a vehicle, alert, trip or API key.
+// Only found owners are cached, so a resource created after a miss becomes
+// visible straight away.
+type Ownership struct {
+	pool  *pgxpool.Pool
+	redis *redis.Client
+	cache sync.Map // "kind:id" → ownerEntry
+}
+
+func NewOwnership(pool *pgxpool.Pool, redisClient *redis.Client) *Ownership {
+	return &Ownership{pool: pool, redis: redisClient}
+}
+
+// OwnerFleet returns the owning fleet, or "" if the resource does not exist.
+func (o *Ownership) OwnerFleet(ctx context.Context, kind, id string) (string, error) {
+	cacheKey := kind + ":" + id
+	if raw, ok := o.cache.Load(cacheKey); ok {
+		entry := raw.(ownerEntry)
+		if time.Now().Before(entry.expiresAt) {
+			return entry.fleetID, nil
+		}
+		o.cache.Delete(cacheKey)
+	}
+
+	fleetID, err := o.lookup(ctx, kind, id)
+	if err != nil || fleetID == "" {
+		return "", err
+	}
+	o.cache.Store(cacheKey, ownerEntry{fleetID: fleetID, expiresAt: time.Now().Add(ownerCacheTTL)})
+	return fleetID, nil
+}
+
+func (o *Ownership) lookup(ctx context.Context, kind, id string) (string, error) {
+	var (
+		query string
+		arg   interface{} = id
+	)
+	switch kind {
+	case "vehicle":
+		query = `SELECT fleet_id FROM vehicle_registry WHERE vehicle_id = $1`
+	case "alert":
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return "", nil // not an alert ID — cannot exist
+		}
+		arg = n
+		query = `SELECT fleet_id FROM vehicle_alerts WHERE id = $1`
+	case "trip":
+		// trip has no fleet_id column — join through vehicle_registry
+		query = `
+			SELECT v.fleet_id FROM trip t
+			JOIN vehicle_registry v ON v.vehicle_id = t.vehicle_id
+			WHERE t.trip_id = $1`
+	case "api_key":
+		fleetID, err := o.redis.HGet(ctx, "auth:key:"+id, "fleet_id").Result()
+		if err == redis.Nil {
+			return "", nil
+		}
+		return fleetID, err
+	default:
+		return "", fmt.Errorf("ownership: unknown resource kind %q", kind)
+	}
+
+	var fleetID string
+	err := o.pool.QueryRow(ctx, query, arg).Scan(&fleetID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("ownership: %s %s: %w", kind, id, err)
+	}
+	return fleetID, nil
+}
@@ -0,0 +1,186 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"fleet-monitor/serving/internal/auth"
+	"fleet-monitor/serving/internal/metrics"
+	"fleet-monitor/serving/internal/oidc"
+)
+
+// Clients authenticate one of two ways, neither of which puts the credential
+// in a URL that proxies would log:
+//
+//   - Subprotocol: new WebSocket(url, ["fleet-monitor.v1", "auth.<credential>"]).
+//     The server selects fleet-monitor.v1 and never echoes the credential.
+//   - First message: connect without one, then send
+//     {"type":"auth","token":"<credential>"} within authTimeout.
+//
+// The credential is an API key or an operator bearer token. Token sessions
+// are tied to the operator's subject, so they can be addressed directly.
+const (
+	Subprotocol        = "fleet-monitor.v1"
+	authProtocolPrefix = "auth."
+	authTimeout        = 5 * time.Second
+)
+
+// Application close codes (4000–4999 are reserved for applications).
+const (
+	CloseUnauthorized  = 4401
+	CloseFleetNotFound = 4404
+)
+
+type authMessage struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+// session is what a client authenticated with. Exactly one of apiKey and
+// token is set for WebSocket clients; SSE clients authenticated by bearer
+// token carry only the subject.
+type session struct {
+	apiKey    string
+	token     string
+	subject   string    // operator subject; empty for API keys
+	expiresAt time.Time // token expiry
+}
+
+// authorise resolves a credential and checks it may watch fleetID. Static
+// keys and super_admin tokens may watch any fleet; everything else only its
+// own. Foreign fleets are reported as not found, as on the REST API.
+func (h *Hub) authorise(ctx context.Context, credential, fleetID string) (session, int, string) {
+	if h.tokens != nil && strings.Count(credential, ".") == 2 {
+		claims, err := h.tokens.Verify(ctx, credential)
+		if err != nil {
+			metrics.WSAuthRejections.WithLabelValues("invalid_token").Inc()
+			return session{}, CloseUnauthorized, "invalid bearer token"
+		}
+		if claims.Role != oidc.RoleSuperAdmin && claims.FleetID != fleetID {
+			metrics.WSAuthRejections.WithLabelValues("fleet_mismatch").Inc()
+			return session{}, CloseFleetNotFound, "fleet not found"
+		}
+		return session{token: credential, subject: claims.Subject, expiresAt: claims.ExpiresAt.Time}, 0, ""
+	}
+
+	keyFleet, ok := h.authenticator.FleetID(ctx, credential)
+	if !ok {
+		metrics.WSAuthRejections.WithLabelValues("invalid_key").Inc()
+		return session{}, CloseUnauthorized, "invalid or missing api key"
+	}
+	if keyFleet != "" && keyFleet != fleetID {
+		metrics.WSAuthRejections.WithLabelValues("fleet_mismatch").Inc()
+		return session{}, CloseFleetNotFound, "fleet not found"
+	}
+	return session{apiKey: credential}, 0, ""
+}
+
+// tokenFromSubprotocol returns the credential offered as "auth.<credential>", and whether
+// the client also offered Subprotocol so the handshake can select it.
+func tokenFromSubprotocol(r *http.Request) (token string, offered bool) {
+	for _, p := range websocket.Subprotocols(r) {
+		if p == Subprotocol {
+			offered = true
+		} else if t, ok := strings.CutPrefix(p, authProtocolPrefix); ok {
+			token = t
+		}
+	}
+	return token, offered
+}
+
+// awaitAuth runs the first-message handshake on an upgraded connection. The
+// socket is closed with an application code on failure.
+func (h *Hub) awaitAuth(conn *websocket.Conn, fleetID string) (session, bool) {
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	var msg authMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "auth" {
+		metrics.WSAuthRejections.WithLabelValues("no_handshake").Inc()
+		closeWith(conn, CloseUnauthorized, "expected auth message")
+		return session{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
+	defer cancel()
+	sess, code, reason := h.authorise(ctx, msg.Token, fleetID)
+	if code != 0 {
+		closeWith(conn, code, reason)
+		return session{}, false
+	}
+	return sess, true
+}
+
+func closeWith(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(writeDeadline),
+	)
+	conn.Close()
+}
+
+// watchRevocations closes sockets authenticated with a key as soon as the
+// key store announces its revocation. Expired keys are caught by the
+// periodic re-check in writePump.
+func (h *Hub) watchRevocations(ctx context.Context) {
+	sub := h.redis.Subscribe(ctx, auth.RevocationChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			select {
+			case h.revoked <- msg.Payload:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *Hub) closeRevoked(digest string) {
+	for _, fleet := range h.fleets {
+		for client := range fleet.clients {
+			if client.keyDigest == digest {
+				client.kick(CloseUnauthorized, "api key revoked")
+			}
+		}
+	}
+}
+
+// originChecker allows the configured origins. "*" allows any origin; an
+// empty list keeps gorilla's same-origin check. Requests without an Origin
+// header are not from browsers and are always allowed.
+func originChecker(allowed []string) func(r *http.Request) bool {
+	set := make(map[string]bool, len(allowed))
+	for _, o := range allowed {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			set[strings.ToLower(o)] = true
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || set["*"] {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return set[strings.ToLower(u.Scheme+"://"+u.Host)]
+	}
+}
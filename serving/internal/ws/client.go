@@ -1,12 +1,19 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"fleet-monitor/serving/internal/auth"
+	"fleet-monitor/serving/internal/tracing"
 )
 
 const (
@@ -14,25 +21,68 @@ const (
 	readDeadline   = 60 * time.Second
 	pingInterval   = 30 * time.Second
 	sendBufferSize = 256
+	maxMessageSize = 32 * 1024 // control messages only
 )
 
+// outbound is a frame queued for a client. trace and origin are set only for
+// telemetry that arrived with a trace context from ingestion; seq only for
+// sequenced fleet events.
+type outbound struct {
+	data   []byte
+	seq    int64
+	trace  trace.SpanContext
+	origin time.Time
+}
+
+// closeFrame is sent instead of a normal closure when the server ends the
+// session, e.g. because the client's key was revoked.
+type closeFrame struct {
+	code   int
+	reason string
+}
+
+// pendingEvent is a live sequenced event held back while a replay is read.
+type pendingEvent struct {
+	seq int64
+	out outbound
+}
+
 type Client struct {
-	hub     *Hub
-	conn    *websocket.Conn
-	fleetID string
-	send    chan []byte
-	done    chan struct{}
-	once    sync.Once
+	hub       *Hub
+	conn      *websocket.Conn
+	fleetID   string
+	session   session
+	keyDigest string
+	filter    *Filter // nil = everything; owned by the hub goroutine
+
+	// Resume state, also owned by the hub goroutine. resumeFrom is the
+	// ?since= value (-1 = none); lastSeq is the last sequenced event queued.
+	resumeFrom int64
+	lastSeq    int64
+	catchingUp bool
+	pending    []pendingEvent
+
+	send   chan outbound
+	kicked chan closeFrame
+	done   chan struct{}
+	once   sync.Once
 }
 
-func newClient(hub *Hub, conn *websocket.Conn, fleetID string) *Client {
-	return &Client{
-		hub:     hub,
-		conn:    conn,
-		fleetID: fleetID,
-		send:    make(chan []byte, sendBufferSize),
-		done:    make(chan struct{}),
+func newClient(hub *Hub, conn *websocket.Conn, fleetID string, sess session) *Client {
+	c := &Client{
+		hub:        hub,
+		conn:       conn,
+		fleetID:    fleetID,
+		session:    sess,
+		send:       make(chan outbound, sendBufferSize),
+		kicked:     make(chan closeFrame, 1),
+		resumeFrom: -1,
+		done:       make(chan struct{}),
 	}
+	if sess.apiKey != "" {
+		c.keyDigest = auth.HashKey(sess.apiKey)
+	}
+	return c
 }
 
 func (c *Client) close() {
@@ -45,6 +95,7 @@ func (c *Client) close() {
 func (c *Client) readPump() {
 	defer c.close()
 
+	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(readDeadline))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(readDeadline))
@@ -52,7 +103,7 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err,
 				websocket.CloseGoingAway,
@@ -63,19 +114,21 @@ func (c *Client) readPump() {
 			}
 			return
 		}
+
+		select {
+		case c.hub.control <- parseControl(c, data):
+		case <-c.done:
+			return
+		}
 	}
 }
 
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingInterval)
+	final := closeFrame{code: websocket.CloseNormalClosure}
 	defer func() {
 		ticker.Stop()
-		c.conn.WriteControl(
-			websocket.CloseMessage,
-			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
-			time.Now().Add(writeDeadline),
-		)
-		c.conn.Close()
+		closeWith(c.conn, final.code, final.reason)
 		c.close()
 	}()
 
@@ -86,12 +139,22 @@ func (c *Client) writePump() {
 			if !ok {
 				return
 			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			if err := c.write(msg); err != nil {
 				log.Printf("ws: write error for fleet %s: %v", c.fleetID, err)
 				return
 			}
 
+		case final = <-c.kicked:
+			log.Printf("ws: closing client for fleet %s: %s", c.fleetID, final.reason)
+			return
+
 		case <-ticker.C:
+			// Catches keys that expired after rotation, or revocations this
+			// instance missed while its subscription was down.
+			if !c.stillAuthorised() {
+				final = closeFrame{code: CloseUnauthorized, reason: "credentials expired or revoked"}
+				return
+			}
 			c.conn.SetWriteDeadline(time.Now().Add(writeDeadline))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
@@ -108,7 +171,55 @@ func (c *Client) writePump() {
 	}
 }
 
-func (c *Client) enqueue(msg []byte) bool {
+// write sends one frame. Traced frames get a span that ends the trace begun
+// at the ingestion HTTP handler, tagged with device-to-browser latency.
+func (c *Client) write(msg outbound) error {
+	if !msg.trace.IsValid() {
+		return c.conn.WriteMessage(websocket.TextMessage, msg.data)
+	}
+
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), msg.trace)
+	_, span := tracing.Tracer().Start(ctx, "Client.write",
+		trace.WithAttributes(attribute.String("fleet.id", c.fleetID)))
+	defer span.End()
+
+	err := c.conn.WriteMessage(websocket.TextMessage, msg.data)
+	if !msg.origin.IsZero() {
+		span.SetAttributes(attribute.Int64("fleet.end_to_end_ms", time.Since(msg.origin).Milliseconds()))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "websocket write failed")
+	}
+	return err
+}
+
+// stillAuthorised re-checks the session's credential: API keys against the
+// key store, tokens against their expiry. SSE bearer sessions carry only the
+// expiry of the token their request was authenticated with.
+func (c *Client) stillAuthorised() bool {
+	switch {
+	case c.session.token != "", !c.session.expiresAt.IsZero():
+		return time.Now().Before(c.session.expiresAt)
+	case c.session.apiKey != "":
+		ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
+		defer cancel()
+		_, code, _ := c.hub.authorise(ctx, c.session.apiKey, c.fleetID)
+		return code == 0
+	}
+	return true
+}
+
+// kick asks writePump to close the session with the given code. Safe to call
+// from the hub loop; only the first kick wins.
+func (c *Client) kick(code int, reason string) {
+	select {
+	case c.kicked <- closeFrame{code: code, reason: reason}:
+	default:
+	}
+}
+
+func (c *Client) enqueue(msg outbound) bool {
 	select {
 	case c.send <- msg:
 		return true
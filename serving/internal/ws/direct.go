@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Direct messages reach one operator's sessions on a fleet, on whichever
+// instances they are connected to. Every hub subscribes to directChannel and
+// delivers to its own matching sessions.
+const directChannel = "ws:direct"
+
+type directMessage struct {
+	FleetID string          `json:"fleet_id"`
+	Subject string          `json:"subject"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type OperatorMessagePayload struct {
+	From     string    `json:"from"`
+	FromName string    `json:"from_name,omitempty"`
+	Text     string    `json:"text"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// SendToOperator publishes an operator.message to subject's sessions on
+// fleetID. Delivery is best effort — offline operators do not receive it.
+func (h *Hub) SendToOperator(ctx context.Context, fleetID, subject string, p OperatorMessagePayload) error {
+	data, err := json.Marshal(newOperatorMessageEvent(p))
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(directMessage{FleetID: fleetID, Subject: subject, Data: data})
+	if err != nil {
+		return err
+	}
+	return h.redis.Publish(ctx, directChannel, msg).Err()
+}
+
+func (h *Hub) watchDirect(ctx context.Context) {
+	sub := h.redis.Subscribe(ctx, directChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case raw, ok := <-ch:
+			if !ok {
+				return
+			}
+			var msg directMessage
+			if err := json.Unmarshal([]byte(raw.Payload), &msg); err != nil {
+				log.Printf("ws: direct message decode error: %v", err)
+				continue
+			}
+			select {
+			case h.direct <- msg:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *Hub) deliverDirect(msg directMessage) {
+	fleet, ok := h.fleets[msg.FleetID]
+	if !ok {
+		return
+	}
+	for client := range fleet.clients {
+		if client.session.subject == msg.Subject {
+			h.deliver(fleet, client, outbound{data: msg.Data})
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Drain mode lets an instance shut down without dropping dashboards all at
+// once. New sessions are refused with 503, /health reports draining so the
+// load balancer stops routing here, and connected clients get a
+// server.draining event with a jittered reconnect delay. Whoever is still
+// connected when the grace period ends is closed with 1012 (service restart).
+
+type DrainingPayload struct {
+	Instance         string `json:"instance"`
+	ReconnectAfterMs int64  `json:"reconnect_after_ms"`
+}
+
+// Draining reports whether Drain has started.
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// Drain runs the drain sequence and returns once every session has closed,
+// grace has passed, or ctx is done. Call it before cancelling Run's context.
+func (h *Hub) Drain(ctx context.Context, grace time.Duration) {
+	if !h.draining.CompareAndSwap(false, true) {
+		return
+	}
+	h.publishInstance(ctx)
+
+	select {
+	case h.drainCmd <- grace:
+	case <-ctx.Done():
+		return
+	}
+	log.Printf("ws: draining %d sessions (grace %s)", h.totalSessions(), grace)
+
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	poll := time.NewTicker(250 * time.Millisecond)
+	defer poll.Stop()
+	for h.totalSessions() > 0 {
+		select {
+		case <-poll.C:
+		case <-deadline.C:
+			h.kickAll <- closeFrame{code: websocket.CloseServiceRestart, reason: "server restarting"}
+			// Let writePumps send their close frames.
+			time.Sleep(500 * time.Millisecond)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+	log.Println("ws: drain complete")
+}
+
+// notifyDraining runs on the hub goroutine. Reconnects are spread over the
+// first half of the grace period so the remaining instances are not hit by
+// every client at once.
+func (h *Hub) notifyDraining(grace time.Duration) {
+	spread := grace.Milliseconds() / 2
+	for _, fleet := range h.fleets {
+		for client := range fleet.clients {
+			var after int64
+			if spread > 0 {
+				after = rand.Int64N(spread)
+			}
+			data, _ := json.Marshal(newDrainingEvent(DrainingPayload{Instance: h.instanceID, ReconnectAfterMs: after}))
+			h.deliver(fleet, client, outbound{data: data})
+		}
+	}
+}
+
+func (h *Hub) closeAll(f closeFrame) {
+	for _, fleet := range h.fleets {
+		for client := range fleet.clients {
+			client.kick(f.code, f.reason)
+		}
+	}
+}
+
+func (h *Hub) totalSessions() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	total := 0
+	for _, n := range h.sessions {
+		total += n
+	}
+	return total
+}
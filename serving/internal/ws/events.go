@@ -12,11 +12,21 @@ const (
 	EventVehicleDeviation EventType = "vehicle.deviation"
 	EventStopArrived      EventType = "vehicle.stop_arrived"
 	EventAlertResolved    EventType = "vehicle.alert_resolved"
+	EventDelayPredicted   EventType = "vehicle.delay_predicted"
+	EventUnplannedStop    EventType = "vehicle.unplanned_stop"
 	EventPing             EventType = "ping"
+	EventAuthenticated    EventType = "authenticated"
+	EventSubscribed       EventType = "subscribed"
+	EventResumed          EventType = "resumed"
+	EventOperatorMessage  EventType = "operator.message"
+	EventServerDraining   EventType = "server.draining"
+	EventError            EventType = "error"
 )
 
+// Seq is set on sequenced fleet events only; see stream.go.
 type envelope struct {
 	Type    EventType   `json:"type"`
+	Seq     int64       `json:"seq,omitempty"`
 	Payload interface{} `json:"payload,omitempty"`
 }
 
@@ -42,6 +52,7 @@ type VehicleAlertPayload struct {
 type VehicleOfflinePayload struct {
 	VehicleID  string    `json:"vehicle_id"`
 	LastSeenAt time.Time `json:"last_seen_at"`
+	Place      string    `json:"place,omitempty"` // where it was last seen
 }
 
 type VehicleOnlinePayload struct {
@@ -62,6 +73,43 @@ type StopArrivedPayload struct {
 	ArrivedAt time.Time `json:"arrived_at"`
 }
 
+// DelayPredictedPayload — the ETA at a stop has moved past the end of its
+// planned arrival window by more than the alert margin.
+type DelayPredictedPayload struct {
+	AlertID      int64     `json:"alert_id"`
+	VehicleID    string    `json:"vehicle_id"`
+	TripID       string    `json:"trip_id"`
+	StopID       string    `json:"stop_id"`
+	StopName     string    `json:"stop_name"`
+	Severity     string    `json:"severity"`
+	WindowEnd    time.Time `json:"window_end"`
+	ETA          time.Time `json:"eta"`
+	DelaySeconds int       `json:"delay_seconds"`
+}
+
+// UnplannedStopPayload — a vehicle on a trip has been stationary away from
+// every planned stop for longer than the alert threshold.
+type UnplannedStopPayload struct {
+	AlertID         int64     `json:"alert_id"`
+	UnplannedStopID int64     `json:"unplanned_stop_id"`
+	VehicleID       string    `json:"vehicle_id"`
+	TripID          string    `json:"trip_id"`
+	Lat             float64   `json:"lat"`
+	Lng             float64   `json:"lng"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds int       `json:"duration_seconds"`
+	Severity        string    `json:"severity"`
+}
+
+type AuthenticatedPayload struct {
+	FleetID string `json:"fleet_id"`
+	LastSeq int64  `json:"last_seq"`
+}
+
+type ErrorPayload struct {
+	Message string `json:"message"`
+}
+
 type AlertResolvedPayload struct {
 	AlertID    int64     `json:"alert_id"`
 	VehicleID  string    `json:"vehicle_id"`
@@ -96,6 +144,38 @@ func newAlertResolvedEvent(p AlertResolvedPayload) envelope {
 	return envelope{Type: EventAlertResolved, Payload: p}
 }
 
+func newDelayPredictedEvent(p DelayPredictedPayload) envelope {
+	return envelope{Type: EventDelayPredicted, Payload: p}
+}
+
+func newUnplannedStopEvent(p UnplannedStopPayload) envelope {
+	return envelope{Type: EventUnplannedStop, Payload: p}
+}
+
 func newPingEvent() envelope {
 	return envelope{Type: EventPing}
 }
+
+func newAuthenticatedEvent(p AuthenticatedPayload) envelope {
+	return envelope{Type: EventAuthenticated, Payload: p}
+}
+
+func newSubscribedEvent(f *Filter) envelope {
+	return envelope{Type: EventSubscribed, Payload: f}
+}
+
+func newErrorEvent(msg string) envelope {
+	return envelope{Type: EventError, Payload: ErrorPayload{Message: msg}}
+}
+
+func newResumedEvent(p ResumedPayload) envelope {
+	return envelope{Type: EventResumed, Payload: p}
+}
+
+func newOperatorMessageEvent(p OperatorMessagePayload) envelope {
+	return envelope{Type: EventOperatorMessage, Payload: p}
+}
+
+func newDrainingEvent(p DrainingPayload) envelope {
+	return envelope{Type: EventServerDraining, Payload: p}
+}
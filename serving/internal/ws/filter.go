@@ -0,0 +1,221 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"fleet-monitor/serving/internal/domain"
+)
+
+// Control protocol, client → server:
+//
+//	{"type":"subscribe","vehicle_ids":["v1"],"event_types":["vehicle.alert"],
+//	 "bbox":[minLng,minLat,maxLng,maxLat],"min_severity":"WARNING"}
+//	{"type":"unsubscribe","vehicle_ids":["v1"],"bbox":true}
+//	{"type":"unsubscribe"}                      — clear every filter
+//
+// subscribe adds vehicle IDs and event types to the client's sets and
+// replaces bbox / min_severity when given. unsubscribe removes the listed IDs
+// and types, and clears bbox / min_severity when they are present. Every
+// change is acknowledged with a "subscribed" event carrying the full filter.
+//
+// An empty set or unset field matches everything. bbox applies only to events
+// that carry a position, min_severity only to events with an alert severity.
+const maxFilterVehicles = 500
+
+// BBox and MinSeverity stay raw so unsubscribe can name them with any value.
+type controlMessage struct {
+	Type        string          `json:"type"`
+	VehicleIDs  []string        `json:"vehicle_ids"`
+	EventTypes  []EventType     `json:"event_types"`
+	BBox        json.RawMessage `json:"bbox"`
+	MinSeverity json.RawMessage `json:"min_severity"`
+	Since       *int64          `json:"since"` // resume only
+}
+
+func given(raw json.RawMessage) bool {
+	return len(raw) > 0 && string(raw) != "null"
+}
+
+// Filter is one client's subscription. It is owned by the hub goroutine.
+type Filter struct {
+	VehicleIDs  []string             `json:"vehicle_ids"`
+	EventTypes  []EventType          `json:"event_types"`
+	BBox        *[4]float64          `json:"bbox"`
+	MinSeverity domain.AlertSeverity `json:"min_severity,omitempty"`
+
+	vehicles map[string]bool
+	types    map[EventType]bool
+}
+
+// eventMeta is what the hub needs to know about an event to filter it,
+// without decoding the frame again for every client.
+type eventMeta struct {
+	eventType EventType
+	vehicleID string
+	severity  domain.AlertSeverity
+	hasPos    bool
+	lat, lng  float64
+	seq       int64 // 0 = not sequenced
+}
+
+var filterableEvents = map[EventType]bool{
+	EventVehiclePosition:  true,
+	EventVehicleAlert:     true,
+	EventVehicleOffline:   true,
+	EventVehicleOnline:    true,
+	EventVehicleDeviation: true,
+	EventStopArrived:      true,
+	EventAlertResolved:    true,
+	EventDelayPredicted:   true,
+	EventUnplannedStop:    true,
+}
+
+var severityRank = map[domain.AlertSeverity]int{
+	domain.SeverityInfo:     0,
+	domain.SeverityWarning:  1,
+	domain.SeverityCritical: 2,
+}
+
+func metaFor(evt envelope) eventMeta {
+	m := eventMeta{eventType: evt.Type}
+	switch p := evt.Payload.(type) {
+	case VehiclePositionPayload:
+		m.vehicleID, m.hasPos, m.lat, m.lng = p.VehicleID, true, p.Lat, p.Lng
+	case VehicleAlertPayload:
+		m.vehicleID, m.severity = p.VehicleID, domain.AlertSeverity(p.Severity)
+	case VehicleOfflinePayload:
+		m.vehicleID = p.VehicleID
+	case VehicleOnlinePayload:
+		m.vehicleID = p.VehicleID
+	case VehicleDeviationPayload:
+		m.vehicleID = p.VehicleID
+	case StopArrivedPayload:
+		m.vehicleID = p.VehicleID
+	case AlertResolvedPayload:
+		m.vehicleID = p.VehicleID
+	case DelayPredictedPayload:
+		m.vehicleID, m.severity = p.VehicleID, domain.AlertSeverity(p.Severity)
+	case UnplannedStopPayload:
+		m.vehicleID, m.severity = p.VehicleID, domain.AlertSeverity(p.Severity)
+		m.hasPos, m.lat, m.lng = true, p.Lat, p.Lng
+	}
+	return m
+}
+
+func (f *Filter) matches(m eventMeta) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.types) > 0 && !f.types[m.eventType] {
+		return false
+	}
+	if len(f.vehicles) > 0 && m.vehicleID != "" && !f.vehicles[m.vehicleID] {
+		return false
+	}
+	if f.BBox != nil && m.hasPos {
+		b := f.BBox
+		if m.lng < b[0] || m.lat < b[1] || m.lng > b[2] || m.lat > b[3] {
+			return false
+		}
+	}
+	if f.MinSeverity != "" && m.severity != "" &&
+		severityRank[m.severity] < severityRank[f.MinSeverity] {
+		return false
+	}
+	return true
+}
+
+// apply returns a copy of f with msg applied; f itself is left untouched so
+// a rejected message changes nothing.
+func (f *Filter) apply(msg controlMessage) (*Filter, error) {
+	next := &Filter{vehicles: make(map[string]bool), types: make(map[EventType]bool)}
+	if f != nil {
+		for id := range f.vehicles {
+			next.vehicles[id] = true
+		}
+		for t := range f.types {
+			next.types[t] = true
+		}
+		next.BBox, next.MinSeverity = f.BBox, f.MinSeverity
+	}
+
+	for _, t := range msg.EventTypes {
+		if !filterableEvents[t] {
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+	}
+
+	switch msg.Type {
+	case "subscribe":
+		for _, id := range msg.VehicleIDs {
+			next.vehicles[id] = true
+		}
+		if len(next.vehicles) > maxFilterVehicles {
+			return nil, fmt.Errorf("at most %d vehicle_ids per connection", maxFilterVehicles)
+		}
+		for _, t := range msg.EventTypes {
+			next.types[t] = true
+		}
+		if given(msg.BBox) {
+			var b [4]float64
+			if json.Unmarshal(msg.BBox, &b) != nil ||
+				b[0] < -180 || b[2] > 180 || b[1] < -90 || b[3] > 90 || b[0] > b[2] || b[1] > b[3] {
+				return nil, errors.New("bbox must be [min_lng, min_lat, max_lng, max_lat]")
+			}
+			next.BBox = &b
+		}
+		if given(msg.MinSeverity) {
+			var sev domain.AlertSeverity
+			json.Unmarshal(msg.MinSeverity, &sev)
+			if _, ok := severityRank[sev]; !ok {
+				return nil, errors.New("min_severity must be INFO, WARNING or CRITICAL")
+			}
+			next.MinSeverity = sev
+		}
+
+	case "unsubscribe":
+		if msg.VehicleIDs == nil && msg.EventTypes == nil && !given(msg.BBox) && !given(msg.MinSeverity) {
+			next = &Filter{vehicles: make(map[string]bool), types: make(map[EventType]bool)}
+			break
+		}
+		for _, id := range msg.VehicleIDs {
+			delete(next.vehicles, id)
+		}
+		for _, t := range msg.EventTypes {
+			delete(next.types, t)
+		}
+		if given(msg.BBox) {
+			next.BBox = nil
+		}
+		if given(msg.MinSeverity) {
+			next.MinSeverity = ""
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown message type %q", msg.Type)
+	}
+
+	next.VehicleIDs = slices.Sorted(maps.Keys(next.vehicles))
+	next.EventTypes = slices.Sorted(maps.Keys(next.types))
+	return next, nil
+}
+
+// controlRequest carries a client's control message to the hub goroutine,
+// which owns every Filter.
+type controlRequest struct {
+	client *Client
+	msg    controlMessage
+	err    error
+}
+
+func parseControl(c *Client, data []byte) controlRequest {
+	req := controlRequest{client: c}
+	if err := json.Unmarshal(data, &req.msg); err != nil {
+		req.err = errors.New("invalid JSON")
+	}
+	return req
+}
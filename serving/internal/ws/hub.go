@@ -6,57 +6,105 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
-)
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 4096,
-	CheckOrigin:     func(r *http.Request) bool { return true },
-}
+	"fleet-monitor/serving/internal/metrics"
+	"fleet-monitor/serving/internal/oidc"
+	"fleet-monitor/serving/internal/tracing"
+)
 
 type fleetSubscription struct {
 	clients      map[*Client]struct{}
 	telemetrySub *redis.PubSub
-	alertsSub    *redis.PubSub
+	eventsSub    *redis.PubSub
 	cancel       context.CancelFunc
 }
 
 type broadcastMsg struct {
 	fleetID string
 	data    []byte
+	meta    eventMeta
+	trace   trace.SpanContext
+	origin  time.Time
 }
 
+// Authenticator resolves an API key to its fleet; "" means a platform key
+// that may watch every fleet.
 type Authenticator interface {
-	Validate(ctx context.Context, apiKey string) bool
+	FleetID(ctx context.Context, apiKey string) (string, bool)
+}
+
+type Config struct {
+	// Tokens verifies operator bearer tokens; nil accepts API keys only.
+	Tokens *oidc.Verifier
+	// AllowedOrigins lists browser origins permitted to open sockets; empty
+	// means same-origin only.
+	AllowedOrigins []string
 }
 
 type Hub struct {
 	redis         *redis.Client
 	authenticator Authenticator
+	tokens        *oidc.Verifier
+	upgrader      websocket.Upgrader
+	instanceID    string
+	startedAt     time.Time
+	draining      atomic.Bool
 	register      chan *Client
 	unregister    chan *Client
 	broadcast     chan broadcastMsg
-	mu            sync.RWMutex
+	revoked       chan string
+	control       chan controlRequest
+	replayed      chan replayResult
+	direct        chan directMessage
+	drainCmd      chan time.Duration
+	kickAll       chan closeFrame
 	fleets        map[string]*fleetSubscription
+
+	mu       sync.RWMutex
+	sessions map[sessionKey]int
 }
 
-func NewHub(redisClient *redis.Client, auth Authenticator) *Hub {
+func NewHub(redisClient *redis.Client, auth Authenticator, cfg Config) *Hub {
 	return &Hub{
 		redis:         redisClient,
 		authenticator: auth,
-		register:      make(chan *Client, 64),
-		unregister:    make(chan *Client, 64),
-		broadcast:     make(chan broadcastMsg, 1024),
-		fleets:        make(map[string]*fleetSubscription),
+		tokens:        cfg.Tokens,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 4096,
+			Subprotocols:    []string{Subprotocol},
+			CheckOrigin:     originChecker(cfg.AllowedOrigins),
+		},
+		instanceID: newInstanceID(),
+		startedAt:  time.Now(),
+		register:   make(chan *Client, 64),
+		unregister: make(chan *Client, 64),
+		broadcast:  make(chan broadcastMsg, 1024),
+		revoked:    make(chan string, 64),
+		control:    make(chan controlRequest, 64),
+		replayed:   make(chan replayResult, 64),
+		direct:     make(chan directMessage, 64),
+		drainCmd:   make(chan time.Duration),
+		kickAll:    make(chan closeFrame),
+		fleets:     make(map[string]*fleetSubscription),
+		sessions:   make(map[sessionKey]int),
 	}
 }
 
 func (h *Hub) Run(ctx context.Context) {
+	go h.watchRevocations(ctx)
+	go h.watchDirect(ctx)
+	go h.heartbeat(ctx)
+
 	for {
 		select {
 		case client := <-h.register:
@@ -65,6 +113,18 @@ func (h *Hub) Run(ctx context.Context) {
 			h.removeClient(client)
 		case msg := <-h.broadcast:
 			h.fanOut(msg)
+		case digest := <-h.revoked:
+			h.closeRevoked(digest)
+		case req := <-h.control:
+			h.applyControl(ctx, req)
+		case res := <-h.replayed:
+			h.finishReplay(res)
+		case msg := <-h.direct:
+			h.deliverDirect(msg)
+		case grace := <-h.drainCmd:
+			h.notifyDraining(grace)
+		case f := <-h.kickAll:
+			h.closeAll(f)
 		case <-ctx.Done():
 			h.shutdown()
 			return
@@ -72,29 +132,89 @@ func (h *Hub) Run(ctx context.Context) {
 	}
 }
 
+// ServeWS upgrades GET /ws?fleet_id=... . The API key arrives in the
+// Sec-WebSocket-Protocol header or as the first message — never in the query
+// string. See auth.go for the handshake.
 func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	if h.Draining() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, `{"error":"instance is draining, reconnect"}`, http.StatusServiceUnavailable)
+		return
+	}
 	fleetID := r.URL.Query().Get("fleet_id")
-	token := r.URL.Query().Get("token")
-
 	if fleetID == "" {
 		http.Error(w, `{"error":"fleet_id query parameter required"}`, http.StatusBadRequest)
 		return
 	}
-	if !h.authenticator.Validate(r.Context(), token) {
-		http.Error(w, `{"error":"invalid or missing token"}`, http.StatusUnauthorized)
+	since := int64(-1)
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, `{"error":"since must be a non-negative sequence number"}`, http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+	if r.URL.Query().Has("token") {
+		metrics.WSAuthRejections.WithLabelValues("query_token").Inc()
+		http.Error(w, `{"error":"token must not be sent in the query string; use the Sec-WebSocket-Protocol header or an auth message"}`, http.StatusBadRequest)
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Subprotocol auth is checked before upgrading so a bad credential gets a
+	// plain HTTP error.
+	token, offered := tokenFromSubprotocol(r)
+	var sess session
+	if token != "" {
+		if !offered {
+			http.Error(w, `{"error":"offer the `+Subprotocol+` subprotocol alongside the auth token"}`, http.StatusBadRequest)
+			return
+		}
+		var code int
+		sess, code, _ = h.authorise(r.Context(), token, fleetID)
+		switch code {
+		case CloseUnauthorized:
+			http.Error(w, `{"error":"invalid or missing credentials"}`, http.StatusUnauthorized)
+			return
+		case CloseFleetNotFound:
+			http.Error(w, `{"error":"fleet not found"}`, http.StatusNotFound)
+			return
+		}
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("ws: upgrade failed for fleet %s: %v", fleetID, err)
 		return
 	}
 
-	client := newClient(h, conn, fleetID)
-	h.register <- client
-	go client.writePump()
-	go client.readPump()
+	go func() {
+		if token == "" {
+			var ok bool
+			if sess, ok = h.awaitAuth(conn, fleetID); !ok {
+				return
+			}
+		}
+
+		// last_seq gives clients that have not yet seen an event a point to
+		// resume from.
+		ctx, cancel := context.WithTimeout(context.Background(), appendTimeout)
+		lastSeq, _ := h.lastSeq(ctx, fleetID)
+		cancel()
+
+		conn.SetWriteDeadline(time.Now().Add(writeDeadline))
+		ack := AuthenticatedPayload{FleetID: fleetID, LastSeq: lastSeq}
+		if err := conn.WriteJSON(newAuthenticatedEvent(ack)); err != nil {
+			conn.Close()
+			return
+		}
+
+		client := newClient(h, conn, fleetID, sess)
+		client.resumeFrom = since
+		h.register <- client
+		go client.writePump()
+		go client.readPump()
+	}()
 }
 
 func (h *Hub) addClient(ctx context.Context, client *Client) {
@@ -104,6 +224,11 @@ func (h *Hub) addClient(ctx context.Context, client *Client) {
 		h.fleets[client.fleetID] = fleet
 	}
 	fleet.clients[client] = struct{}{}
+	h.track(client, 1)
+	if client.resumeFrom >= 0 {
+		h.startReplay(ctx, client, client.resumeFrom)
+	}
+	metrics.WSClients.WithLabelValues(client.fleetID).Set(float64(len(fleet.clients)))
 	log.Printf("ws: client connected to fleet %s (total: %d)", client.fleetID, len(fleet.clients))
 }
 
@@ -114,13 +239,16 @@ func (h *Hub) removeClient(client *Client) {
 	}
 	if _, ok := fleet.clients[client]; ok {
 		delete(fleet.clients, client)
+		h.track(client, -1)
 		close(client.send)
 		log.Printf("ws: client disconnected from fleet %s (remaining: %d)", client.fleetID, len(fleet.clients))
 	}
+	metrics.WSClients.WithLabelValues(client.fleetID).Set(float64(len(fleet.clients)))
 	if len(fleet.clients) == 0 {
+		metrics.WSClients.DeleteLabelValues(client.fleetID)
 		fleet.cancel()
 		fleet.telemetrySub.Close()
-		fleet.alertsSub.Close()
+		fleet.eventsSub.Close()
 		delete(h.fleets, client.fleetID)
 		log.Printf("ws: fleet %s has no clients, subscriptions cancelled", client.fleetID)
 	}
@@ -131,24 +259,99 @@ func (h *Hub) fanOut(msg broadcastMsg) {
 	if !exists {
 		return
 	}
+	out := outbound{data: msg.data, seq: msg.meta.seq, trace: msg.trace, origin: msg.origin}
 	for client := range fleet.clients {
-		if !client.enqueue(msg.data) {
-			log.Printf("ws: evicting slow client from fleet %s", msg.fleetID)
-			delete(fleet.clients, client)
-			close(client.send)
+		if !client.filter.matches(msg.meta) {
+			continue
+		}
+		if msg.meta.seq == 0 {
+			h.deliver(fleet, client, out)
+			continue
+		}
+		if client.catchingUp {
+			if len(client.pending) >= maxPendingEvents {
+				h.evict(fleet, client)
+				continue
+			}
+			client.pending = append(client.pending, pendingEvent{seq: msg.meta.seq, out: out})
+			continue
+		}
+		if msg.meta.seq <= client.lastSeq {
+			continue
+		}
+		if h.deliver(fleet, client, out) {
+			client.lastSeq = msg.meta.seq
 		}
 	}
 }
 
+// deliver queues out for c, evicting it if its buffer is full.
+func (h *Hub) deliver(fleet *fleetSubscription, c *Client, out outbound) bool {
+	if c.enqueue(out) {
+		return true
+	}
+	h.evict(fleet, c)
+	return false
+}
+
+func (h *Hub) evict(fleet *fleetSubscription, c *Client) {
+	log.Printf("ws: evicting slow client from fleet %s", c.fleetID)
+	delete(fleet.clients, c)
+	h.track(c, -1)
+	close(c.send)
+	metrics.WSEvictions.WithLabelValues(c.fleetID).Inc()
+	metrics.WSClients.WithLabelValues(c.fleetID).Set(float64(len(fleet.clients)))
+}
+
+// applyControl updates a client's filter and acknowledges it. Errors leave
+// the previous filter in place.
+func (h *Hub) applyControl(ctx context.Context, req controlRequest) {
+	c := req.client
+	if fleet, ok := h.fleets[c.fleetID]; !ok || !hasClient(fleet, c) {
+		return // disconnected or evicted while the request was queued
+	}
+	if req.err == nil && req.msg.Type == "resume" {
+		if req.msg.Since == nil || *req.msg.Since < 0 {
+			data, _ := json.Marshal(newErrorEvent("resume requires a non-negative since"))
+			c.enqueue(outbound{data: data})
+			return
+		}
+		h.startReplay(ctx, c, *req.msg.Since)
+		return
+	}
+
+	err := req.err
+	if err == nil {
+		var next *Filter
+		if next, err = c.filter.apply(req.msg); err == nil {
+			c.filter = next
+		}
+	}
+
+	evt := newSubscribedEvent(c.filter)
+	if err != nil {
+		evt = newErrorEvent(err.Error())
+	}
+	data, _ := json.Marshal(evt)
+	c.enqueue(outbound{data: data})
+}
+
+func hasClient(fleet *fleetSubscription, c *Client) bool {
+	_, ok := fleet.clients[c]
+	return ok
+}
+
 func (h *Hub) shutdown() {
 	for fleetID, fleet := range h.fleets {
 		fleet.cancel()
 		fleet.telemetrySub.Close()
-		fleet.alertsSub.Close()
+		fleet.eventsSub.Close()
 		for client := range fleet.clients {
+			h.track(client, -1)
 			close(client.send)
 		}
 		delete(h.fleets, fleetID)
+		metrics.WSClients.DeleteLabelValues(fleetID)
 	}
 	log.Println("ws: hub shutdown complete")
 }
@@ -157,17 +360,17 @@ func (h *Hub) startFleetSubscriptions(ctx context.Context, fleetID string) *flee
 	subCtx, cancel := context.WithCancel(ctx)
 
 	telemetrySub := h.redis.Subscribe(subCtx, fmt.Sprintf("fleet:%s:telemetry", fleetID))
-	alertsSub := h.redis.Subscribe(subCtx, fmt.Sprintf("fleet:%s:alerts", fleetID))
+	eventsSub := h.redis.Subscribe(subCtx, streamKey(fleetID))
 
 	fleet := &fleetSubscription{
 		clients:      make(map[*Client]struct{}),
 		telemetrySub: telemetrySub,
-		alertsSub:    alertsSub,
+		eventsSub:    eventsSub,
 		cancel:       cancel,
 	}
 
 	go h.drainTelemetry(subCtx, fleetID, telemetrySub)
-	go h.drainAlerts(subCtx, fleetID, alertsSub)
+	go h.drainEvents(subCtx, fleetID, eventsSub)
 
 	log.Printf("ws: started subscriptions for fleet %s", fleetID)
 	return fleet
@@ -182,18 +385,39 @@ func (h *Hub) drainTelemetry(ctx context.Context, fleetID string, sub *redis.Pub
 				return
 			}
 			var raw struct {
-				VehicleID  string  `json:"vehicle_id"`
-				Lat        float64 `json:"lat"`
-				Lng        float64 `json:"lng"`
-				SpeedKmh   float64 `json:"speed_kmh"`
-				IsMoving   bool    `json:"is_moving"`
-				EngineOn   bool    `json:"engine_on"`
-				ReceivedAt int64   `json:"received_at"`
+				VehicleID   string            `json:"vehicle_id"`
+				Lat         float64           `json:"lat"`
+				Lng         float64           `json:"lng"`
+				SpeedKmh    float64           `json:"speed_kmh"`
+				IsMoving    bool              `json:"is_moving"`
+				EngineOn    bool              `json:"engine_on"`
+				ReceivedAt  int64             `json:"received_at"`
+				TimestampMs int64             `json:"timestamp_ms"`
+				Trace       map[string]string `json:"trace"`
 			}
 			if err := json.Unmarshal([]byte(msg.Payload), &raw); err != nil {
 				log.Printf("ws: telemetry decode error for fleet %s: %v", fleetID, err)
 				continue
 			}
+
+			// Only continue traces started at ingestion; untraced payloads
+			// must not open new root traces here.
+			out := broadcastMsg{fleetID: fleetID}
+			if parent := tracing.Extract(ctx, raw.Trace); trace.SpanContextFromContext(parent).IsValid() {
+				_, span := tracing.Tracer().Start(parent, "Hub.drainTelemetry",
+					trace.WithSpanKind(trace.SpanKindConsumer),
+					trace.WithAttributes(
+						attribute.String("fleet.id", fleetID),
+						attribute.String("vehicle.id", raw.VehicleID),
+					),
+				)
+				out.trace = span.SpanContext()
+				span.End()
+			}
+			if raw.TimestampMs > 0 {
+				out.origin = time.UnixMilli(raw.TimestampMs)
+			}
+
 			evt := newPositionEvent(VehiclePositionPayload{
 				VehicleID:  raw.VehicleID,
 				Lat:        raw.Lat,
@@ -208,8 +432,10 @@ func (h *Hub) drainTelemetry(ctx context.Context, fleetID string, sub *redis.Pub
 				log.Printf("ws: telemetry encode error for fleet %s: %v", fleetID, err)
 				continue
 			}
+			out.data = data
+			out.meta = metaFor(evt)
 			select {
-			case h.broadcast <- broadcastMsg{fleetID: fleetID, data: data}:
+			case h.broadcast <- out:
 			case <-ctx.Done():
 				return
 			}
@@ -219,7 +445,9 @@ func (h *Hub) drainTelemetry(ctx context.Context, fleetID string, sub *redis.Pub
 	}
 }
 
-func (h *Hub) drainAlerts(ctx context.Context, fleetID string, sub *redis.PubSub) {
+// drainEvents forwards sequenced events — alerts from ingestion and events
+// appended by any serving instance.
+func (h *Hub) drainEvents(ctx context.Context, fleetID string, sub *redis.PubSub) {
 	ch := sub.Channel()
 	for {
 		select {
@@ -227,32 +455,18 @@ func (h *Hub) drainAlerts(ctx context.Context, fleetID string, sub *redis.PubSub
 			if !ok {
 				return
 			}
-			var raw struct {
-				VehicleID   string  `json:"vehicle_id"`
-				AlertType   string  `json:"alert_type"`
-				Severity    string  `json:"severity"`
-				Value       float64 `json:"value"`
-				TriggeredAt int64   `json:"triggered_at"`
-			}
-			if err := json.Unmarshal([]byte(msg.Payload), &raw); err != nil {
-				log.Printf("ws: alert decode error for fleet %s: %v", fleetID, err)
+			var evt streamEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &evt); err != nil {
+				log.Printf("ws: event decode error for fleet %s: %v", fleetID, err)
 				continue
 			}
-			evt := newAlertEvent(VehicleAlertPayload{
-				AlertID:        0,
-				VehicleID:      raw.VehicleID,
-				AlertType:      raw.AlertType,
-				Severity:       raw.Severity,
-				TriggeredValue: raw.Value,
-				CreatedAt:      time.Unix(raw.TriggeredAt, 0).UTC(),
-			})
-			data, err := json.Marshal(evt)
+			data, err := evt.encode()
 			if err != nil {
-				log.Printf("ws: alert encode error for fleet %s: %v", fleetID, err)
+				log.Printf("ws: event encode error for fleet %s seq %d: %v", fleetID, evt.Seq, err)
 				continue
 			}
 			select {
-			case h.broadcast <- broadcastMsg{fleetID: fleetID, data: data}:
+			case h.broadcast <- broadcastMsg{fleetID: fleetID, data: data, meta: evt.meta()}:
 			case <-ctx.Done():
 				return
 			}
@@ -282,24 +496,34 @@ func (h *Hub) BroadcastAlertResolved(fleetID string, payload AlertResolvedPayloa
 	h.broadcastEvent(fleetID, newAlertResolvedEvent(payload))
 }
 
+func (h *Hub) BroadcastDelayPredicted(fleetID string, payload DelayPredictedPayload) {
+	h.broadcastEvent(fleetID, newDelayPredictedEvent(payload))
+}
+
+func (h *Hub) BroadcastUnplannedStop(fleetID string, payload UnplannedStopPayload) {
+	h.broadcastEvent(fleetID, newUnplannedStopEvent(payload))
+}
+
+// broadcastEvent sequences evt and publishes it to the fleet on every
+// instance. The event is dropped, with a log line, if Redis is unavailable.
 func (h *Hub) broadcastEvent(fleetID string, evt envelope) {
-	data, err := json.Marshal(evt)
-	if err != nil {
-		log.Printf("ws: broadcast marshal error for fleet %s: %v", fleetID, err)
-		return
-	}
-	select {
-	case h.broadcast <- broadcastMsg{fleetID: fleetID, data: data}:
-	default:
-		log.Printf("ws: broadcast channel full, dropping event for fleet %s", fleetID)
+	ctx, cancel := context.WithTimeout(context.Background(), appendTimeout)
+	defer cancel()
+	if _, err := h.appendEvent(ctx, fleetID, evt); err != nil {
+		log.Printf("ws: append %s event for fleet %s failed: %v", evt.Type, fleetID, err)
 	}
 }
 
+// ClientCount is the number of sessions on fleetID on this instance; see
+// Watchers for the whole deployment.
 func (h *Hub) ClientCount(fleetID string) int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	if fleet, ok := h.fleets[fleetID]; ok {
-		return len(fleet.clients)
+	total := 0
+	for k, n := range h.sessions {
+		if k.fleetID == fleetID {
+			total += n
+		}
 	}
-	return 0
+	return total
 }
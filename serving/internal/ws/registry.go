@@ -0,0 +1,212 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Instance registry — each serving instance heartbeats its identity and a
+// snapshot of its sessions so any instance can answer "who is watching fleet
+// X" across the deployment. Counts lag by up to one heartbeat.
+//
+//	ws:instances                  ZSET  instance ID → last heartbeat (unix)
+//	ws:instance:{id}              HASH  started_at, draining, clients
+//	ws:instance:{id}:sessions     HASH  "{fleet_id}|{subject}" → open sessions
+//
+// Both hashes expire after instanceTTL, so a crashed instance drops out on
+// its own; the ZSET entry is pruned by the next reader.
+const (
+	heartbeatInterval = 10 * time.Second
+	instanceTTL       = 3 * heartbeatInterval
+	instanceIndex     = "ws:instances"
+)
+
+// sessionKey groups local sessions for the registry. subject is empty for
+// API-key sessions, which belong to no operator.
+type sessionKey struct {
+	fleetID string
+	subject string
+}
+
+func (k sessionKey) field() string { return k.fleetID + "|" + k.subject }
+
+type FleetWatchers struct {
+	FleetID        string         `json:"fleet_id"`
+	Connections    int            `json:"connections"`
+	APIKeySessions int            `json:"api_key_sessions"`
+	Operators      map[string]int `json:"operators"` // subject → sessions
+	Instances      map[string]int `json:"instances"` // instance ID → sessions
+}
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "serving"
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+func instanceKey(id string) string         { return "ws:instance:" + id }
+func instanceSessionsKey(id string) string { return "ws:instance:" + id + ":sessions" }
+
+// InstanceID identifies this process in the registry.
+func (h *Hub) InstanceID() string {
+	return h.instanceID
+}
+
+// track adjusts the local session counts. Runs on the hub goroutine; the
+// lock is for readers on other goroutines.
+func (h *Hub) track(c *Client, delta int) {
+	k := sessionKey{fleetID: c.fleetID, subject: c.session.subject}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions[k] += delta; h.sessions[k] <= 0 {
+		delete(h.sessions, k)
+	}
+}
+
+func (h *Hub) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	h.publishInstance(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			h.publishInstance(ctx)
+		case <-ctx.Done():
+			// Deregister with a fresh context — ctx is already cancelled.
+			dctx, cancel := context.WithTimeout(context.Background(), appendTimeout)
+			defer cancel()
+			pipe := h.redis.TxPipeline()
+			pipe.ZRem(dctx, instanceIndex, h.instanceID)
+			pipe.Del(dctx, instanceKey(h.instanceID), instanceSessionsKey(h.instanceID))
+			if _, err := pipe.Exec(dctx); err != nil {
+				log.Printf("ws: deregister instance %s: %v", h.instanceID, err)
+			}
+			return
+		}
+	}
+}
+
+func (h *Hub) publishInstance(ctx context.Context) {
+	h.mu.RLock()
+	fields := make(map[string]interface{}, len(h.sessions))
+	total := 0
+	for k, n := range h.sessions {
+		fields[k.field()] = n
+		total += n
+	}
+	h.mu.RUnlock()
+
+	draining := "0"
+	if h.draining.Load() {
+		draining = "1"
+	}
+
+	pipe := h.redis.TxPipeline()
+	pipe.HSet(ctx, instanceKey(h.instanceID),
+		"started_at", h.startedAt.Unix(),
+		"draining", draining,
+		"clients", total,
+	)
+	pipe.Expire(ctx, instanceKey(h.instanceID), instanceTTL)
+	pipe.Del(ctx, instanceSessionsKey(h.instanceID))
+	if len(fields) > 0 {
+		pipe.HSet(ctx, instanceSessionsKey(h.instanceID), fields)
+		pipe.Expire(ctx, instanceSessionsKey(h.instanceID), instanceTTL)
+	}
+	pipe.ZAdd(ctx, instanceIndex, redis.Z{Score: float64(time.Now().Unix()), Member: h.instanceID})
+	if _, err := pipe.Exec(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("ws: instance heartbeat failed: %v", err)
+	}
+}
+
+// liveInstances returns instances that heartbeated within instanceTTL, and
+// prunes the rest from the index.
+func (h *Hub) liveInstances(ctx context.Context) ([]string, error) {
+	cutoff := strconv.FormatInt(time.Now().Add(-instanceTTL).Unix(), 10)
+	if err := h.redis.ZRemRangeByScore(ctx, instanceIndex, "-inf", "("+cutoff).Err(); err != nil {
+		return nil, err
+	}
+	return h.redis.ZRangeByScore(ctx, instanceIndex, &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+}
+
+// instanceSessions loads every live instance's session hash.
+func (h *Hub) instanceSessions(ctx context.Context) (map[string]map[string]string, error) {
+	ids, err := h.liveInstances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list instances: %w", err)
+	}
+
+	pipe := h.redis.Pipeline()
+	cmds := make(map[string]*redis.MapStringStringCmd, len(ids))
+	for _, id := range ids {
+		cmds[id] = pipe.HGetAll(ctx, instanceSessionsKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("load sessions: %w", err)
+	}
+
+	out := make(map[string]map[string]string, len(ids))
+	for id, cmd := range cmds {
+		out[id] = cmd.Val()
+	}
+	return out, nil
+}
+
+// Watchers reports the sessions open on fleetID across all instances.
+func (h *Hub) Watchers(ctx context.Context, fleetID string) (FleetWatchers, error) {
+	fw := FleetWatchers{
+		FleetID:   fleetID,
+		Operators: make(map[string]int),
+		Instances: make(map[string]int),
+	}
+	all, err := h.instanceSessions(ctx)
+	if err != nil {
+		return fw, err
+	}
+
+	for id, sessions := range all {
+		for field, v := range sessions {
+			fleet, subject, _ := strings.Cut(field, "|")
+			if fleet != fleetID {
+				continue
+			}
+			n, _ := strconv.Atoi(v)
+			fw.Connections += n
+			fw.Instances[id] += n
+			if subject == "" {
+				fw.APIKeySessions += n
+			} else {
+				fw.Operators[subject] += n
+			}
+		}
+	}
+	return fw, nil
+}
+
+// UserSessions counts an operator's sessions on fleetID across instances.
+func (h *Hub) UserSessions(ctx context.Context, fleetID, subject string) (int, error) {
+	all, err := h.instanceSessions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, sessions := range all {
+		n, _ := strconv.Atoi(sessions[sessionKey{fleetID: fleetID, subject: subject}.field()])
+		total += n
+	}
+	return total, nil
+}
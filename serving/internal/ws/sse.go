@@ -0,0 +1,145 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fleet-monitor/serving/internal/middleware"
+)
+
+const (
+	sseKeepalive  = 15 * time.Second
+	sseRetryMilli = 3000
+)
+
+// ServeSSE streams the same envelopes as /ws as text/event-stream, for
+// clients whose proxies break WebSocket upgrades. It runs behind the normal
+// header auth and fleet scope, and shares the hub's fleet subscriptions.
+//
+// Each message's data is one envelope; sequenced events also carry an id, so
+// EventSource reconnects resume via Last-Event-ID. ?since= works as on /ws.
+// Subscription filters are WebSocket-only — there is no inbound channel.
+//
+// GET /api/v1/fleet/{fleet_id}/events
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	if h.Draining() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, `{"error":"instance is draining, reconnect"}`, http.StatusServiceUnavailable)
+		return
+	}
+	fleetID := r.PathValue("fleet_id")
+
+	since := int64(-1)
+	resumeFrom := r.Header.Get("Last-Event-ID")
+	if resumeFrom == "" {
+		resumeFrom = r.URL.Query().Get("since")
+	}
+	if resumeFrom != "" {
+		n, err := strconv.ParseInt(resumeFrom, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, `{"error":"Last-Event-ID / since must be a non-negative sequence number"}`, http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx would otherwise buffer the stream
+	w.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithTimeout(r.Context(), appendTimeout)
+	lastSeq, _ := h.lastSeq(ctx, fleetID)
+	cancel()
+
+	ack, _ := json.Marshal(newAuthenticatedEvent(AuthenticatedPayload{FleetID: fleetID, LastSeq: lastSeq}))
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetryMilli)
+	if err := writeSSE(w, rc, outbound{data: ack}); err != nil {
+		return
+	}
+
+	// Bearer-token callers are addressable by subject, and the stream ends
+	// when their token expires, as a WebSocket session does.
+	sess := session{apiKey: middleware.APIKeyFromContext(r.Context())}
+	if p := middleware.PrincipalFromContext(r.Context()); p.Method == "token" {
+		sess.subject = p.Subject
+		sess.expiresAt = p.ExpiresAt
+	}
+	client := newClient(h, nil, fleetID, sess)
+	client.resumeFrom = since
+	h.register <- client
+	defer client.close()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	reauth := time.NewTicker(pingInterval)
+	defer reauth.Stop()
+	var expired <-chan time.Time
+	if !sess.expiresAt.IsZero() {
+		expiry := time.NewTimer(time.Until(sess.expiresAt))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	for {
+		select {
+		case msg, ok := <-client.send:
+			if !ok {
+				return // evicted or hub shutting down
+			}
+			if err := writeSSE(w, rc, msg); err != nil {
+				log.Printf("sse: write error for fleet %s: %v", fleetID, err)
+				return
+			}
+
+		case f := <-client.kicked:
+			data, _ := json.Marshal(newErrorEvent(f.reason))
+			writeSSE(w, rc, outbound{data: data})
+			return
+
+		case <-reauth.C:
+			if !client.stillAuthorised() {
+				data, _ := json.Marshal(newErrorEvent("credentials expired or revoked"))
+				writeSSE(w, rc, outbound{data: data})
+				return
+			}
+
+		case <-expired:
+			data, _ := json.Marshal(newErrorEvent("credentials expired or revoked"))
+			writeSSE(w, rc, outbound{data: data})
+			return
+
+		case <-keepalive.C:
+			rc.SetWriteDeadline(time.Now().Add(writeDeadline))
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, rc *http.ResponseController, msg outbound) error {
+	rc.SetWriteDeadline(time.Now().Add(writeDeadline))
+	if msg.seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", msg.seq); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", msg.data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
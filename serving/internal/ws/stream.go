@@ -0,0 +1,241 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"fleet-monitor/serving/internal/domain"
+)
+
+// Discrete fleet events (alerts, offline/online, deviations, stop arrivals)
+// carry a per-fleet sequence number and are kept in a capped Redis Stream,
+// fleet:{id}:events, with entry IDs 0-{seq}. Positions are not sequenced: they
+// are superseded within seconds and the snapshot endpoints cover a gap.
+//
+// Clients resume with GET /ws?fleet_id=...&since={seq}, mid-session with
+// {"type":"resume","since":seq}, or over SSE with Last-Event-ID. Missed events are replayed in order, then a
+// "resumed" event marks the switch to live.
+const (
+	// eventStreamMaxLen must match the ingestion module's value.
+	eventStreamMaxLen = 10000
+	maxReplayEvents   = 1000
+	maxPendingEvents  = 1024
+	appendTimeout     = 2 * time.Second
+)
+
+// appendEventScript assigns the next sequence number, appends to the stream
+// and publishes, atomically so sequence order is stream order. Shared with
+// the ingestion alert evaluator.
+var appendEventScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[2], '0-' .. seq,
+	'type', ARGV[3], 'vehicle_id', ARGV[4], 'severity', ARGV[5], 'payload', ARGV[6])
+redis.call('PUBLISH', ARGV[1], cjson.encode({
+	seq = seq, type = ARGV[3], vehicle_id = ARGV[4], severity = ARGV[5], payload = ARGV[6]}))
+return seq
+`)
+
+func seqKey(fleetID string) string    { return fmt.Sprintf("fleet:%s:seq", fleetID) }
+func streamKey(fleetID string) string { return fmt.Sprintf("fleet:%s:events", fleetID) }
+
+// streamEvent is one sequenced event, as published and as stored.
+type streamEvent struct {
+	Seq       int64     `json:"seq"`
+	Type      EventType `json:"type"`
+	VehicleID string    `json:"vehicle_id"`
+	Severity  string    `json:"severity"`
+	Payload   string    `json:"payload"`
+}
+
+type ResumedPayload struct {
+	Since     int64 `json:"since"`
+	LastSeq   int64 `json:"last_seq"`
+	Replayed  int   `json:"replayed"`
+	Truncated bool  `json:"truncated"` // events were lost — reload via REST
+}
+
+type replayResult struct {
+	client  *Client
+	events  []streamEvent
+	resumed ResumedPayload
+	err     error
+}
+
+func (e streamEvent) meta() eventMeta {
+	return eventMeta{
+		eventType: e.Type,
+		vehicleID: e.VehicleID,
+		severity:  domain.AlertSeverity(e.Severity),
+		seq:       e.Seq,
+	}
+}
+
+// encode renders the event as a client frame. Alerts are stored in the shape
+// ingestion publishes them; everything else already holds the event payload.
+func (e streamEvent) encode() ([]byte, error) {
+	evt := envelope{Type: e.Type, Seq: e.Seq, Payload: json.RawMessage(e.Payload)}
+	if e.Type == EventVehicleAlert {
+		var raw struct {
+			VehicleID   string  `json:"vehicle_id"`
+			AlertType   string  `json:"alert_type"`
+			Severity    string  `json:"severity"`
+			Value       float64 `json:"value"`
+			TriggeredAt int64   `json:"triggered_at"`
+		}
+		if err := json.Unmarshal([]byte(e.Payload), &raw); err != nil {
+			return nil, fmt.Errorf("decode alert: %w", err)
+		}
+		evt.Payload = VehicleAlertPayload{
+			VehicleID:      raw.VehicleID,
+			AlertType:      raw.AlertType,
+			Severity:       raw.Severity,
+			TriggeredValue: raw.Value,
+			CreatedAt:      time.Unix(raw.TriggeredAt, 0).UTC(),
+		}
+	}
+	return json.Marshal(evt)
+}
+
+// appendEvent sequences evt for every instance's clients; it reaches this
+// hub's clients through the fleet's events subscription like any other.
+func (h *Hub) appendEvent(ctx context.Context, fleetID string, evt envelope) (int64, error) {
+	payload, err := json.Marshal(evt.Payload)
+	if err != nil {
+		return 0, err
+	}
+	m := metaFor(evt)
+	return appendEventScript.Run(ctx, h.redis,
+		[]string{seqKey(fleetID), streamKey(fleetID)},
+		streamKey(fleetID), eventStreamMaxLen,
+		string(evt.Type), m.vehicleID, string(m.severity), payload,
+	).Int64()
+}
+
+func (h *Hub) lastSeq(ctx context.Context, fleetID string) (int64, error) {
+	n, err := h.redis.Get(ctx, seqKey(fleetID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// readReplay loads the events after since. The result is truncated when the
+// stream no longer holds since+1 or more than maxReplayEvents were missed.
+func (h *Hub) readReplay(ctx context.Context, fleetID string, since int64) ([]streamEvent, ResumedPayload, error) {
+	resumed := ResumedPayload{Since: since}
+
+	last, err := h.lastSeq(ctx, fleetID)
+	if err != nil {
+		return nil, resumed, err
+	}
+	resumed.LastSeq = last
+	if since >= last {
+		// Nothing missed — or the counter was reset and since is meaningless.
+		resumed.Truncated = since > last
+		return nil, resumed, nil
+	}
+
+	entries, err := h.redis.XRangeN(ctx, streamKey(fleetID),
+		"0-"+strconv.FormatInt(since+1, 10), "+", maxReplayEvents).Result()
+	if err != nil {
+		return nil, resumed, err
+	}
+
+	events := make([]streamEvent, 0, len(entries))
+	for _, e := range entries {
+		evt := streamEvent{
+			Type:      EventType(str(e.Values["type"])),
+			VehicleID: str(e.Values["vehicle_id"]),
+			Severity:  str(e.Values["severity"]),
+			Payload:   str(e.Values["payload"]),
+		}
+		fmt.Sscanf(e.ID, "0-%d", &evt.Seq)
+		events = append(events, evt)
+	}
+
+	resumed.Replayed = len(events)
+	switch {
+	case len(events) == 0:
+		resumed.Truncated = true
+	case events[0].Seq != since+1:
+		resumed.Truncated = true
+	case events[len(events)-1].Seq < last && len(events) == maxReplayEvents:
+		resumed.Truncated = true
+	}
+	return events, resumed, nil
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// startReplay puts the client into catch-up: live sequenced events are held
+// in pending while the missed ones are read, so nothing is lost or repeated
+// at the seam.
+func (h *Hub) startReplay(ctx context.Context, c *Client, since int64) {
+	c.catchingUp = true
+	c.pending = c.pending[:0]
+	go func() {
+		rctx, cancel := context.WithTimeout(ctx, appendTimeout)
+		defer cancel()
+		events, resumed, err := h.readReplay(rctx, c.fleetID, since)
+		select {
+		case h.replayed <- replayResult{client: c, events: events, resumed: resumed, err: err}:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// finishReplay runs on the hub goroutine: replayed events first, then
+// whatever arrived live during the read, then the client is live again.
+func (h *Hub) finishReplay(res replayResult) {
+	c := res.client
+	fleet, ok := h.fleets[c.fleetID]
+	if !ok || !hasClient(fleet, c) {
+		return
+	}
+	c.catchingUp = false
+
+	if res.err != nil {
+		data, _ := json.Marshal(newErrorEvent("replay unavailable: " + res.err.Error()))
+		c.enqueue(outbound{data: data})
+		res.resumed.Truncated = true
+	}
+
+	for _, evt := range res.events {
+		if evt.Seq <= c.lastSeq || !c.filter.matches(evt.meta()) {
+			continue
+		}
+		data, err := evt.encode()
+		if err != nil {
+			continue
+		}
+		if !h.deliver(fleet, c, outbound{data: data, seq: evt.Seq}) {
+			return
+		}
+		c.lastSeq = evt.Seq
+	}
+
+	data, _ := json.Marshal(newResumedEvent(res.resumed))
+	if !h.deliver(fleet, c, outbound{data: data}) {
+		return
+	}
+
+	pending := c.pending
+	c.pending = nil
+	for _, p := range pending {
+		if p.seq <= c.lastSeq {
+			continue
+		}
+		if !h.deliver(fleet, c, p.out) {
+			return
+		}
+		c.lastSeq = p.seq
+	}
+}
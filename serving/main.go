@@ -12,12 +12,19 @@ import (
 
 	"github.com/joho/godotenv"
 
+	"fleet-monitor/serving/internal/archive"
 	"fleet-monitor/serving/internal/auth"
 	"fleet-monitor/serving/internal/config"
+	"fleet-monitor/serving/internal/geocode"
 	"fleet-monitor/serving/internal/handler"
 	"fleet-monitor/serving/internal/jobs"
+	"fleet-monitor/serving/internal/leader"
+	"fleet-monitor/serving/internal/metrics"
 	"fleet-monitor/serving/internal/middleware"
+	"fleet-monitor/serving/internal/oidc"
+	"fleet-monitor/serving/internal/scheduler"
 	"fleet-monitor/serving/internal/store"
+	"fleet-monitor/serving/internal/tracing"
 	"fleet-monitor/serving/internal/ws"
 )
 
@@ -29,6 +36,17 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		ServiceName:  "fleet-serving",
+		Exporter:     cfg.TracingExporter,
+		OTLPEndpoint: cfg.TracingOTLPEndpoint,
+		SampleRatio:  cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("Tracing: %v", err)
+	}
+	fmt.Printf("✓ Tracing ready (exporter=%s)\n", cfg.TracingExporter)
+
 	tsStore, err := store.NewTimescaleStore(ctx, cfg)
 	if err != nil {
 		log.Fatalf("TimescaleDB: %v", err)
@@ -47,99 +65,182 @@ func main() {
 		ValidAPIKeys:    cfg.ValidAPIKeys,
 		CacheTTLSeconds: cfg.AuthCacheTTLSeconds,
 	}, redisStore.Client())
+	go authenticator.WatchRevocations(ctx)
 	fmt.Println("✓ Authenticator ready")
 
-	authMW := middleware.Auth(authenticator)
+	// Operator tokens — the built-in issuer verifies its own tokens in-process;
+	// an external issuer is trusted via discovery/JWKS.
+	var (
+		localIssuer   *oidc.LocalIssuer
+		tokenVerifier *oidc.Verifier
+	)
+	switch {
+	case cfg.OIDCLocalIssuer:
+		localIssuer, err = oidc.NewLocalIssuer(oidc.LocalConfig{
+			Issuer:           cfg.OIDCIssuerURL,
+			Audience:         cfg.OIDCAudience,
+			SigningKeyFile:   cfg.OIDCLocalSigningKey,
+			EphemeralKey:     cfg.OIDCLocalEphemeral,
+			TokenTTL:         time.Duration(cfg.OIDCTokenTTLSeconds) * time.Second,
+			MaxLoginFailures: cfg.OIDCLoginMaxFailures,
+			LockoutPeriod:    time.Duration(cfg.OIDCLoginLockoutSeconds) * time.Second,
+			LoginsPerMinute:  cfg.OIDCLoginsPerMinute,
+		}, tsStore.Pool(), redisStore.Client())
+		if err != nil {
+			log.Fatalf("OIDC: %v", err)
+		}
+		tokenVerifier = oidc.NewVerifier(cfg.OIDCIssuerURL, cfg.OIDCAudience, localIssuer)
+		fmt.Printf("✓ Local OIDC issuer ready (%s)\n", cfg.OIDCIssuerURL)
+	case cfg.OIDCIssuerURL != "":
+		tokenVerifier = oidc.NewVerifier(cfg.OIDCIssuerURL, cfg.OIDCAudience, oidc.NewRemoteKeys(cfg.OIDCIssuerURL))
+		fmt.Printf("✓ Operator tokens trusted from %s\n", cfg.OIDCIssuerURL)
+	}
+
+	authMW := middleware.Auth(authenticator, tokenVerifier)
 
-	hub := ws.NewHub(redisStore.Client(), authenticator)
-	go hub.Run(ctx)
-	fmt.Println("✓ WebSocket hub started")
+	// The hub outlives the signal context so it can drain sessions on
+	// shutdown; hubCtx is cancelled once the drain finishes.
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	hub := ws.NewHub(redisStore.Client(), authenticator, ws.Config{
+		Tokens:         tokenVerifier,
+		AllowedOrigins: cfg.WSAllowedOrigins,
+	})
+	go hub.Run(hubCtx)
+	fmt.Printf("✓ WebSocket hub started (instance %s)\n", hub.InstanceID())
+
+	// Reverse geocoding is optional — without it, positions stay bare
+	// coordinates.
+	var geocoder *geocode.Geocoder
+	if cfg.GeocoderProvider != "" {
+		provider, err := geocode.NewProvider(geocode.Config{
+			Provider:        cfg.GeocoderProvider,
+			OfflineFile:     cfg.GeocoderOfflineFile,
+			HTTPURL:         cfg.GeocoderHTTPURL,
+			HTTPUserAgent:   cfg.GeocoderHTTPUserAgent,
+			HTTPMinInterval: time.Duration(cfg.GeocoderHTTPMinIntervalMs) * time.Millisecond,
+		})
+		if err != nil {
+			log.Fatalf("Geocoder: %v", err)
+		}
+		geocoder = geocode.NewGeocoder(provider, redisStore.Client(),
+			time.Duration(cfg.GeocoderCacheTTLSeconds)*time.Second, cfg.GeocoderCachePrecision)
+		fmt.Printf("✓ Reverse geocoding enabled (%s)\n", cfg.GeocoderProvider)
+	}
 
 	// ── Background jobs ───────────────────────────────────────────────────────
 
-	go jobs.NewHeartbeatMonitor(
-		redisStore.Client(), tsStore.Pool(), hub,
-		cfg.HeartbeatIntervalSeconds, cfg.StalenessThresholdSeconds,
-	).Run(ctx)
-	fmt.Println("✓ Heartbeat monitor started")
+	// Every replica campaigns for every job; each runs on one replica at a
+	// time and moves within a lease TTL when its leader dies.
+	elector := leader.NewElector(redisStore.Client(), tsStore.Pool(), hub.InstanceID(),
+		time.Duration(cfg.LeaderLeaseSeconds)*time.Second)
 
-	go jobs.NewDeviationDetector(
+	sched := scheduler.New(redisStore.Client(), tsStore.Pool(), elector, cfg.JobHistoryDays)
+	sched.Register(jobs.NewHeartbeatMonitor(
+		redisStore.Client(), tsStore.Pool(), hub, geocoder,
+		cfg.HeartbeatIntervalSeconds, cfg.StalenessThresholdSeconds,
+	).Job())
+	sched.Register(jobs.NewDeviationDetector(
 		redisStore.Client(), tsStore.Pool(), hub,
 		cfg.DeviationDetectorIntervalSeconds,
-	).Run(ctx)
-	fmt.Println("✓ Deviation detector started")
-
-	go jobs.NewStopDetector(
+	).Job())
+	sched.Register(jobs.NewStopDetector(
 		redisStore.Client(), tsStore.Pool(), hub,
 		cfg.StopDetectorIntervalSeconds,
-	).Run(ctx)
-	fmt.Println("✓ Stop detector started")
-
-	go jobs.NewETAEstimator(
-		redisStore.Client(), tsStore.Pool(),
+	).Job())
+	sched.Register(jobs.NewUnplannedStopDetector(
+		redisStore.Client(), tsStore.Pool(), hub,
 		cfg.StopDetectorIntervalSeconds,
-	).Run(ctx)
-	fmt.Println("✓ ETA estimator started")
+		cfg.UnplannedStopMinMinutes, cfg.UnplannedStopAlertMinutes, cfg.UnplannedStopRadiusMeters,
+	).Job())
+	sched.Register(jobs.NewETAEstimator(
+		redisStore.Client(), tsStore.Pool(), hub,
+		cfg.StopDetectorIntervalSeconds, cfg.DelayAlertMarginSeconds,
+	).Job())
+	sched.Register(jobs.NewSegmentLearner(
+		tsStore.Pool(),
+		cfg.SegmentLearnerIntervalSeconds, cfg.SegmentHistoryDays,
+	).Job())
+
+	// Cold-storage archive is optional — without it, history stops at the
+	// retention horizon.
+	var telemetryArchive *archive.Archive
+	if cfg.ArchiveBackend != "" {
+		objectStore, err := archive.NewObjectStore(ctx, archive.Config{
+			Backend:     cfg.ArchiveBackend,
+			LocalDir:    cfg.ArchiveLocalDir,
+			S3Endpoint:  cfg.ArchiveS3Endpoint,
+			S3Bucket:    cfg.ArchiveS3Bucket,
+			S3AccessKey: cfg.ArchiveS3AccessKey,
+			S3SecretKey: cfg.ArchiveS3SecretKey,
+			S3UseSSL:    cfg.ArchiveS3UseSSL,
+		})
+		if err != nil {
+			log.Fatalf("Archive: %v", err)
+		}
+		telemetryArchive = archive.New(objectStore)
+
+		sched.Register(jobs.NewTelemetryArchiver(
+			tsStore.Pool(), telemetryArchive,
+			cfg.ArchiveIntervalSeconds, cfg.ArchiveLeadHours,
+		).Job())
+		fmt.Printf("✓ Telemetry archiver registered (%s)\n", cfg.ArchiveBackend)
+	}
+
+	go sched.Run(ctx)
+	fmt.Printf("✓ Background jobs campaigning for leadership (lease %ds)\n", cfg.LeaderLeaseSeconds)
 
 	// ── Handlers ─────────────────────────────────────────────────────────────
 
-	healthHandler    := handler.NewHealthHandler(tsStore, redisStore)
+	healthHandler    := handler.NewHealthHandler(tsStore, redisStore, hub, elector)
 	analyticsHandler := handler.NewAnalyticsHandler(redisStore.Client(), tsStore.Pool())
-	vehicleHandler   := handler.NewVehicleHandler(redisStore.Client(), tsStore.Pool())
-	alertHandler     := handler.NewAlertHandler(redisStore.Client(), tsStore.Pool())
+	vehicleHandler   := handler.NewVehicleHandler(redisStore.Client(), tsStore.Pool(), telemetryArchive, geocoder)
+	nearbyHandler    := handler.NewNearbyHandler(redisStore.Client(), tsStore.Pool())
+	fleetHandler     := handler.NewFleetVehicleHandler(redisStore.Client(), tsStore.Pool())
+	alertHandler     := handler.NewAlertHandler(redisStore.Client(), tsStore.Pool(), geocoder)
 	tripHandler      := handler.NewTripHandler(redisStore.Client(), tsStore.Pool())
+	adminHandler     := handler.NewAdminHandler(tsStore.Pool())
+	jobHandler       := handler.NewJobHandler(sched)
+	sessionHandler   := handler.NewSessionHandler(hub)
+	keyHandler       := handler.NewKeyHandler(auth.NewKeyStore(redisStore.Client()), tsStore.Pool())
+
+	owners := store.NewOwnership(tsStore.Pool(), redisStore.Client())
 
 	mux := http.NewServeMux()
+	router := middleware.NewRouter(mux, authMW)
 
-	mux.HandleFunc("GET /health", healthHandler.Handle)
-	mux.HandleFunc("GET /ws", hub.ServeWS)
+	router.Public("GET /health", healthHandler.Handle)
+	router.Public("GET /metrics", metrics.HandleMetrics)
+	router.Public("GET /ws", hub.ServeWS)
 
-	mux.Handle("GET /api/v1/whoami",
-		authMW(http.HandlerFunc(whoamiHandler)),
-	)
-	mux.Handle("GET /api/v1/fleet/{fleet_id}/ping",
-		authMW(
-			middleware.FleetScoped("fleet_id")(
-				http.HandlerFunc(fleetPingHandler),
-			),
-		),
-	)
+	if localIssuer != nil {
+		router.Public("GET /.well-known/openid-configuration", localIssuer.HandleDiscovery)
+		router.Public("GET /oauth/jwks", localIssuer.HandleJWKS)
+		router.Public("POST /oauth/token", localIssuer.HandleToken)
+	}
+
+	api := apiHandlers{
+		hub:       hub,
+		analytics: analyticsHandler,
+		vehicle:   vehicleHandler,
+		nearby:    nearbyHandler,
+		fleet:     fleetHandler,
+		alert:     alertHandler,
+		trip:      tripHandler,
+		admin:     adminHandler,
+		job:       jobHandler,
+		session:   sessionHandler,
+		key:       keyHandler,
+	}
+	for _, rt := range apiRoutes(api, owners) {
+		router.Handle(rt.pattern, rt.role, rt.scope, rt.handler)
+	}
 
-	mux.Handle("GET /api/v1/analytics/summary",
-		authMW(http.HandlerFunc(analyticsHandler.HandleSummary)))
-
-	mux.Handle("GET /api/v1/vehicles/{vehicle_id}/panel",
-		authMW(http.HandlerFunc(vehicleHandler.HandlePanel)))
-	mux.Handle("GET /api/v1/vehicles/{vehicle_id}/active-trip",
-		authMW(http.HandlerFunc(vehicleHandler.HandleActiveTrip)))
-	mux.Handle("GET /api/v1/vehicles/{vehicle_id}/alerts",
-		authMW(http.HandlerFunc(vehicleHandler.HandleVehicleAlerts)))
-
-	mux.Handle("GET /api/v1/alerts",
-		authMW(http.HandlerFunc(alertHandler.HandleAttentionQueue)))
-	mux.Handle("GET /api/v1/alerts/{alert_id}",
-		authMW(http.HandlerFunc(alertHandler.HandleAlertDetail)))
-	mux.Handle("POST /api/v1/alerts/{alert_id}/acknowledge",
-		authMW(http.HandlerFunc(alertHandler.HandleAcknowledge)))
-	mux.Handle("POST /api/v1/alerts/{alert_id}/resolve",
-		authMW(http.HandlerFunc(alertHandler.HandleResolve)))
-	mux.Handle("POST /api/v1/alerts/{alert_id}/unacknowledge",
-		authMW(http.HandlerFunc(alertHandler.HandleUnacknowledge)))
-	mux.Handle("GET /api/v1/fleet/{fleet_id}/alerts",
-		authMW(
-			middleware.FleetScoped("fleet_id")(
-				http.HandlerFunc(alertHandler.HandleFleetAlertHistory),
-			),
-		))
-
-	mux.Handle("GET /api/v1/trips",
-		authMW(http.HandlerFunc(tripHandler.HandleList)))
-	mux.Handle("GET /api/v1/trips/{trip_id}",
-		authMW(http.HandlerFunc(tripHandler.HandleDetail)))
+	fmt.Printf("✓ %d routes registered, all with a declared fleet scope\n", len(router.Routes()))
 
 	srv := &http.Server{
 		Addr:    ":" + cfg.HTTPPort,
-		Handler: mux,
+		Handler: metrics.Instrument(mux),
 	}
 
 	go func() {
@@ -152,31 +253,47 @@ func main() {
 	<-ctx.Done()
 	fmt.Println("\nShutting down...")
 
+	drainGrace := time.Duration(cfg.WSDrainSeconds) * time.Second
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainGrace+2*time.Second)
+	hub.Drain(drainCtx, drainGrace)
+	cancelDrain()
+	stopHub()
+
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Printf("Shutdown error: %v", err)
 	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("Tracing shutdown error: %v", err)
+	}
 
 	fmt.Println("Done.")
 }
 
 func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	p       := middleware.PrincipalFromContext(r.Context())
 	apiKey  := middleware.APIKeyFromContext(r.Context())
-	fleetID := middleware.FleetIDFromContext(r.Context())
 
-	keySource := "redis"
-	if fleetID == "" {
-		keySource = "static_config"
+	keySource := ""
+	if p.Method == "api_key" {
+		keySource = "redis"
+		if p.FleetID == "" {
+			keySource = "static_config"
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"api_key":    apiKey,
-		"fleet_id":   fleetID,
-		"key_source": keySource,
-		"message":    "auth passed",
+		"subject":     p.Subject,
+		"name":        p.Name,
+		"role":        string(p.Role),
+		"auth_method": p.Method,
+		"api_key":     apiKey,
+		"fleet_id":    p.FleetID,
+		"key_source":  keySource,
+		"message":     "auth passed",
 	})
 }
 
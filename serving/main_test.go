@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"fleet-monitor/serving/internal/handler"
+	"fleet-monitor/serving/internal/middleware"
+	"fleet-monitor/serving/internal/oidc"
+)
+
+const (
+	testIssuer   = "https://issuer.test"
+	testAudience = "fleet-monitor"
+	ownFleet     = "fleet-a"
+	foreignFleet = "fleet-b"
+)
+
+type staticKey struct{ key *rsa.PublicKey }
+
+func (k staticKey) PublicKey(context.Context, string) (*rsa.PublicKey, error) {
+	return k.key, nil
+}
+
+// staticKeys accepts one unscoped static config key.
+type staticKeys struct{}
+
+const testStaticKey = "static-key"
+
+func (staticKeys) Validate(_ context.Context, key string) bool { return key == testStaticKey }
+func (staticKeys) FleetID(_ context.Context, key string) (string, bool) {
+	return "", key == testStaticKey
+}
+
+// owners resolves "a-…" IDs to ownFleet and "b-…" IDs to foreignFleet.
+type owners struct{}
+
+func (owners) OwnerFleet(_ context.Context, _, id string) (string, error) {
+	switch {
+	case strings.HasPrefix(id, "a-"):
+		return ownFleet, nil
+	case strings.HasPrefix(id, "b-"):
+		return foreignFleet, nil
+	}
+	return "", nil
+}
+
+type routeTest struct {
+	t      *testing.T
+	key    *rsa.PrivateKey
+	router *middleware.Router
+	mux    *http.ServeMux
+	real   *http.ServeMux // handler-scoped routes with their real handlers
+
+	reached *http.Request // last request that got past the middleware
+}
+
+func newRouteTest(t *testing.T) *routeTest {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &routeTest{t: t, key: key, mux: http.NewServeMux(), real: http.NewServeMux()}
+	auth := middleware.Auth(staticKeys{}, oidc.NewVerifier(testIssuer, testAudience, staticKey{&key.PublicKey}))
+
+	// Every route gets a stub that records the request, so the scope is
+	// what is under test and no store is needed.
+	rt.router = middleware.NewRouter(rt.mux, auth)
+	for _, r := range apiRoutes(apiHandlers{}, owners{}) {
+		rt.router.Handle(r.pattern, r.role, r.scope, func(w http.ResponseWriter, req *http.Request) {
+			rt.reached = req
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+
+	// Handler-scoped routes check the fleet themselves; register the real
+	// handlers, which must reject a foreign fleet before touching a store.
+	real := middleware.NewRouter(rt.real, auth)
+	api := apiHandlers{key: handler.NewKeyHandler(nil, nil)}
+	for _, r := range apiRoutes(api, owners{}) {
+		if strings.HasPrefix(scopeOf(rt.router, r.pattern), "handler:") {
+			real.Handle(r.pattern, r.role, r.scope, r.handler)
+		}
+	}
+	return rt
+}
+
+func scopeOf(router *middleware.Router, pattern string) string {
+	for _, ri := range router.Routes() {
+		if ri.Pattern == pattern {
+			return ri.Scope
+		}
+	}
+	return ""
+}
+
+func (rt *routeTest) token(role oidc.Role, fleetID string) string {
+	now := time.Now()
+	claims := &oidc.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Subject:   "op-1",
+			Audience:  jwt.ClaimStrings{testAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Role:    role,
+		FleetID: fleetID,
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(rt.key)
+	if err != nil {
+		rt.t.Fatal(err)
+	}
+	return signed
+}
+
+// do sends method target as role in ownFleet and returns the status and
+// whether the request reached the handler.
+func (rt *routeTest) do(mux *http.ServeMux, role oidc.Role, method, target, body string) (int, *http.Request) {
+	fleetID := ownFleet
+	if role == oidc.RoleSuperAdmin {
+		fleetID = ""
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+rt.token(role, fleetID))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	rt.reached = nil
+	mux.ServeHTTP(rec, req)
+	return rec.Code, rt.reached
+}
+
+var patternParam = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// path fills in a pattern's parameters with IDs owned by fleet — ownFleet
+// for "a", foreignFleet for "b".
+func path(pattern, fleet string) (method, target string) {
+	method, target, _ = strings.Cut(pattern, " ")
+	target = patternParam.ReplaceAllStringFunc(target, func(m string) string {
+		switch m {
+		case "{fleet_id}":
+			if fleet == "a" {
+				return ownFleet
+			}
+			return foreignFleet
+		case "{job}":
+			return "heartbeat-monitor"
+		}
+		return fleet + "-1"
+	})
+	return method, target
+}
+
+// seenFleet is the fleet a handler would act on for this request.
+func seenFleet(r *http.Request) string {
+	if v := r.PathValue("fleet_id"); v != "" {
+		return v
+	}
+	return r.URL.Query().Get("fleet_id")
+}
+
+func TestRoutesEnforceFleetScope(t *testing.T) {
+	rt := newRouteTest(t)
+
+	routes := rt.router.Routes()
+	if len(routes) != len(apiRoutes(apiHandlers{}, owners{})) {
+		t.Fatalf("registered %d routes, table has %d", len(routes), len(apiRoutes(apiHandlers{}, owners{})))
+	}
+
+	for _, ri := range routes {
+		ri := ri
+		t.Run(ri.Pattern, func(t *testing.T) {
+			hasFleetParam := strings.Contains(ri.Pattern, "{fleet_id}")
+
+			switch {
+			case ri.Scope == "fleet" && hasFleetParam:
+				method, own := path(ri.Pattern, "a")
+				_, foreign := path(ri.Pattern, "b")
+				rt.expect(t, "same fleet", ri.Role, method, own, "", http.StatusOK, ownFleet)
+				rt.expect(t, "foreign fleet", ri.Role, method, foreign, "", http.StatusNotFound, "")
+				rt.expect(t, "query override", ri.Role, method, foreign+"?fleet_id="+ownFleet, "", http.StatusBadRequest, "")
+				rt.expect(t, "query disagrees", ri.Role, method, own+"?fleet_id="+foreignFleet, "", http.StatusBadRequest, "")
+
+			case ri.Scope == "fleet":
+				method, target := path(ri.Pattern, "a")
+				rt.expect(t, "same fleet", ri.Role, method, target+"?fleet_id="+ownFleet, "", http.StatusOK, ownFleet)
+				rt.expect(t, "foreign fleet", ri.Role, method, target+"?fleet_id="+foreignFleet, "", http.StatusNotFound, "")
+				rt.expect(t, "query override", ri.Role, method,
+					target+"?fleet_id="+foreignFleet+"&fleet_id="+ownFleet, "", http.StatusNotFound, "")
+				rt.expect(t, "missing fleet", ri.Role, method, target, "", http.StatusBadRequest, "")
+
+			case strings.HasPrefix(ri.Scope, "owned:"):
+				method, own := path(ri.Pattern, "a")
+				_, foreign := path(ri.Pattern, "b")
+				rt.expect(t, "same fleet", ri.Role, method, own, "{}", http.StatusOK, "")
+				rt.expect(t, "foreign fleet", ri.Role, method, foreign, "{}", http.StatusNotFound, "")
+				rt.expect(t, "query override", ri.Role, method, foreign+"?fleet_id="+ownFleet, "{}", http.StatusNotFound, "")
+
+			case ri.Scope == "caller_fleet":
+				method, target := path(ri.Pattern, "a")
+				rt.expect(t, "same fleet", ri.Role, method, target, "", http.StatusOK, ownFleet)
+				rt.expect(t, "explicit same fleet", ri.Role, method, target+"?fleet_id="+ownFleet, "", http.StatusOK, ownFleet)
+				rt.expect(t, "foreign fleet", ri.Role, method, target+"?fleet_id="+foreignFleet, "", http.StatusNotFound, "")
+				rt.expect(t, "query override", ri.Role, method,
+					target+"?fleet_id="+foreignFleet+"&fleet_id="+ownFleet, "", http.StatusNotFound, "")
+
+			case strings.HasPrefix(ri.Scope, "handler:"):
+				method, target := path(ri.Pattern, "a")
+				rt.expect(t, "same fleet", ri.Role, method, target, `{"fleet_id":"`+ownFleet+`"}`, http.StatusOK, "")
+				rt.expectReal(t, "foreign fleet", ri.Role, method, target, `{"fleet_id":"`+foreignFleet+`"}`, http.StatusNotFound)
+				rt.expectReal(t, "query override", ri.Role, method, target+"?fleet_id="+ownFleet,
+					`{"fleet_id":"`+foreignFleet+`"}`, http.StatusNotFound)
+
+			case ri.Scope == "platform":
+				method, target := path(ri.Pattern, "a")
+				rt.expect(t, "super admin", oidc.RoleSuperAdmin, method, target, "", http.StatusOK, "")
+				rt.expect(t, "fleet admin", oidc.RoleFleetAdmin, method, target, "", http.StatusForbidden, "")
+				rt.expect(t, "fleet admin with fleet", oidc.RoleFleetAdmin, method, target+"?fleet_id="+ownFleet, "", http.StatusForbidden, "")
+
+			case ri.Scope == "self":
+				method, target := path(ri.Pattern, "a")
+				rt.expect(t, "caller", ri.Role, method, target, "", http.StatusOK, "")
+
+			default:
+				t.Fatalf("no test for scope %q", ri.Scope)
+			}
+
+			if ri.Role != oidc.RoleViewer && ri.Scope != "platform" {
+				method, target := path(ri.Pattern, "a")
+				rt.expect(t, "below role", oidc.RoleViewer, method, target+"?fleet_id="+ownFleet, "{}", http.StatusForbidden, "")
+			}
+
+			method, target := path(ri.Pattern, "a")
+			req := httptest.NewRequest(method, target, nil)
+			rec := httptest.NewRecorder()
+			rt.mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("unauthenticated: got %d, want 401", rec.Code)
+			}
+		})
+	}
+}
+
+// expect checks a request against the stub router. wantFleet, when set, is
+// the fleet the handler must see.
+func (rt *routeTest) expect(t *testing.T, name string, role oidc.Role, method, target, body string, want int, wantFleet string) {
+	t.Helper()
+	code, reached := rt.do(rt.mux, role, method, target, body)
+	if code != want {
+		t.Errorf("%s: %s %s: got %d, want %d", name, method, target, code, want)
+		return
+	}
+	if (want == http.StatusOK) != (reached != nil) {
+		t.Errorf("%s: %s %s: reached handler = %v", name, method, target, reached != nil)
+		return
+	}
+	if reached != nil && wantFleet != "" && seenFleet(reached) != wantFleet {
+		t.Errorf("%s: %s %s: handler sees fleet %q, want %q", name, method, target, seenFleet(reached), wantFleet)
+	}
+}
+
+// expectReal checks a request against the real handler of a
+// handler-scoped route.
+func (rt *routeTest) expectReal(t *testing.T, name string, role oidc.Role, method, target, body string, want int) {
+	t.Helper()
+	if code, _ := rt.do(rt.real, role, method, target, body); code != want {
+		t.Errorf("%s: %s %s: got %d, want %d", name, method, target, code, want)
+	}
+}
+
+// Static config keys are shared, so they must stay read-only: no dispatcher,
+// key-management or platform route accepts one.
+func TestStaticKeysAreReadOnly(t *testing.T) {
+	rt := newRouteTest(t)
+	for _, ri := range rt.router.Routes() {
+		method, target := path(ri.Pattern, "a")
+		if ri.Scope == "fleet" && !strings.Contains(ri.Pattern, "{fleet_id}") {
+			target += "?fleet_id=" + ownFleet
+		}
+		req := httptest.NewRequest(method, target, strings.NewReader(`{"fleet_id":"`+ownFleet+`"}`))
+		req.Header.Set("X-API-Key", testStaticKey)
+		rec := httptest.NewRecorder()
+		rt.mux.ServeHTTP(rec, req)
+
+		want := http.StatusOK
+		if ri.Role != oidc.RoleViewer {
+			want = http.StatusForbidden
+		}
+		if rec.Code != want {
+			t.Errorf("%s: got %d, want %d", ri.Pattern, rec.Code, want)
+		}
+	}
+}
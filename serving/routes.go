@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+
+	"fleet-monitor/serving/internal/handler"
+	"fleet-monitor/serving/internal/middleware"
+	"fleet-monitor/serving/internal/oidc"
+	"fleet-monitor/serving/internal/ws"
+)
+
+// apiHandlers is everything the authenticated routes dispatch to.
+type apiHandlers struct {
+	hub       *ws.Hub
+	analytics *handler.AnalyticsHandler
+	vehicle   *handler.VehicleHandler
+	nearby    *handler.NearbyHandler
+	fleet     *handler.FleetVehicleHandler
+	alert     *handler.AlertHandler
+	trip      *handler.TripHandler
+	admin     *handler.AdminHandler
+	job       *handler.JobHandler
+	session   *handler.SessionHandler
+	key       *handler.KeyHandler
+}
+
+// apiRoute is one authenticated route and its access policy.
+type apiRoute struct {
+	pattern string
+	role    oidc.Role
+	scope   middleware.Scope
+	handler http.HandlerFunc
+}
+
+// apiRoutes is the table of authenticated routes. Every route declares a
+// minimum role and how the fleet boundary is enforced; the router refuses
+// to start on a gap, and main_test.go drives each one across fleets.
+func apiRoutes(h apiHandlers, owners middleware.OwnerResolver) []apiRoute {
+	vehicleScope := middleware.OwnedBy(owners, middleware.KindVehicle, "vehicle_id")
+	alertScope   := middleware.OwnedBy(owners, middleware.KindAlert, "alert_id")
+	tripScope    := middleware.OwnedBy(owners, middleware.KindTrip, "trip_id")
+	keyScope     := middleware.OwnedBy(owners, middleware.KindAPIKey, "key_id")
+	fleetScope   := middleware.FleetParam("fleet_id")
+
+	return []apiRoute{
+		{"GET /api/v1/whoami", oidc.RoleViewer, middleware.Self(), whoamiHandler},
+		{"GET /api/v1/fleet/{fleet_id}/ping", oidc.RoleViewer, fleetScope, fleetPingHandler},
+		{"GET /api/v1/fleet/{fleet_id}/events", oidc.RoleViewer, fleetScope, h.hub.ServeSSE},
+		{"GET /api/v1/fleet/{fleet_id}/watchers", oidc.RoleDispatcher, fleetScope, h.session.HandleWatchers},
+		{"POST /api/v1/fleet/{fleet_id}/messages", oidc.RoleDispatcher, fleetScope, h.session.HandleMessage},
+
+		{"GET /api/v1/fleet/{fleet_id}/analytics", oidc.RoleViewer, fleetScope, h.analytics.HandleSummary},
+		{"GET /api/v1/fleet/{fleet_id}/analytics/timeseries", oidc.RoleViewer, fleetScope, h.analytics.HandleTimeseries},
+		{"GET /api/v1/fleet/{fleet_id}/analytics/on-time", oidc.RoleViewer, fleetScope, h.analytics.HandleOnTime},
+		{"GET /api/v1/fleet/{fleet_id}/analytics/dwell", oidc.RoleViewer, fleetScope, h.analytics.HandleDwell},
+		{"GET /api/v1/fleet/{fleet_id}/analytics/unplanned-stops", oidc.RoleViewer, fleetScope, h.analytics.HandleUnplannedStops},
+
+		{"GET /api/v1/fleet/{fleet_id}/vehicles", oidc.RoleViewer, fleetScope, h.fleet.HandleList},
+		{"GET /api/v1/fleet/{fleet_id}/vehicles/live", oidc.RoleViewer, fleetScope, h.fleet.HandleLive},
+		{"GET /api/v1/fleet/{fleet_id}/vehicles/nearby", oidc.RoleViewer, fleetScope, h.nearby.HandleNearby},
+		{"GET /api/v1/fleet/{fleet_id}/vehicles/nearby/history", oidc.RoleViewer, fleetScope, h.nearby.HandleNearbyHistory},
+
+		{"GET /api/v1/vehicles/{vehicle_id}/panel", oidc.RoleViewer, vehicleScope, h.vehicle.HandlePanel},
+		{"GET /api/v1/vehicles/{vehicle_id}/active-trip", oidc.RoleViewer, vehicleScope, h.vehicle.HandleActiveTrip},
+		{"GET /api/v1/vehicles/{vehicle_id}/alerts", oidc.RoleViewer, vehicleScope, h.vehicle.HandleVehicleAlerts},
+		{"GET /api/v1/vehicles/{vehicle_id}/telemetry", oidc.RoleViewer, vehicleScope, h.vehicle.HandleTelemetryHistory},
+
+		{"GET /api/v1/alerts", oidc.RoleViewer, fleetScope, h.alert.HandleAttentionQueue},
+		{"GET /api/v1/alerts/{alert_id}", oidc.RoleViewer, alertScope, h.alert.HandleAlertDetail},
+		{"POST /api/v1/alerts/{alert_id}/acknowledge", oidc.RoleDispatcher, alertScope, h.alert.HandleAcknowledge},
+		{"POST /api/v1/alerts/{alert_id}/resolve", oidc.RoleDispatcher, alertScope, h.alert.HandleResolve},
+		{"POST /api/v1/alerts/{alert_id}/unacknowledge", oidc.RoleDispatcher, alertScope, h.alert.HandleUnacknowledge},
+		{"GET /api/v1/fleet/{fleet_id}/alerts", oidc.RoleViewer, fleetScope, h.alert.HandleFleetAlertHistory},
+
+		{"GET /api/v1/trips", oidc.RoleViewer, middleware.CallerFleetFilter("fleet_id"), h.trip.HandleList},
+		{"GET /api/v1/trips/{trip_id}", oidc.RoleViewer, tripScope, h.trip.HandleDetail},
+		{"PUT /api/v1/trips/{trip_id}/windows", oidc.RoleDispatcher, tripScope, h.trip.HandleSetWindows},
+
+		{"GET /api/v1/admin/storage", oidc.RoleSuperAdmin, middleware.Platform(), h.admin.HandleStorage},
+		{"GET /api/v1/admin/jobs", oidc.RoleSuperAdmin, middleware.Platform(), h.job.HandleList},
+		{"GET /api/v1/admin/jobs/{job}/runs", oidc.RoleSuperAdmin, middleware.Platform("job"), h.job.HandleRuns},
+		{"POST /api/v1/admin/jobs/{job}/pause", oidc.RoleSuperAdmin, middleware.Platform("job"), h.job.HandlePause},
+		{"POST /api/v1/admin/jobs/{job}/resume", oidc.RoleSuperAdmin, middleware.Platform("job"), h.job.HandleResume},
+		{"POST /api/v1/admin/jobs/{job}/trigger", oidc.RoleSuperAdmin, middleware.Platform("job"), h.job.HandleTrigger},
+		{"POST /api/v1/admin/keys", oidc.RoleFleetAdmin, middleware.HandlerScoped("body fleet_id"), h.key.HandleIssue},
+		{"GET /api/v1/admin/keys", oidc.RoleFleetAdmin, middleware.CallerFleetFilter("fleet_id"), h.key.HandleList},
+		{"POST /api/v1/admin/keys/{key_id}/rotate", oidc.RoleFleetAdmin, keyScope, h.key.HandleRotate},
+		{"DELETE /api/v1/admin/keys/{key_id}", oidc.RoleFleetAdmin, keyScope, h.key.HandleRevoke},
+	}
+}